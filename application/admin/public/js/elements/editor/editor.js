@@ -91,6 +91,41 @@ export class GcHtmlEditor extends LitElement {
         }
     }
 
+    // Opens the file picker, remembering where the image should be inserted
+    pickImage() {
+        const selection = this.shadowRoot.getSelection();
+        this._imageRange = selection.rangeCount ? selection.getRangeAt(0) : null;
+        this.shadowRoot.querySelector('input[type="file"]').click();
+    }
+
+    // Uploads the picked image to the media library and inserts it at the remembered position
+    async uploadImage(event) {
+        const file = event.target.files[0];
+        event.target.value = '';
+        if (!file) return;
+
+        const body = new FormData();
+        body.append('file', file);
+        const csrf = document.querySelector('input[name="_CSRF"]')?.value ?? '';
+        const response = await fetch('/api/v1/media', { method: 'POST', body, headers: { 'X-CSRF-Token': csrf } });
+        const media = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            alert(media.error ?? 'The image could not be uploaded.');
+            return;
+        }
+
+        this.shadowRoot.querySelector('gc-html-edit-window')?.focus();
+        if (this._imageRange) {
+            const selection = this.shadowRoot.getSelection();
+            selection.removeAllRanges();
+            selection.addRange(this._imageRange);
+        }
+        const image = document.createElement('img');
+        image.src = media.url;
+        image.alt = media.alt_text ?? '';
+        document.execCommand('insertHTML', false, image.outerHTML);
+    }
+
     setInlineStyle(event) {
         let style = event.target.value;
         if (style === 'none') {
@@ -142,6 +177,8 @@ export class GcHtmlEditor extends LitElement {
                         <div class="group">
                             <button @click="${this.do('formatBlock', 'P')}">¶</button>
                             <button @click="${this.doAsk('createLink', 'Where do you wish the link to go to?')}">A</button>
+                            <button @click="${this.pickImage}" title="Upload an image">Image</button>
+                            <input type="file" accept="image/*" hidden @change="${this.uploadImage}">
                         </div>
                         <div class="group">
                             <select @change="${this.setInlineStyle}">
@@ -47,7 +47,11 @@ export class Table extends LitElement {
 
     setPage(event) {
         let page = event.target.value * this.count
-        window.location = "?offset=" + page + "&count=" + this.count
+        // Keep any other query parameters, such as filters, when changing page
+        let query = new URLSearchParams(window.location.search)
+        query.set("offset", page)
+        query.set("count", this.count)
+        window.location = "?" + query.toString()
     }
 
     static properties = {
@@ -1,46 +1,76 @@
 package main
 
 import (
-	"time"
+	"flag"
+	"os"
 
 	"github.com/gojicms/goji/core"
 	"github.com/gojicms/goji/core/config"
 	"github.com/gojicms/goji/core/utils"
 	"github.com/gojicms/goji/core/utils/log"
 	_ "github.com/mattn/go-sqlite3"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 // This represents a standard installation of Goji
 func main() {
-	// Prepares the server
+	// Defaults for a standard installation, which the config file and environment may override. DB_DSN is
+	// still honoured for installs that predate GOJI_DATABASE_DSN.
+	defaults := &config.ActiveConfig.Application
+	defaults.Host = "0.0.0.0"
+	// If true, certain features will be enabled to help track bugs down
+	defaults.Debug = true
+	defaults.LogLevel = log.LogError | log.LogWarn | log.LogInfo | log.LogVerbose
+	defaults.Database.DSN = utils.GetEnv("DB_DSN", "file:application.db?cache=shared&mode=rwc")
+
+	// Loads the config file named by GOJI_CONFIG (see goji.sample.yaml) along with any GOJI_* environment
+	// variables; every invalid setting is reported at once.
+	if err := config.Load(utils.GetEnv("GOJI_CONFIG", "")); err != nil {
+		log.Fatal(log.RCInvalidAppInvocation, "Application", "%v", err)
+	}
+
+	// Prepares the server; configuration provided here takes precedence over the config file and environment.
+	// Zero values, such as false or 0, are ignored here, so set those through the defaults above instead.
 	core.PrepareServer(config.ApplicationConfig{
-		Host: "0.0.0.0",
 		// This determines the route for web content
 		RootUrl: "",
 		// And this determines the route for APIs
 		ApiRootUrl: "/api/v1",
-		// If true, certain features will be enabled to help track bugs down
-		Debug: true,
-		// Sets a maximum size for templates; any templates that exceed this will not be processed
-		TemplateFileSizeLimit: 1024 * 1024 * 10,
-		// Sets the logs that are enabled
-		LogLevel: log.LogError | log.LogWarn | log.LogInfo | log.LogVerbose,
-		// Configure how authentication works
-		Auth: config.AuthConfig{
-			// This identifies the name for auth cookies
-			CookieLifetime:  time.Hour,
-			RefreshLifetime: time.Minute * 45,
-		},
-		// Configure a basic SQLite Database; Not ideal for production... perhaps?
-		Database: config.DatabaseConfig{
-			Connector: func() gorm.Dialector {
-				return sqlite.Open(utils.GetEnv("DB_DSN", "file:application.db?cache=shared&mode=rwc"))
-			},
-		},
 	})
 
-	// Start server
-	core.StartServer()
+	// Optionally manage migrations rather than serving:
+	//   goji migrate [-dry-run]
+	//   goji rollback [-steps 1] [-dry-run] <service>
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
+	// Start server; this blocks until the server is shut down
+	if err := core.StartServer(); err != nil {
+		log.Fatal(log.RCUnknownError, "Application", "Server stopped with an error: %v", err)
+	}
+}
+
+func runCommand(command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list the migrations that would run without applying them")
+	steps := flags.Int("steps", 1, "the number of migrations to roll back")
+	_ = flags.Parse(args)
+
+	var err error
+	switch command {
+	case "migrate":
+		err = core.MigrateServices(*dryRun)
+	case "rollback":
+		if flags.NArg() != 1 {
+			log.Fatal(log.RCInvalidAppInvocation, "Application", "Usage: goji rollback [-steps 1] [-dry-run] <service>")
+		}
+		err = core.RollbackService(flags.Arg(0), *steps, *dryRun)
+	default:
+		log.Fatal(log.RCInvalidAppInvocation, "Application", "Unknown command %s; expected migrate or rollback", command)
+	}
+
+	if err != nil {
+		log.Fatal(log.RCDatabase, "Application", "%v", err)
+	}
 }
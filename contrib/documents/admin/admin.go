@@ -2,8 +2,12 @@ package admin
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gojicms/goji/contrib/documents/documents"
 	"github.com/gojicms/goji/core/extend"
@@ -19,6 +23,9 @@ var listTemplate []byte
 //go:embed editor.gohtml
 var editorTemplate []byte
 
+// inputTimeLayout is the format of datetime-local inputs
+const inputTimeLayout = "2006-01-02T15:04"
+
 func adminListing(flow *httpflow.HttpFlow) ([]byte, error) {
 	flow.Append("templateData", "title", "Goji - Documents")
 
@@ -28,15 +35,27 @@ func adminListing(flow *httpflow.HttpFlow) ([]byte, error) {
 	offsetInt := Stoid(offset, 0)
 	countInt := Stoid(count, 10)
 
-	items, _ := documents.Get(countInt, offsetInt, "updated_at desc")
-	totalItems, _ := documents.Count()
+	// Searches list the best matches first, with the text that matched
+	query := strings.TrimSpace(flow.Request.URL.Query().Get("q"))
+	var items []documents.SearchResult
+	var totalItems int64
+	if query != "" {
+		items, totalItems, _ = documents.Search(query, documents.Filter{}, countInt, offsetInt)
+	} else {
+		docs, _ := documents.Get(countInt, offsetInt, "updated_at desc")
+		for _, doc := range docs {
+			items = append(items, documents.SearchResult{Document: doc})
+		}
+		totalItems, _ = documents.Count()
+	}
 
 	return server.RenderTemplate(listTemplate, Object{
 		"items":      items,
 		"totalItems": totalItems,
 		"offset":     offsetInt,
 		"count":      countInt,
-	}, server.DefaultRenderOptions)
+		"query":      query,
+	}, server.RenderOptions{Flow: flow})
 }
 
 func newDocEditor(flow *httpflow.HttpFlow) ([]byte, error) {
@@ -58,17 +77,31 @@ func newDocEditor(flow *httpflow.HttpFlow) ([]byte, error) {
 		title := flow.PostFormValue("title")
 		content := flow.PostFormValue("content")
 
+		document.Title = title
+		document.Slug = flow.PostFormValue("slug")
+		document.Content = content
+		document.CreatedBy = user
+
 		if title == "" {
 			result["status"] = "error"
 			result["message"] = "Title cannot be empty."
 			goto render
 		}
 
-		document.Title = title
-		document.Content = content
-		document.CreatedBy = user
+		if err := applyPublishing(flow, &document); err != nil {
+			result["status"] = "error"
+			result["message"] = "Failed to save document: " + err.Error()
+			goto render
+		}
 
-		doc, err := documents.Create(document)
+		terms, err := formTerms(flow)
+		if err != nil {
+			result["status"] = "error"
+			result["message"] = "Failed to save document: " + err.Error()
+			goto render
+		}
+
+		doc, err := documents.Create(document, terms)
 
 		if err != nil {
 			result["status"] = "error"
@@ -84,10 +117,7 @@ func newDocEditor(flow *httpflow.HttpFlow) ([]byte, error) {
 	}
 
 render:
-	return server.RenderTemplate(editorTemplate, Object{
-		"document": document,
-		"result":   result,
-	}, server.DefaultRenderOptions)
+	return renderEditor(flow, &document, result)
 
 }
 
@@ -122,16 +152,44 @@ func editDocEditor(flow *httpflow.HttpFlow) ([]byte, error) {
 				goto render
 			}
 
+			if !flow.Get("user").(*users.User).HasPermission("document:edit") {
+				result["status"] = "error"
+				result["message"] = "You do not have permission to edit documents."
+				goto render
+			}
+
 			document.Title = title
+			document.Slug = flow.PostFormValue("slug")
 			document.Content = content
 
-			_, err = documents.Update(*document)
+			if err := applyPublishing(flow, document); err != nil {
+				result["status"] = "error"
+				result["message"] = "Failed to save document: " + err.Error()
+				goto render
+			}
+
+			terms, err := formTerms(flow)
 			if err != nil {
 				result["status"] = "error"
 				result["message"] = "Failed to save document: " + err.Error()
+				goto render
 			}
+
+			_, err = documents.Update(*document, flow.Get("user").(*users.User), terms)
+			if err != nil {
+				result["status"] = "error"
+				result["message"] = "Failed to save document: " + err.Error()
+				goto render
+			}
+			document, _ = documents.GetById(id)
 			break
 		case "delete":
+			if !flow.Get("user").(*users.User).HasPermission("document:delete") {
+				result["status"] = "error"
+				result["message"] = "You do not have permission to delete documents."
+				goto render
+			}
+
 			_, err := documents.DeleteById(id)
 			if err != nil {
 				result["status"] = "error"
@@ -144,28 +202,160 @@ func editDocEditor(flow *httpflow.HttpFlow) ([]byte, error) {
 		}
 	}
 render:
+	return renderEditor(flow, document, result)
+}
+
+// renderEditor renders the document editor, offering the statuses the current user may choose
+func renderEditor(flow *httpflow.HttpFlow, document *documents.Document, result Object) ([]byte, error) {
+	user := flow.Get("user").(*users.User)
+
+	categories, err := documents.GetTerms(documents.TaxonomyCategory)
+	if err != nil {
+		return nil, err
+	}
+
 	return server.RenderTemplate(editorTemplate, Object{
-		"document": document,
-		"result":   result,
-	}, server.DefaultRenderOptions)
+		"document":   document,
+		"result":     result,
+		"statuses":   documents.Statuses,
+		"canPublish": user.HasPermission("document:publish"),
+		"categories": categories,
+	}, server.RenderOptions{
+		Flow: flow,
+		Functions: template.FuncMap{
+			// inputTime formats a time for a datetime-local input
+			"inputTime": func(t *time.Time) string {
+				if t == nil {
+					return ""
+				}
+				return t.Local().Format(inputTimeLayout)
+			},
+		},
+	})
+}
+
+// applyPublishing reads the status and schedule of the editor form into the document. Publishing and
+// archiving, or changing a published or archived document, requires document:publish.
+func applyPublishing(flow *httpflow.HttpFlow, document *documents.Document) error {
+	status, err := documents.ParseStatus(flow.PostFormValue("status"))
+	if err != nil {
+		return err
+	}
+
+	publishedAt, err := parseInputTime(flow.PostFormValue("published_at"))
+	if err != nil {
+		return fmt.Errorf("invalid publish time: %w", err)
+	}
+	unpublishAt, err := parseInputTime(flow.PostFormValue("unpublish_at"))
+	if err != nil {
+		return fmt.Errorf("invalid unpublish time: %w", err)
+	}
+
+	user := flow.Get("user").(*users.User)
+	restricted := func(s documents.Status) bool {
+		return s == documents.StatusPublished || s == documents.StatusArchived
+	}
+	if (restricted(status) || restricted(document.Status)) && !user.HasPermission("document:publish") {
+		if status != document.Status {
+			return errors.New("publishing or archiving documents requires the document:publish permission")
+		}
+		// Without document:publish, the schedule of a published document is left untouched
+		return nil
+	}
+
+	document.Status = status
+	document.PublishedAt = publishedAt
+	document.UnpublishAt = unpublishAt
+	return nil
+}
+
+// formTerms reads the categories and tags of the editor form, which are assigned as the document is saved;
+// tags are entered by name, separated by commas, and are created if they do not exist and the user may add
+// terms
+func formTerms(flow *httpflow.HttpFlow) (*documents.TermAssignment, error) {
+	categories := []documents.Term{}
+	for _, id := range flow.Request.PostForm["categories"] {
+		category, err := documents.GetTermById(id)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, *category)
+	}
+
+	user := flow.Get("user").(*users.User)
+	return &documents.TermAssignment{
+		Categories: categories,
+		Tags:       strings.Split(flow.PostFormValue("tags"), ","),
+		CreateTags: user.HasPermission("term:add"),
+	}, nil
+}
+
+func parseInputTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(inputTimeLayout, value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
 
 func Init() {
 	extend.AddSideMenuItem("Documents", "docs", 10, "", "document:view")
 	extend.AddSideMenuItem("Create", "docs/new", 10, "Documents", "document:add")
+	extend.AddSideMenuItem("Categories", "docs/categories", 15, "Documents", "term:view")
+	extend.AddSideMenuItem("Tags", "docs/tags", 16, "Documents", "term:view")
+	extend.AddSideMenuItem("Settings", "docs/settings", 20, "Documents", "document:configure")
+
+	extend.AddAdminPage(extend.AdminPage{
+		Route:      "docs",
+		Render:     adminListing,
+		Permission: "document:view",
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Route:      "docs/new",
+		Render:     newDocEditor,
+		Permission: "document:add",
+	})
+
+	for _, taxonomy := range documents.Taxonomies {
+		page := taxonomyPages[taxonomy]
+		extend.AddAdminPage(extend.AdminPage{
+			Route:      "docs/" + page.Path,
+			Render:     termsListing(taxonomy),
+			Permission: "term:view",
+		})
+
+		extend.AddAdminPage(extend.AdminPage{
+			Route:      "docs/" + page.Path + "/{id}",
+			Render:     termEditor(taxonomy),
+			Permission: "term:view",
+		})
+	}
+
+	extend.AddAdminPage(extend.AdminPage{
+		Route:      "docs/settings",
+		Render:     documentSettings,
+		Permission: "document:configure",
+	})
 
 	extend.AddAdminPage(extend.AdminPage{
-		Route:  "docs",
-		Render: adminListing,
+		Route:      "docs/{id}",
+		Render:     editDocEditor,
+		Permission: "document:view",
 	})
 
 	extend.AddAdminPage(extend.AdminPage{
-		Route:  "docs/new",
-		Render: newDocEditor,
+		Route:      "docs/{id}/revisions",
+		Render:     revisionsListing,
+		Permission: "document:view",
 	})
 
 	extend.AddAdminPage(extend.AdminPage{
-		Route:  "docs/{id}",
-		Render: editDocEditor,
+		Route:      "docs/{id}/revisions/{revision}",
+		Render:     revisionViewer,
+		Permission: "document:view",
 	})
 }
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gojicms/goji/contrib/documents/documents"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/services/site"
+	. "github.com/gojicms/goji/core/utils"
+)
+
+//go:embed revisions.gohtml
+var revisionsTemplate []byte
+
+//go:embed revision.gohtml
+var revisionTemplate []byte
+
+//go:embed settings.gohtml
+var settingsTemplate []byte
+
+func revisionsListing(flow *httpflow.HttpFlow) ([]byte, error) {
+	flow.Append("templateData", "title", "Goji - Document Revisions")
+
+	document, err := documents.GetById(flow.GetKvp("admin_meta", "id"))
+	if err != nil || document.ID == 0 {
+		return []byte("<b>Document not found</b>"), nil
+	}
+
+	revisions, err := documents.GetRevisions(document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.RenderTemplate(revisionsTemplate, Object{
+		"document":  document,
+		"revisions": revisions,
+	}, server.RenderOptions{Flow: flow})
+}
+
+func revisionViewer(flow *httpflow.HttpFlow) ([]byte, error) {
+	flow.Append("templateData", "title", "Goji - Document Revision")
+
+	document, err := documents.GetById(flow.GetKvp("admin_meta", "id"))
+	if err != nil || document.ID == 0 {
+		return []byte("<b>Document not found</b>"), nil
+	}
+
+	number := Stoid(flow.GetKvp("admin_meta", "revision"), 0)
+	revision, err := documents.GetRevision(document.ID, number)
+	if err != nil {
+		return []byte("<b>Revision not found</b>"), nil
+	}
+
+	result := Object{
+		"status":  nil,
+		"message": nil,
+	}
+
+	if flow.Request.Method == "POST" && flow.PostFormValue("action") == "restore" {
+		user := flow.Get("user").(*users.User)
+		if !user.HasPermission("document:edit") {
+			result["status"] = "error"
+			result["message"] = "You do not have permission to restore revisions."
+		} else if _, err := documents.Restore(revision, user); err != nil {
+			result["status"] = "error"
+			result["message"] = "Failed to restore revision: " + err.Error()
+		} else {
+			flow.SetHeader("Location", fmt.Sprintf("/admin/docs/%d", document.ID))
+			flow.WriteHeaders(http.StatusFound)
+			return []byte{}, nil
+		}
+	}
+
+	// Compare against the previous revision, which may have been pruned
+	previous, _ := documents.GetRevision(document.ID, number-1)
+	if previous == nil {
+		previous = &documents.Revision{}
+	}
+
+	mode := flow.Request.URL.Query().Get("mode")
+	left, right := previous.Content, revision.Content
+	if mode == "text" {
+		left, right = documents.HtmlToText(left), documents.HtmlToText(right)
+	} else {
+		mode = "html"
+		left, right = documents.HtmlToLines(left), documents.HtmlToLines(right)
+	}
+
+	return server.RenderTemplate(revisionTemplate, Object{
+		"document": document,
+		"revision": revision,
+		"previous": previous,
+		"mode":     mode,
+		"title":    documents.SideBySide(previous.Title, revision.Title),
+		"content":  documents.SideBySide(left, right),
+		"result":   result,
+	}, server.RenderOptions{Flow: flow})
+}
+
+func documentSettings(flow *httpflow.HttpFlow) ([]byte, error) {
+	flow.Append("templateData", "title", "Goji - Document Settings")
+
+	result := Object{
+		"status":  nil,
+		"message": nil,
+	}
+
+	if flow.Request.Method == "POST" {
+		limit, limitErr := strconv.Atoi(OrDefault(flow.PostFormValue("revision_limit"), "0"))
+		days, daysErr := strconv.Atoi(OrDefault(flow.PostFormValue("revision_max_age_days"), "0"))
+		if limitErr != nil || daysErr != nil || limit < 0 || days < 0 {
+			result["status"] = "error"
+			result["message"] = "Retention limits must be whole numbers of zero or more."
+		} else {
+			site.SetSiteConfig(documents.RevisionLimitKey, strconv.Itoa(limit))
+			site.SetSiteConfig(documents.RevisionMaxAgeKey, strconv.Itoa(days))
+
+			pruned, err := documents.PruneAllRevisions(documents.GetRetentionPolicy())
+			if err != nil {
+				result["status"] = "error"
+				result["message"] = "Settings saved, but pruning revisions failed: " + err.Error()
+			} else {
+				result["status"] = "success"
+				result["message"] = fmt.Sprintf("Settings saved; %d revisions were pruned.", pruned)
+			}
+		}
+	}
+
+	policy := documents.GetRetentionPolicy()
+	return server.RenderTemplate(settingsTemplate, Object{
+		"revisionLimit":  policy.MaxRevisions,
+		"revisionMaxAge": int(policy.MaxAge.Hours() / 24),
+		"result":         result,
+	}, server.RenderOptions{Flow: flow})
+}
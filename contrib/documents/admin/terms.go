@@ -0,0 +1,161 @@
+package admin
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+
+	"github.com/gojicms/goji/contrib/documents/documents"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	. "github.com/gojicms/goji/core/utils"
+)
+
+//go:embed terms.gohtml
+var termsTemplate []byte
+
+//go:embed term.gohtml
+var termTemplate []byte
+
+// taxonomyPages are the admin paths and titles of each taxonomy
+var taxonomyPages = map[documents.Taxonomy]struct {
+	Path  string
+	Title string
+	Name  string
+}{
+	documents.TaxonomyCategory: {"categories", "Categories", "Category"},
+	documents.TaxonomyTag:      {"tags", "Tags", "Tag"},
+}
+
+func termsListing(taxonomy documents.Taxonomy) func(flow *httpflow.HttpFlow) ([]byte, error) {
+	page := taxonomyPages[taxonomy]
+
+	return func(flow *httpflow.HttpFlow) ([]byte, error) {
+		flow.Append("templateData", "title", "Goji - "+page.Title)
+
+		term := documents.Term{Taxonomy: taxonomy}
+
+		result := Object{
+			"status":  nil,
+			"message": nil,
+		}
+
+		if flow.Request.Method == "POST" {
+			user := flow.Get("user").(*users.User)
+			if !user.HasPermission("term:add") {
+				result["status"] = "error"
+				result["message"] = fmt.Sprintf("You do not have permission to create %s.", page.Title)
+				goto render
+			}
+
+			readTerm(flow, &term)
+			if _, err := documents.SaveTerm(term); err != nil {
+				result["status"] = "error"
+				result["message"] = fmt.Sprintf("Failed to create %s: %s", page.Name, err.Error())
+				goto render
+			}
+
+			result["status"] = "success"
+			result["message"] = page.Name + " created."
+			term = documents.Term{Taxonomy: taxonomy}
+		}
+
+	render:
+		terms, err := documents.GetTerms(taxonomy)
+		if err != nil {
+			return nil, err
+		}
+
+		return server.RenderTemplate(termsTemplate, Object{
+			"page":     page,
+			"taxonomy": taxonomy,
+			"term":     term,
+			"terms":    terms,
+			"result":   result,
+		}, server.RenderOptions{Flow: flow})
+	}
+}
+
+func termEditor(taxonomy documents.Taxonomy) func(flow *httpflow.HttpFlow) ([]byte, error) {
+	page := taxonomyPages[taxonomy]
+
+	return func(flow *httpflow.HttpFlow) ([]byte, error) {
+		flow.Append("templateData", "title", "Goji - Edit "+page.Name)
+
+		term, err := documents.GetTermById(flow.GetKvp("admin_meta", "id"))
+		if err != nil || term.Taxonomy != taxonomy {
+			return []byte("<b>" + page.Name + " not found</b>"), nil
+		}
+
+		result := Object{
+			"status":  nil,
+			"message": nil,
+		}
+
+		if flow.Request.Method == "POST" {
+			user := flow.Get("user").(*users.User)
+
+			switch flow.PostFormValue("action") {
+			case "save":
+				if !user.HasPermission("term:edit") {
+					result["status"] = "error"
+					result["message"] = fmt.Sprintf("You do not have permission to edit %s.", page.Title)
+					goto render
+				}
+
+				readTerm(flow, term)
+				saved, err := documents.SaveTerm(*term)
+				if err != nil {
+					result["status"] = "error"
+					result["message"] = fmt.Sprintf("Failed to save %s: %s", page.Name, err.Error())
+					goto render
+				}
+				term = saved
+				result["status"] = "success"
+				result["message"] = page.Name + " saved."
+			case "delete":
+				if !user.HasPermission("term:delete") {
+					result["status"] = "error"
+					result["message"] = fmt.Sprintf("You do not have permission to delete %s.", page.Title)
+					goto render
+				}
+
+				if err := documents.DeleteTerm(term); err != nil {
+					result["status"] = "error"
+					result["message"] = fmt.Sprintf("Failed to delete %s: %s", page.Name, err.Error())
+					goto render
+				}
+				flow.SetHeader("Location", "/admin/docs/"+page.Path)
+				flow.WriteHeaders(http.StatusFound)
+				return []byte{}, nil
+			}
+		}
+
+	render:
+		terms, err := documents.GetTerms(taxonomy)
+		if err != nil {
+			return nil, err
+		}
+
+		return server.RenderTemplate(termTemplate, Object{
+			"page":     page,
+			"taxonomy": taxonomy,
+			"term":     term,
+			"terms":    terms,
+			"result":   result,
+		}, server.RenderOptions{Flow: flow})
+	}
+}
+
+// readTerm reads the fields of the term form into the term
+func readTerm(flow *httpflow.HttpFlow, term *documents.Term) {
+	term.Name = flow.PostFormValue("name")
+	term.Slug = flow.PostFormValue("slug")
+	term.Description = flow.PostFormValue("description")
+
+	term.ParentId = nil
+	if parent := uint(Stoid(flow.PostFormValue("parent_id"), 0)); parent != 0 {
+		term.ParentId = &parent
+	}
+}
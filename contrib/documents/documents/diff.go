@@ -0,0 +1,157 @@
+package documents
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// maxDiffLines bounds the size of a line diff; larger inputs are shown as a full replacement
+const maxDiffLines = 4000
+
+// DiffOp describes how a line differs between two versions
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+	DiffChange DiffOp = "change"
+)
+
+// DiffRow is a row of a side-by-side diff; Left or Right is empty where a line was inserted or deleted
+type DiffRow struct {
+	Op    DiffOp
+	Left  string
+	Right string
+}
+
+var (
+	blockEnd = regexp.MustCompile(`(?i)</(p|div|h[1-6]|li|blockquote|pre|tr)>|<br\s*/?>`)
+	anyTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// HtmlToText reduces HTML content to plain text, keeping one line per block element
+func HtmlToText(content string) string {
+	text := blockEnd.ReplaceAllString(content, "\n")
+	text = anyTag.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HtmlToLines breaks HTML content after each block element, so its source may be compared line by line
+func HtmlToLines(content string) string {
+	return blockEnd.ReplaceAllString(content, "$0\n")
+}
+
+// SideBySide compares two texts line by line, pairing deleted and inserted lines as changes so they may be
+// shown next to each other
+func SideBySide(left string, right string) []DiffRow {
+	a := splitLines(left)
+	b := splitLines(right)
+
+	var rows []DiffRow
+	var deleted, inserted []string
+	flush := func() {
+		for i := 0; i < max(len(deleted), len(inserted)); i++ {
+			row := DiffRow{Op: DiffChange}
+			if i < len(deleted) {
+				row.Left = deleted[i]
+			} else {
+				row.Op = DiffInsert
+			}
+			if i < len(inserted) {
+				row.Right = inserted[i]
+			} else {
+				row.Op = DiffDelete
+			}
+			rows = append(rows, row)
+		}
+		deleted, inserted = nil, nil
+	}
+
+	for _, edit := range diffLines(a, b) {
+		switch edit.op {
+		case DiffEqual:
+			flush()
+			rows = append(rows, DiffRow{Op: DiffEqual, Left: edit.line, Right: edit.line})
+		case DiffDelete:
+			deleted = append(deleted, edit.line)
+		case DiffInsert:
+			inserted = append(inserted, edit.line)
+		}
+	}
+	flush()
+	return rows
+}
+
+type lineEdit struct {
+	op   DiffOp
+	line string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script between a and b from their longest common subsequence
+func diffLines(a []string, b []string) []lineEdit {
+	var edits []lineEdit
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			edits = append(edits, lineEdit{DiffDelete, line})
+		}
+		for _, line := range b {
+			edits = append(edits, lineEdit{DiffInsert, line})
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, lineEdit{DiffEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, lineEdit{DiffDelete, a[i]})
+			i++
+		default:
+			edits = append(edits, lineEdit{DiffInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, lineEdit{DiffDelete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, lineEdit{DiffInsert, b[j]})
+	}
+	return edits
+}
@@ -1,21 +1,89 @@
 package documents
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/services/auth/users"
 	"github.com/gojicms/goji/core/utils/log"
 	"gorm.io/gorm"
 )
 
+// Status is the stage of a document in the publishing workflow
+type Status string
+
+const (
+	StatusDraft         Status = "draft"
+	StatusPendingReview Status = "pending_review"
+	StatusPublished     Status = "published"
+	StatusArchived      Status = "archived"
+)
+
+// Statuses lists every status in workflow order
+var Statuses = []Status{StatusDraft, StatusPendingReview, StatusPublished, StatusArchived}
+
 type Document struct {
 	gorm.Model
 	Title       string      `json:"title" gorm:"size:255"`
+	Slug        string      `json:"slug" gorm:"size:255;uniqueIndex"`
 	Description string      `json:"description" gorm:"size:1000"`
 	Content     string      `json:"content" gorm:"type:text"`
 	CreatedBy   *users.User `json:"-" gorm:"foreignKey:CreatedById;constraint:OnUpdate:NO ACTION,OnDelete:SET NULL;"`
 	CreatedById *uint       `json:"-" gorm:"index"`
+	Status      Status      `json:"status" gorm:"size:32;index;default:draft"`
+	// PublishedAt is when a published document becomes visible; a future time schedules the document
+	PublishedAt *time.Time `json:"published_at" gorm:"index"`
+	// UnpublishAt is when a published document is hidden again and archived; nil keeps it published
+	UnpublishAt *time.Time `json:"unpublish_at" gorm:"index"`
+	// Announced is set once EventPublished has been published for the document becoming visible
+	Announced bool `json:"-" gorm:"index;default:false"`
+	// Terms are the categories and tags of the document; they are assigned with SetTerms or a TermAssignment
+	Terms []Term `json:"terms" gorm:"many2many:document_terms;"`
+}
+
+var (
+	// EventSaving is published before a document is created or updated; listeners may modify or veto it
+	EventSaving  = extend.NewEvent[*Document]("document.saving")
+	EventCreated = extend.NewEvent[*Document]("document.created")
+	EventUpdated = extend.NewEvent[*Document]("document.updated")
+	EventDeleted = extend.NewEvent[*Document]("document.deleted")
+	// EventPublished is published when a document becomes visible: when it is saved with the published status
+	// and a publish time that has passed, or by the scheduler once the publish time of a scheduled document
+	// arrives
+	EventPublished = extend.NewEvent[*Document]("document.published")
+)
+
+// FilterContent transforms the content of published documents before they are shown to the public, by
+// templates or the API
+var FilterContent = extend.NewFilter[string]("document.content")
+
+// Filter narrows the documents returned by GetFiltered; empty fields are ignored
+type Filter struct {
+	// Published limits the documents to those visible to the public, ignoring Status
+	Published bool
+	Status    string
+	// Category is the slug of a category; documents in its subcategories are included
+	Category string
+	// Tag is the slug of a tag
+	Tag string
+}
+
+// IsVisible returns true if the document is published and within its publishing window
+func (document *Document) IsVisible() bool {
+	now := time.Now()
+	return document.Status == StatusPublished &&
+		(document.PublishedAt == nil || !document.PublishedAt.After(now)) &&
+		(document.UnpublishAt == nil || document.UnpublishAt.After(now))
+}
+
+// IsScheduled returns true if the document is published with a publish time in the future
+func (document *Document) IsScheduled() bool {
+	return document.Status == StatusPublished && document.PublishedAt != nil && document.PublishedAt.After(time.Now())
 }
 
 // Summary returns a summary of the document content
@@ -34,17 +102,80 @@ func (document *Document) Summary(maxLength int) string {
 }
 
 // Get gets all documents, limited by limit and starting at offset.
-// sort is the field to sort by and which direction, and can be "created_at", "updated_at", or "title", eg. "createdAt DESC" or "title ASC"
-// If sort is empty, it will default to "createdAt DESC"
+// sort is the field to sort by and which direction, and can be "created_at", "updated_at", or "title", eg. "created_at DESC" or "title ASC"
+// If sort is empty, it will default to "created_at DESC"
 func Get(limit int, offset int, sort string) ([]Document, error) {
 	db := database.GetDB()
 	var documents []Document
 
 	if sort == "" {
-		sort = "createdAt DESC"
+		sort = "created_at DESC"
+	}
+
+	res := db.Preload("CreatedBy").Preload("Terms", orderTerms).Limit(limit).Offset(offset).Order(sort).Find(&documents)
+
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get documents: %s", res.Error.Error())
+		return nil, res.Error
+	}
+
+	return documents, nil
+}
+
+// GetByStatus gets documents with the given status, or every document if status is empty; limit, offset
+// and sort are as for Get
+func GetByStatus(status string, limit int, offset int, sort string) ([]Document, error) {
+	if status == "" {
+		return Get(limit, offset, sort)
+	}
+
+	db := database.GetDB()
+	var documents []Document
+
+	if sort == "" {
+		sort = "created_at DESC"
+	}
+
+	res := db.Preload("CreatedBy").Preload("Terms", orderTerms).Where("status = ?", status).Limit(limit).Offset(offset).Order(sort).Find(&documents)
+
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get documents: %s", res.Error.Error())
+		return nil, res.Error
+	}
+
+	return documents, nil
+}
+
+// CountByStatus counts the documents with the given status, or every document if status is empty
+func CountByStatus(status string) (int64, error) {
+	if status == "" {
+		return Count()
+	}
+
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&Document{}).Where("status = ?", status).Count(&count)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to count documents: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// GetFiltered gets the documents matching the filter, limited by limit and starting at offset; sort is as
+// for Get
+func GetFiltered(filter Filter, limit int, offset int, sort string) ([]Document, error) {
+	db := database.GetDB()
+	var documents []Document
+
+	if sort == "" && filter.Published {
+		sort = "published_at DESC"
+	} else if sort == "" {
+		sort = "created_at DESC"
 	}
 
-	res := db.Preload("CreatedBy").Limit(limit).Offset(offset).Order(sort).Find(&documents)
+	res := db.Preload("CreatedBy").Preload("Terms", orderTerms).Scopes(filter.scope).
+		Limit(limit).Offset(offset).Order(sort).Find(&documents)
 
 	if res.Error != nil {
 		log.Error("Documents", "Failed to get documents: %s", res.Error.Error())
@@ -54,13 +185,146 @@ func Get(limit int, offset int, sort string) ([]Document, error) {
 	return documents, nil
 }
 
+// CountFiltered counts the documents matching the filter
+func CountFiltered(filter Filter) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&Document{}).Scopes(filter.scope).Count(&count)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to count documents: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// GetPublished gets the documents that are currently visible to the public, limited by limit and
+// starting at offset; sort is as for Get
+func GetPublished(limit int, offset int, sort string) ([]Document, error) {
+	db := database.GetDB()
+	var documents []Document
+
+	if sort == "" {
+		sort = "published_at DESC"
+	}
+
+	res := published(db.Preload("CreatedBy").Preload("Terms", orderTerms)).Limit(limit).Offset(offset).Order(sort).Find(&documents)
+
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get published documents: %s", res.Error.Error())
+		return nil, res.Error
+	}
+
+	return documents, nil
+}
+
+// GetPublishedById gets a document by id, provided it is currently visible to the public
+func GetPublishedById(id string) (*Document, error) {
+	db := database.GetDB()
+	var document Document
+	res := published(db.Preload("CreatedBy").Preload("Terms", orderTerms)).Where("id = ?", id).Find(&document)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get published document: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	return &document, nil
+}
+
+// CountPublished counts the documents that are currently visible to the public
+func CountPublished() (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := published(db.Model(&Document{})).Count(&count)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to count published documents: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// ArchiveExpired archives every published document whose UnpublishAt has passed
+// Returns the number of documents archived and an error if there is one
+func ArchiveExpired() (int64, error) {
+	db := database.GetDB()
+	res := db.Model(&Document{}).
+		Where("status = ? AND unpublish_at IS NOT NULL AND unpublish_at <= ?", StatusPublished, time.Now()).
+		Update("status", StatusArchived)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to archive expired documents: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
+
+// AnnounceScheduled publishes EventPublished for the published documents that became visible since they
+// were saved. Each document is claimed in the database first, so processes sharing it announce a document
+// only once.
+// Returns the number of documents announced and an error if there is one
+func AnnounceScheduled() (int, error) {
+	db := database.GetDB()
+	now := time.Now()
+
+	var due []Document
+	res := db.Preload("CreatedBy").Preload("Terms", orderTerms).
+		Where("status = ? AND announced = ? AND published_at <= ?", StatusPublished, false, now).
+		Where("unpublish_at IS NULL OR unpublish_at > ?", now).
+		Find(&due)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get scheduled documents: %s", res.Error.Error())
+		return 0, res.Error
+	}
+
+	announced := 0
+	for i := range due {
+		claim := db.Model(&Document{}).Where("id = ? AND announced = ?", due[i].ID, false).UpdateColumn("announced", true)
+		if claim.Error != nil {
+			log.Error("Documents", "Failed to announce document %d: %s", due[i].ID, claim.Error.Error())
+			return announced, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+		due[i].Announced = true
+		EventPublished.Notify(context.Background(), &due[i])
+		announced++
+	}
+	return announced, nil
+}
+
+// NextScheduledChange returns the earliest future time at which a published document becomes visible or
+// is archived, or nil if nothing is scheduled
+func NextScheduledChange() (*time.Time, error) {
+	db := database.GetDB()
+	now := time.Now()
+
+	var next *time.Time
+	for _, column := range []string{"published_at", "unpublish_at"} {
+		var document Document
+		res := db.Where("status = ? AND "+column+" > ?", StatusPublished, now).Order(column).Limit(1).Find(&document)
+		if res.Error != nil {
+			log.Error("Documents", "Failed to get scheduled documents: %s", res.Error.Error())
+			return nil, res.Error
+		}
+		if document.ID == 0 {
+			continue
+		}
+		at := document.PublishedAt
+		if column == "unpublish_at" {
+			at = document.UnpublishAt
+		}
+		if at != nil && (next == nil || at.Before(*next)) {
+			next = at
+		}
+	}
+	return next, nil
+}
+
 // GetById gets a document by id
 // id is the id of the document to get
 // Returns the document and an error if there is one
 func GetById(id string) (*Document, error) {
 	db := database.GetDB()
 	var document Document
-	res := db.Preload("CreatedBy").Where("id = ?", id).Find(&document)
+	res := db.Preload("CreatedBy").Preload("Terms", orderTerms).Where("id = ?", id).Find(&document)
 	if res.Error != nil {
 		log.Error("Documents", "Failed to get document: %s", res.Error.Error())
 		return nil, res.Error
@@ -68,29 +332,52 @@ func GetById(id string) (*Document, error) {
 	return &document, nil
 }
 
-// Create creates a new document
+// Create creates a new document, recording its first revision
 // document is the document to create
 // Returns the created document and an error if there is one
-func Create(document Document) (*Document, error) {
+func Create(document Document, terms *TermAssignment) (*Document, error) {
+	if err := prepare(&document); err != nil {
+		return nil, err
+	}
+	if err := EventSaving.Publish(context.Background(), &document); err != nil {
+		return nil, err
+	}
+	document.Announced = isVisible(&document)
 	db := database.GetDB()
-	res := db.Debug().Create(&document)
-	if res.Error != nil {
-		log.Error("Documents", "Failed to create document: %s", res.Error.Error())
-		return nil, res.Error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := assignSlug(tx, &document); err != nil {
+			return err
+		}
+		if err := tx.Omit("Terms").Create(&document).Error; err != nil {
+			return err
+		}
+		if err := terms.apply(tx, document.ID); err != nil {
+			return err
+		}
+		if err := indexDocument(tx, &document); err != nil {
+			return err
+		}
+		_, err := RecordRevision(tx, &document, document.CreatedBy, "Created")
+		return err
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to create document: %s", err.Error())
+		return nil, err
+	}
+	scheduler.poke()
+
+	EventCreated.Notify(context.Background(), &document)
+	if document.Announced {
+		EventPublished.Notify(context.Background(), &document)
 	}
 	return &document, nil
 }
 
-// Update updates a document
+// Update updates a document, recording a revision attributed to author
 // document is the document to update
 // Returns the updated document and an error if there is one
-func Update(document Document) (*Document, error) {
-	db := database.GetDB()
-	res := db.Save(&document)
-	if res.Error != nil {
-		log.Error("Documents", "Failed to update document: %s", res.Error.Error())
-	}
-	return &document, nil
+func Update(document Document, author *users.User, terms *TermAssignment) (*Document, error) {
+	return save(document, author, "", terms)
 }
 
 // DeleteById deletes a document by id
@@ -98,12 +385,32 @@ func Update(document Document) (*Document, error) {
 // Returns the number of rows affected and an error if there is one
 func DeleteById(id string) (int64, error) {
 	db := database.GetDB()
-	res := db.Where("id = ?", id).Delete(&Document{})
-	if res.Error != nil {
-		log.Error("Documents", "Failed to delete document: %s", res.Error.Error())
-		return 0, res.Error
+	var affected int64
+	var document Document
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Keep a copy of the document for listeners
+		if err := tx.Where("id = ?", id).Find(&document).Error; err != nil {
+			return err
+		}
+		res := tx.Where("id = ?", id).Delete(&Document{})
+		if res.Error != nil {
+			return res.Error
+		}
+		affected = res.RowsAffected
+		// The history of a deleted document goes with it
+		if err := tx.Where("document_id = ?", id).Delete(&Revision{}).Error; err != nil {
+			return err
+		}
+		return unindexDocument(tx, id)
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to delete document: %s", err.Error())
+		return 0, err
 	}
-	return res.RowsAffected, nil
+	if affected > 0 {
+		EventDeleted.Notify(context.Background(), &document)
+	}
+	return affected, nil
 }
 
 // Count counts the number of documents
@@ -132,3 +439,114 @@ func GetByTitle(title string) (*Document, error) {
 	}
 	return &document, nil
 }
+
+// ParseStatus validates a status; an empty status is treated as a draft
+func ParseStatus(s string) (Status, error) {
+	if s == "" {
+		return StatusDraft, nil
+	}
+	for _, status := range Statuses {
+		if string(status) == s {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("invalid status %q; expected draft, pending_review, published or archived", s)
+}
+
+// published limits a query to documents that are currently visible to the public
+func published(db *gorm.DB) *gorm.DB {
+	now := time.Now()
+	return db.Where("status = ?", StatusPublished).
+		Where("published_at IS NULL OR published_at <= ?", now).
+		Where("unpublish_at IS NULL OR unpublish_at > ?", now)
+}
+
+// scope applies the filter to a query
+func (filter Filter) scope(db *gorm.DB) *gorm.DB {
+	if filter.Published {
+		db = published(db)
+	} else if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+	if filter.Category != "" {
+		db = withTerm(TaxonomyCategory, filter.Category)(db)
+	}
+	if filter.Tag != "" {
+		db = withTerm(TaxonomyTag, filter.Tag)(db)
+	}
+	return db
+}
+
+// save updates the document and records a revision in a single transaction, then applies the retention policy
+func save(document Document, author *users.User, note string, terms *TermAssignment) (*Document, error) {
+	if err := prepare(&document); err != nil {
+		return nil, err
+	}
+	if err := EventSaving.Publish(context.Background(), &document); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	// A document that was already announced is not announced again while it stays visible
+	document.Announced = isVisible(&document)
+	var previous []bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Document{}).Where("id = ?", document.ID).Pluck("announced", &previous).Error; err != nil {
+			return err
+		}
+		if err := assignSlug(tx, &document); err != nil {
+			return err
+		}
+		if err := tx.Omit("Terms").Save(&document).Error; err != nil {
+			return err
+		}
+		if err := terms.apply(tx, document.ID); err != nil {
+			return err
+		}
+		if err := indexDocument(tx, &document); err != nil {
+			return err
+		}
+		_, err := RecordRevision(tx, &document, author, note)
+		return err
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to update document: %s", err.Error())
+		return nil, err
+	}
+	scheduler.poke()
+
+	if pruned, err := PruneRevisions(document.ID, GetRetentionPolicy()); err == nil && pruned > 0 {
+		log.Debug("Documents", "Pruned %d revisions of document %d", pruned, document.ID)
+	}
+
+	EventUpdated.Notify(context.Background(), &document)
+	if document.Announced && (len(previous) == 0 || !previous[0]) {
+		EventPublished.Notify(context.Background(), &document)
+	}
+	return &document, nil
+}
+
+// isVisible returns true if a document is published and within its publishing window
+func isVisible(document *Document) bool {
+	now := time.Now()
+	return document.Status == StatusPublished &&
+		document.PublishedAt != nil && !document.PublishedAt.After(now) &&
+		(document.UnpublishAt == nil || document.UnpublishAt.After(now))
+}
+
+// prepare validates the publishing fields of a document before it is saved
+func prepare(document *Document) error {
+	status, err := ParseStatus(string(document.Status))
+	if err != nil {
+		return err
+	}
+	document.Status = status
+
+	if document.Status == StatusPublished && document.PublishedAt == nil {
+		now := time.Now()
+		document.PublishedAt = &now
+	}
+	if document.PublishedAt != nil && document.UnpublishAt != nil && !document.UnpublishAt.After(*document.PublishedAt) {
+		return errors.New("the unpublish time must be after the publish time")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package documents
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/services/site"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+// Site configuration keys for the revision retention policy; both default to 0, keeping every revision
+const (
+	RevisionLimitKey  = "documents.revision_limit"
+	RevisionMaxAgeKey = "documents.revision_max_age_days"
+)
+
+// Revision is an immutable snapshot of a document, recorded each time the document is saved
+type Revision struct {
+	ID          uint        `json:"id" gorm:"primarykey"`
+	DocumentId  uint        `json:"document_id" gorm:"uniqueIndex:idx_document_revision"`
+	Number      int         `json:"number" gorm:"uniqueIndex:idx_document_revision"`
+	Title       string      `json:"title" gorm:"size:255"`
+	Description string      `json:"description" gorm:"size:1000"`
+	Content     string      `json:"content" gorm:"type:text"`
+	Status      Status      `json:"status" gorm:"size:32"`
+	PublishedAt *time.Time  `json:"published_at"`
+	UnpublishAt *time.Time  `json:"unpublish_at"`
+	Note        string      `json:"note" gorm:"size:255"`
+	AuthorId    *uint       `json:"author_id" gorm:"index"`
+	Author      *users.User `json:"-" gorm:"foreignKey:AuthorId;constraint:OnUpdate:NO ACTION,OnDelete:SET NULL;"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// BeforeUpdate prevents revisions from being changed once recorded
+func (revision *Revision) BeforeUpdate(tx *gorm.DB) error {
+	return errors.New("revisions are immutable")
+}
+
+// RetentionPolicy limits the revisions kept for each document; the latest revision is always kept
+type RetentionPolicy struct {
+	// MaxRevisions is the number of revisions kept per document; 0 keeps every revision
+	MaxRevisions int
+	// MaxAge is how long revisions are kept; 0 keeps revisions regardless of age
+	MaxAge time.Duration
+}
+
+// GetRevisions gets the revisions of a document, newest first
+func GetRevisions(documentId uint) ([]Revision, error) {
+	db := database.GetDB()
+	var revisions []Revision
+	res := db.Preload("Author").Where("document_id = ?", documentId).Order("number desc").Find(&revisions)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get revisions: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	return revisions, nil
+}
+
+// GetRevision gets a single revision of a document by its number
+func GetRevision(documentId uint, number int) (*Revision, error) {
+	db := database.GetDB()
+	var revision Revision
+	res := db.Preload("Author").Where("document_id = ? AND number = ?", documentId, number).Limit(1).Find(&revision)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get revision: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	if revision.ID == 0 {
+		return nil, errors.New("revision not found")
+	}
+	return &revision, nil
+}
+
+// Restore copies the title, description and content of a revision back to its document, recording a new
+// revision. The status and schedule of the document are left unchanged.
+func Restore(revision *Revision, author *users.User) (*Document, error) {
+	document, err := GetById(strconv.Itoa(int(revision.DocumentId)))
+	if err != nil {
+		return nil, err
+	}
+	if document.ID == 0 {
+		return nil, errors.New("document not found")
+	}
+
+	document.Title = revision.Title
+	document.Description = revision.Description
+	document.Content = revision.Content
+	return save(*document, author, "Restored revision "+strconv.Itoa(revision.Number), nil)
+}
+
+// GetRetentionPolicy reads the retention policy from the site configuration
+func GetRetentionPolicy() RetentionPolicy {
+	limit, _ := strconv.Atoi(site.GetSiteConfig(RevisionLimitKey))
+	days, _ := strconv.Atoi(site.GetSiteConfig(RevisionMaxAgeKey))
+	return RetentionPolicy{
+		MaxRevisions: max(limit, 0),
+		MaxAge:       time.Duration(max(days, 0)) * 24 * time.Hour,
+	}
+}
+
+// PruneRevisions deletes the revisions of a document that fall outside the policy
+// Returns the number of revisions deleted and an error if there is one
+func PruneRevisions(documentId uint, policy RetentionPolicy) (int64, error) {
+	if policy.MaxRevisions == 0 && policy.MaxAge == 0 {
+		return 0, nil
+	}
+
+	db := database.GetDB()
+	var latest Revision
+	if err := db.Where("document_id = ?", documentId).Order("number desc").Limit(1).Find(&latest).Error; err != nil {
+		return 0, err
+	}
+	if latest.ID == 0 {
+		return 0, nil
+	}
+
+	query := db.Where("document_id = ? AND number <> ?", documentId, latest.Number)
+	if policy.MaxRevisions > 0 && policy.MaxAge > 0 {
+		query = query.Where("number <= ? OR created_at < ?", latest.Number-policy.MaxRevisions, time.Now().Add(-policy.MaxAge))
+	} else if policy.MaxRevisions > 0 {
+		query = query.Where("number <= ?", latest.Number-policy.MaxRevisions)
+	} else {
+		query = query.Where("created_at < ?", time.Now().Add(-policy.MaxAge))
+	}
+
+	res := query.Delete(&Revision{})
+	if res.Error != nil {
+		log.Error("Documents", "Failed to prune revisions: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
+
+// PruneAllRevisions applies the policy to the revisions of every document
+func PruneAllRevisions(policy RetentionPolicy) (int64, error) {
+	db := database.GetDB()
+	var documentIds []uint
+	if err := db.Model(&Revision{}).Distinct("document_id").Pluck("document_id", &documentIds).Error; err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, id := range documentIds {
+		count, err := PruneRevisions(id, policy)
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// RecordRevision snapshots the document as a new revision within tx
+func RecordRevision(tx *gorm.DB, document *Document, author *users.User, note string) (*Revision, error) {
+	var latest int
+	if err := tx.Model(&Revision{}).Where("document_id = ?", document.ID).
+		Select("COALESCE(MAX(number), 0)").Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+
+	revision := Revision{
+		DocumentId:  document.ID,
+		Number:      latest + 1,
+		Title:       document.Title,
+		Description: document.Description,
+		Content:     document.Content,
+		Status:      document.Status,
+		PublishedAt: document.PublishedAt,
+		UnpublishAt: document.UnpublishAt,
+		Note:        note,
+	}
+	if author != nil && author.ID != 0 {
+		revision.AuthorId = &author.ID
+	}
+
+	if err := tx.Omit("Author").Create(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
@@ -0,0 +1,123 @@
+package documents
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+// maxSchedulerInterval bounds how long the scheduler sleeps, so documents scheduled by other processes
+// sharing the database are still handled promptly
+const maxSchedulerInterval = time.Minute
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// publishScheduler archives published documents once their UnpublishAt passes and announces those whose
+// PublishedAt arrives, waking at the next scheduled change. Public queries only return documents whose
+// PublishedAt has passed, so publishing itself needs no action.
+type publishScheduler struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	wake   chan struct{}
+}
+
+var scheduler = &publishScheduler{}
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// StartScheduler starts archiving documents as they reach their unpublish time, and announcing them as they
+// reach their publish time
+func StartScheduler() {
+	scheduler.start()
+}
+
+// StopScheduler stops the scheduler, waiting for it to finish until ctx expires
+func StopScheduler(ctx context.Context) error {
+	return scheduler.stop(ctx)
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func (s *publishScheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.wake = make(chan struct{}, 1)
+	go s.run(ctx)
+}
+
+func (s *publishScheduler) stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poke wakes the scheduler so a newly saved schedule is taken into account
+func (s *publishScheduler) poke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wake == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *publishScheduler) run(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		if archived, err := ArchiveExpired(); err == nil && archived > 0 {
+			log.Info("Documents", "Archived %d documents that reached their unpublish time", archived)
+		}
+		if announced, err := AnnounceScheduled(); err == nil && announced > 0 {
+			log.Info("Documents", "Announced %d documents that reached their publish time", announced)
+		}
+
+		wait := maxSchedulerInterval
+		if next, err := NextScheduledChange(); err == nil && next != nil {
+			// Wake slightly after the change so it is no longer in the future
+			wait = min(wait, time.Until(*next)+time.Second)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
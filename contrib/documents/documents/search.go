@@ -0,0 +1,380 @@
+package documents
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// SearchResult is a document matching a search
+type SearchResult struct {
+	Document
+	// Snippet is an excerpt of the matching text with the matched words wrapped in <mark>
+	Snippet template.HTML `json:"snippet"`
+}
+
+// searchHit is a match found in the full-text index
+type searchHit struct {
+	ID      uint
+	Snippet string
+}
+
+const (
+	searchTable = "document_search"
+	// maxSearchTerms limits the words of a query that are searched for
+	maxSearchTerms = 10
+	// snippetLength is the length of snippets made without the full-text index, in bytes
+	snippetLength = 200
+	// The full-text index marks matches with these, so they can be highlighted after the text is escaped
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// ftsEnabled is true when the database has a full-text index; otherwise searches use LIKE
+var ftsEnabled bool
+
+var (
+	hiddenTags = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)>`)
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+	blockTags  = regexp.MustCompile(`(?i)</?(p|div|h[1-6]|li|ul|ol|br|blockquote|pre|tr|td|th|section|article)\b[^>]*>`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// InitSearch prepares the full-text index, filling it if it is new or out of date. The index is created at
+// startup rather than by a migration because it depends on how Goji was built: SQLite only includes FTS5
+// with the sqlite_fts5 build tag. Other builds and databases search with LIKE queries instead.
+func InitSearch() error {
+	db := database.GetDB()
+	ftsEnabled = false
+
+	if db.Dialector.Name() != "sqlite" {
+		log.Info("Documents", "Searching documents with LIKE queries on %s", db.Dialector.Name())
+		return nil
+	}
+
+	if !db.Migrator().HasTable(searchTable) {
+		err := db.Exec("CREATE VIRTUAL TABLE " + searchTable +
+			" USING fts5(title, description, content, tokenize = 'porter unicode61 remove_diacritics 2')").Error
+		if err != nil {
+			log.Warn("Documents", "Full-text search is unavailable, so documents are searched with LIKE queries; build with -tags sqlite_fts5 to enable it: %s", err.Error())
+			return nil
+		}
+	}
+
+	// The index exists, but SQLite may have been built without FTS5 since it was created
+	var indexed int64
+	if err := db.Table(searchTable).Count(&indexed).Error; err != nil {
+		log.Warn("Documents", "Full-text search is unavailable, so documents are searched with LIKE queries: %s", err.Error())
+		return nil
+	}
+	ftsEnabled = true
+
+	// Documents saved while the index was unavailable are missing from it
+	total, err := Count()
+	if err != nil {
+		return err
+	}
+	if indexed != total {
+		return RebuildSearchIndex()
+	}
+	return nil
+}
+
+// RebuildSearchIndex indexes every document afresh
+func RebuildSearchIndex() error {
+	if !ftsEnabled {
+		return nil
+	}
+
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM " + searchTable).Error; err != nil {
+			return err
+		}
+		var batch []Document
+		return tx.Model(&Document{}).FindInBatches(&batch, 100, func(_ *gorm.DB, _ int) error {
+			for i := range batch {
+				if err := indexDocument(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to rebuild the search index: %s", err.Error())
+		return err
+	}
+	log.Info("Documents", "Rebuilt the search index")
+	return nil
+}
+
+// Search finds the documents matching a query and the filter, best matches first, limited by limit and
+// starting at offset. Every word of the query must match, and the last may be the start of a word.
+// Returns the results and the total number of matches.
+func Search(query string, filter Filter, limit int, offset int) ([]SearchResult, int64, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []SearchResult{}, 0, nil
+	}
+
+	var results []SearchResult
+	var count int64
+	var err error
+	if ftsEnabled {
+		results, count, err = searchIndex(terms, filter, limit, offset)
+	} else {
+		results, count, err = searchLike(terms, filter, limit, offset)
+	}
+	if err != nil {
+		log.Error("Documents", "Failed to search documents: %s", err.Error())
+		return nil, 0, err
+	}
+	return results, count, nil
+}
+
+// PlainText returns the text of the document content without its markup
+func (document Document) PlainText() string {
+	return plainText(document.Content)
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// indexDocument adds a document to the full-text index, replacing any earlier entry
+func indexDocument(tx *gorm.DB, document *Document) error {
+	if !ftsEnabled {
+		return nil
+	}
+	if err := unindexDocument(tx, document.ID); err != nil {
+		return err
+	}
+	return tx.Exec("INSERT INTO "+searchTable+" (rowid, title, description, content) VALUES (?, ?, ?, ?)",
+		document.ID, document.Title, document.Description, plainText(document.Content)).Error
+}
+
+// unindexDocument removes a document from the full-text index
+func unindexDocument(tx *gorm.DB, id any) error {
+	if !ftsEnabled {
+		return nil
+	}
+	return tx.Exec("DELETE FROM "+searchTable+" WHERE rowid = ?", id).Error
+}
+
+// searchIndex searches the full-text index, ranking matches in titles above descriptions and content
+func searchIndex(terms []string, filter Filter, limit int, offset int) ([]SearchResult, int64, error) {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+	quoted[len(quoted)-1] += "*"
+	match := strings.Join(quoted, " ")
+
+	db := database.GetDB()
+	matching := func() *gorm.DB {
+		return db.Model(&Document{}).
+			Joins("JOIN "+searchTable+" ON "+searchTable+".rowid = documents.id").
+			Where(searchTable+" MATCH ?", match).
+			Scopes(filter.scope)
+	}
+
+	var count int64
+	if err := matching().Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var hits []searchHit
+	err := matching().
+		Select("documents.id AS id, snippet("+searchTable+", -1, ?, ?, '…', 24) AS snippet", markStart, markEnd).
+		Order("bm25(" + searchTable + ", 10.0, 4.0, 1.0)").
+		Limit(limit).Offset(offset).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]uint, len(hits))
+	snippets := map[uint]template.HTML{}
+	for i, hit := range hits {
+		ids[i] = hit.ID
+		snippets[hit.ID] = highlightMarks(hit.Snippet)
+	}
+	results, err := loadResults(ids, func(document *Document) template.HTML { return snippets[document.ID] })
+	return results, count, err
+}
+
+// searchLike searches with LIKE queries, ranking matches in titles above descriptions and content. Content
+// is matched including its markup.
+func searchLike(terms []string, filter Filter, limit int, offset int) ([]SearchResult, int64, error) {
+	db := database.GetDB()
+	matching := func() *gorm.DB {
+		query := db.Model(&Document{}).Scopes(filter.scope)
+		for _, term := range terms {
+			like := "%" + escapeLike(term) + "%"
+			query = query.Where("(LOWER(title) LIKE ? ESCAPE '!' OR LOWER(description) LIKE ? ESCAPE '!' OR LOWER(content) LIKE ? ESCAPE '!')",
+				like, like, like)
+		}
+		return query
+	}
+
+	var count int64
+	if err := matching().Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var ids []uint
+	like := "%" + escapeLike(terms[0]) + "%"
+	err := matching().
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "CASE WHEN LOWER(title) LIKE ? ESCAPE '!' THEN 0 WHEN LOWER(description) LIKE ? ESCAPE '!' THEN 1 ELSE 2 END, updated_at DESC",
+			Vars:               []interface{}{like, like},
+			WithoutParentheses: true,
+		}}).
+		Limit(limit).Offset(offset).
+		Pluck("documents.id", &ids).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pattern := termPattern(terms)
+	results, err := loadResults(ids, func(document *Document) template.HTML {
+		for _, text := range []string{plainText(document.Content), document.Description} {
+			if location := pattern.FindStringIndex(text); location != nil {
+				return highlight(excerpt(text, location[0]), pattern)
+			}
+		}
+		return highlight(excerpt(document.Description+" "+plainText(document.Content), 0), pattern)
+	})
+	return results, count, err
+}
+
+// loadResults loads the documents with the given ids in the same order, with their snippets
+func loadResults(ids []uint, snippet func(document *Document) template.HTML) ([]SearchResult, error) {
+	results := make([]SearchResult, 0, len(ids))
+	if len(ids) == 0 {
+		return results, nil
+	}
+
+	var documents []Document
+	db := database.GetDB()
+	if err := db.Preload("CreatedBy").Preload("Terms", orderTerms).Where("id IN ?", ids).Find(&documents).Error; err != nil {
+		return nil, err
+	}
+
+	byId := map[uint]Document{}
+	for _, document := range documents {
+		byId[document.ID] = document
+	}
+	for _, id := range ids {
+		if document, ok := byId[id]; ok {
+			results = append(results, SearchResult{Document: document, Snippet: snippet(&document)})
+		}
+	}
+	return results, nil
+}
+
+// searchTerms splits a query into lowercase words, ignoring punctuation and repeated words
+func searchTerms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, term := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !seen[term] && len(terms) < maxSearchTerms {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termPattern matches any of the terms, ignoring case
+func termPattern(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, using ! as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// plainText strips the markup from HTML, keeping the text readable
+func plainText(content string) string {
+	text := hiddenTags.ReplaceAllString(content, " ")
+	text = blockTags.ReplaceAllString(text, " ")
+	text = htmlTags.ReplaceAllString(text, "")
+	return strings.TrimSpace(whitespace.ReplaceAllString(html.UnescapeString(text), " "))
+}
+
+// excerpt returns about snippetLength bytes of text around the given offset, starting and ending on word
+// boundaries
+func excerpt(text string, at int) string {
+	start := max(at-snippetLength/4, 0)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	if start > 0 {
+		if space := strings.IndexByte(text[start:at], ' '); space >= 0 {
+			start += space + 1
+		}
+	}
+
+	end := min(start+snippetLength, len(text))
+	if end < len(text) {
+		if space := strings.LastIndexByte(text[start:end], ' '); space > at-start {
+			end = start + space
+		}
+		for end > start && !utf8.RuneStart(text[end]) {
+			end--
+		}
+	}
+
+	result := text[start:end]
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(text) {
+		result += "…"
+	}
+	return result
+}
+
+// highlight escapes text, wrapping the matches of pattern in <mark>
+func highlight(text string, pattern *regexp.Regexp) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, location := range pattern.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:location[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[location[0]:location[1]]) + "</mark>")
+		last = location[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// highlightMarks escapes a snippet from the full-text index, replacing its match markers with <mark>
+func highlightMarks(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	return template.HTML(strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(escaped))
+}
@@ -0,0 +1,158 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/utils"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+// maxSlugLength leaves room for the numeric suffix added to duplicate slugs
+const maxSlugLength = 200
+
+var (
+	slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// SlugRedirect remembers a slug a document no longer uses, so links to it may be redirected
+type SlugRedirect struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	Slug       string    `json:"slug" gorm:"size:255;uniqueIndex"`
+	DocumentId uint      `json:"document_id" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Slugify reduces text to lowercase letters, digits and single hyphens, eg. "Hello, World!" to "hello-world"
+func Slugify(text string) string {
+	slug := slugSeparators.ReplaceAllString(strings.ToLower(text), "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
+
+// GetBySlug gets a document by its slug, regardless of its status
+func GetBySlug(slug string) (*Document, error) {
+	db := database.GetDB()
+	var document Document
+	res := db.Preload("CreatedBy").Preload("Terms", orderTerms).Where("slug = ?", slug).Limit(1).Find(&document)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get document by slug: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	return &document, nil
+}
+
+// GetPublishedBySlug gets a document by its slug if it is currently published
+func GetPublishedBySlug(slug string) (*Document, error) {
+	db := database.GetDB()
+	var document Document
+	res := published(db.Preload("CreatedBy").Preload("Terms", orderTerms)).Where("slug = ?", slug).Limit(1).Find(&document)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get document by slug: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	if document.ID == 0 {
+		return nil, fmt.Errorf("document %q not found", slug)
+	}
+	return &document, nil
+}
+
+// ResolveSlug finds the current slug of a published document from its slug, a slug it used to have, or its
+// id. It is the path resolver for {{slug}} web routes, so links to old slugs and ids are redirected.
+func ResolveSlug(value string) (string, bool) {
+	if document, err := GetPublishedBySlug(value); err == nil {
+		return document.Slug, true
+	}
+
+	db := database.GetDB()
+	id := value
+	var redirect SlugRedirect
+	if err := db.Where("slug = ?", value).Limit(1).Find(&redirect).Error; err == nil && redirect.ID != 0 {
+		id = strconv.Itoa(int(redirect.DocumentId))
+	} else if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return "", false
+	}
+
+	document, err := GetPublishedById(id)
+	if err != nil || document.ID == 0 {
+		return "", false
+	}
+	return document.Slug, true
+}
+
+// assignSlug normalizes the slug of a document, generating one from its title if it is empty, and keeps
+// its previous slug as a redirect when it changes. It must run within the transaction that saves the document.
+func assignSlug(tx *gorm.DB, document *Document) error {
+	if document.Slug == "" {
+		slug, err := GenerateSlug(tx, document.Title, document.ID)
+		if err != nil {
+			return err
+		}
+		document.Slug = slug
+	} else {
+		document.Slug = Slugify(document.Slug)
+		if !slugPattern.MatchString(document.Slug) {
+			return errors.New("the slug must contain letters or digits")
+		}
+		var count int64
+		if err := tx.Unscoped().Model(&Document{}).Where("slug = ? AND id <> ?", document.Slug, document.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("the slug %q is already used by another document", document.Slug)
+		}
+	}
+
+	// A slug in use is never redirected elsewhere
+	if err := tx.Where("slug = ?", document.Slug).Delete(&SlugRedirect{}).Error; err != nil {
+		return err
+	}
+
+	if document.ID == 0 {
+		return nil
+	}
+	var slugs []string
+	if err := tx.Unscoped().Model(&Document{}).Where("id = ?", document.ID).Pluck("slug", &slugs).Error; err != nil {
+		return err
+	}
+	if len(slugs) == 0 || slugs[0] == "" || slugs[0] == document.Slug {
+		return nil
+	}
+	previous := slugs[0]
+	if err := tx.Where("slug = ?", previous).Delete(&SlugRedirect{}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&SlugRedirect{Slug: previous, DocumentId: document.ID}).Error
+}
+
+// GenerateSlug makes a slug from title that no other document uses, adding a numeric suffix if needed.
+// Deleted documents are included, as they keep their slugs.
+func GenerateSlug(tx *gorm.DB, title string, documentId uint) (string, error) {
+	base := utils.OrDefault(Slugify(title), "document")
+	var taken []string
+	if err := tx.Unscoped().Model(&Document{}).
+		Where("(slug = ? OR slug LIKE ?) AND id <> ?", base, base+"-%", documentId).
+		Pluck("slug", &taken).Error; err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, slug := range taken {
+		used[slug] = true
+	}
+	slug := base
+	for i := 2; used[slug]; i++ {
+		slug = base + "-" + strconv.Itoa(i)
+	}
+	return slug, nil
+}
@@ -0,0 +1,449 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+// Taxonomy is a way of grouping documents; categories are hierarchical and tags are flat
+type Taxonomy string
+
+const (
+	TaxonomyCategory Taxonomy = "category"
+	TaxonomyTag      Taxonomy = "tag"
+)
+
+// Taxonomies lists every taxonomy
+var Taxonomies = []Taxonomy{TaxonomyCategory, TaxonomyTag}
+
+// Term is a category or tag that documents may be assigned to
+type Term struct {
+	ID          uint     `json:"id" gorm:"primarykey"`
+	Taxonomy    Taxonomy `json:"taxonomy" gorm:"size:32;uniqueIndex:idx_term_slug"`
+	Name        string   `json:"name" gorm:"size:255"`
+	Slug        string   `json:"slug" gorm:"size:255;uniqueIndex:idx_term_slug"`
+	Description string   `json:"description" gorm:"size:1000"`
+	// ParentId nests a category within another; tags are never nested
+	ParentId  *uint     `json:"parent_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Depth is the nesting level of the term, set when terms are listed
+	Depth int `json:"depth" gorm:"-"`
+	// Count is the number of published documents assigned to the term, set when terms are listed
+	Count int64 `json:"count" gorm:"-"`
+}
+
+// DocumentTerm assigns a term to a document
+type DocumentTerm struct {
+	DocumentId uint `gorm:"primaryKey"`
+	TermId     uint `gorm:"primaryKey;index"`
+}
+
+// TermAssignment gives a document new terms as it is saved, within the same transaction. A nil list leaves
+// the terms of that taxonomy alone.
+type TermAssignment struct {
+	Categories []Term
+	// Tags are given by name; tags that do not exist are created if CreateTags is true
+	Tags       []string
+	CreateTags bool
+}
+
+// IsChildOf returns true if the term is nested directly within the term with the given id
+func (term *Term) IsChildOf(id uint) bool {
+	return term.ParentId != nil && *term.ParentId == id
+}
+
+// TermsOf returns the terms of the document in the taxonomy
+func (document Document) TermsOf(taxonomy Taxonomy) []Term {
+	var terms []Term
+	for _, term := range document.Terms {
+		if term.Taxonomy == taxonomy {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Categories returns the categories of the document
+func (document Document) Categories() []Term {
+	return document.TermsOf(TaxonomyCategory)
+}
+
+// Tags returns the tags of the document
+func (document Document) Tags() []Term {
+	return document.TermsOf(TaxonomyTag)
+}
+
+// HasTerm returns true if the document is assigned the term
+func (document Document) HasTerm(id uint) bool {
+	for _, term := range document.Terms {
+		if term.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTaxonomy validates a taxonomy name
+func ParseTaxonomy(s string) (Taxonomy, error) {
+	for _, taxonomy := range Taxonomies {
+		if string(taxonomy) == s {
+			return taxonomy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid taxonomy %q; expected category or tag", s)
+}
+
+// GetTerms gets the terms of a taxonomy with their published document counts. Categories are ordered as a
+// tree, each followed by its subcategories, with Depth set; tags are ordered by name.
+func GetTerms(taxonomy Taxonomy) ([]Term, error) {
+	db := database.GetDB()
+	var terms []Term
+	res := db.Where("taxonomy = ?", taxonomy).Order("name").Find(&terms)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get terms: %s", res.Error.Error())
+		return nil, res.Error
+	}
+
+	var counts []struct {
+		TermId uint
+		Count  int64
+	}
+	res = published(db.Table("document_terms").Joins("JOIN documents ON documents.id = document_terms.document_id")).
+		Where("documents.deleted_at IS NULL").
+		Select("document_terms.term_id AS term_id, COUNT(*) AS count").
+		Group("document_terms.term_id").
+		Scan(&counts)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to count terms: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	countOf := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		countOf[count.TermId] = count.Count
+	}
+	for i := range terms {
+		terms[i].Count = countOf[terms[i].ID]
+	}
+
+	if taxonomy != TaxonomyCategory {
+		return terms, nil
+	}
+	return treeOrder(terms), nil
+}
+
+// GetTermById gets a term by id
+func GetTermById(id string) (*Term, error) {
+	db := database.GetDB()
+	var term Term
+	res := db.Where("id = ?", id).Limit(1).Find(&term)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get term: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	if term.ID == 0 {
+		return nil, errors.New("term not found")
+	}
+	return &term, nil
+}
+
+// GetTermBySlug gets a term of a taxonomy by its slug
+func GetTermBySlug(taxonomy Taxonomy, slug string) (*Term, error) {
+	db := database.GetDB()
+	var term Term
+	res := db.Where("taxonomy = ? AND slug = ?", taxonomy, slug).Limit(1).Find(&term)
+	if res.Error != nil {
+		log.Error("Documents", "Failed to get term: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	if term.ID == 0 {
+		return nil, fmt.Errorf("%s %q not found", taxonomy, slug)
+	}
+	return &term, nil
+}
+
+// SaveTerm creates or updates a term, generating its slug from its name if it is empty
+func SaveTerm(term Term) (*Term, error) {
+	term.Name = strings.TrimSpace(term.Name)
+	if term.Name == "" {
+		return nil, errors.New("the name cannot be empty")
+	}
+	if _, err := ParseTaxonomy(string(term.Taxonomy)); err != nil {
+		return nil, err
+	}
+
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return saveTerm(tx, &term)
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to save term: %s", err.Error())
+		return nil, err
+	}
+	return &term, nil
+}
+
+// DeleteTerm deletes a term, removing it from its documents; subcategories move up to its parent
+func DeleteTerm(term *Term) error {
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("term_id = ?", term.ID).Delete(&DocumentTerm{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Term{}).Where("parent_id = ?", term.ID).Update("parent_id", term.ParentId).Error; err != nil {
+			return err
+		}
+		return tx.Delete(term).Error
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to delete term: %s", err.Error())
+	}
+	return err
+}
+
+// EnsureTags gets the tags with the given names within a transaction, creating those that do not exist if
+// create is true
+func EnsureTags(tx *gorm.DB, names []string, create bool) ([]Term, error) {
+	var tags []Term
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		slug := Slugify(name)
+		if slug == "" {
+			continue
+		}
+		var tag Term
+		if err := tx.Where("taxonomy = ? AND slug = ?", TaxonomyTag, slug).Limit(1).Find(&tag).Error; err != nil {
+			return nil, err
+		}
+		if tag.ID == 0 {
+			if !create {
+				return nil, fmt.Errorf("tag %q does not exist, and creating tags requires the term:add permission", name)
+			}
+			tag = Term{Taxonomy: TaxonomyTag, Name: name, Slug: slug}
+			if err := saveTerm(tx, &tag); err != nil {
+				return nil, err
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// GetTermsBySlug gets the terms of a taxonomy with the given slugs, failing if any does not exist
+func GetTermsBySlug(taxonomy Taxonomy, slugs []string) ([]Term, error) {
+	var terms []Term
+	for _, slug := range slugs {
+		term, err := GetTermBySlug(taxonomy, slug)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, *term)
+	}
+	return terms, nil
+}
+
+// SetTerms replaces the terms of a document within a taxonomy, leaving its terms in other taxonomies alone
+func SetTerms(documentId uint, taxonomy Taxonomy, terms []Term) error {
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return setTerms(tx, documentId, taxonomy, terms)
+	})
+	if err != nil {
+		log.Error("Documents", "Failed to set document terms: %s", err.Error())
+	}
+	return err
+}
+
+// ResolveTerm returns a path resolver for {{category}} and {{tag}} web routes, which also redirects
+// values that differ from a slug only in case or punctuation
+func ResolveTerm(taxonomy Taxonomy) func(value string) (string, bool) {
+	return func(value string) (string, bool) {
+		term, err := GetTermBySlug(taxonomy, Slugify(value))
+		if err != nil {
+			return "", false
+		}
+		return term.Slug, true
+	}
+}
+
+// apply assigns the terms to a document within the transaction saving it
+func (assignment *TermAssignment) apply(tx *gorm.DB, documentId uint) error {
+	if assignment == nil {
+		return nil
+	}
+	if assignment.Categories != nil {
+		if err := setTerms(tx, documentId, TaxonomyCategory, assignment.Categories); err != nil {
+			return err
+		}
+	}
+	if assignment.Tags != nil {
+		tags, err := EnsureTags(tx, assignment.Tags, assignment.CreateTags)
+		if err != nil {
+			return err
+		}
+		return setTerms(tx, documentId, TaxonomyTag, tags)
+	}
+	return nil
+}
+
+func setTerms(tx *gorm.DB, documentId uint, taxonomy Taxonomy, terms []Term) error {
+	if err := tx.Where("document_id = ? AND term_id IN (?)", documentId,
+		tx.Model(&Term{}).Select("id").Where("taxonomy = ?", taxonomy)).
+		Delete(&DocumentTerm{}).Error; err != nil {
+		return err
+	}
+	for _, term := range terms {
+		if term.Taxonomy != taxonomy {
+			return fmt.Errorf("%s is not a %s", term.Name, taxonomy)
+		}
+		if err := tx.Save(&DocumentTerm{DocumentId: documentId, TermId: term.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveTerm(tx *gorm.DB, term *Term) error {
+	if err := assignTermSlug(tx, term); err != nil {
+		return err
+	}
+	if err := checkParent(tx, term); err != nil {
+		return err
+	}
+	return tx.Save(term).Error
+}
+
+// withTerm limits a query to documents assigned the term of the taxonomy with the given slug, including
+// the subcategories of a category
+func withTerm(taxonomy Taxonomy, slug string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		ids := []uint{}
+		if term, err := GetTermBySlug(taxonomy, slug); err == nil {
+			ids = append(ids, term.ID)
+			if taxonomy == TaxonomyCategory {
+				ids = append(ids, descendantIds(db.Session(&gorm.Session{NewDB: true}), term.ID)...)
+			}
+		}
+		return db.Where("documents.id IN (?)",
+			db.Session(&gorm.Session{NewDB: true}).Model(&DocumentTerm{}).Select("document_id").Where("term_id IN ?", ids))
+	}
+}
+
+// orderTerms orders preloaded terms by name
+func orderTerms(db *gorm.DB) *gorm.DB {
+	return db.Order("name")
+}
+
+// assignTermSlug normalizes the slug of a term, generating one from its name if it is empty
+func assignTermSlug(tx *gorm.DB, term *Term) error {
+	explicit := term.Slug != ""
+	base := Slugify(term.Slug)
+	if !explicit {
+		base = Slugify(term.Name)
+	}
+	if base == "" {
+		return errors.New("the slug must contain letters or digits")
+	}
+
+	var taken []string
+	if err := tx.Model(&Term{}).
+		Where("taxonomy = ? AND (slug = ? OR slug LIKE ?) AND id <> ?", term.Taxonomy, base, base+"-%", term.ID).
+		Pluck("slug", &taken).Error; err != nil {
+		return err
+	}
+	used := make(map[string]bool, len(taken))
+	for _, slug := range taken {
+		used[slug] = true
+	}
+	if explicit && used[base] {
+		return fmt.Errorf("the slug %q is already used by another %s", base, term.Taxonomy)
+	}
+
+	term.Slug = base
+	for i := 2; used[term.Slug]; i++ {
+		term.Slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return nil
+}
+
+// checkParent ensures the parent of a term is a category that is not nested within the term itself
+func checkParent(tx *gorm.DB, term *Term) error {
+	if term.ParentId == nil || *term.ParentId == 0 {
+		term.ParentId = nil
+		return nil
+	}
+	if term.Taxonomy != TaxonomyCategory {
+		return errors.New("only categories may have a parent")
+	}
+	if term.ID != 0 && *term.ParentId == term.ID {
+		return errors.New("a category cannot be its own parent")
+	}
+
+	var parent Term
+	if err := tx.Where("id = ? AND taxonomy = ?", *term.ParentId, TaxonomyCategory).Limit(1).Find(&parent).Error; err != nil {
+		return err
+	}
+	if parent.ID == 0 {
+		return errors.New("the parent category does not exist")
+	}
+	if term.ID == 0 {
+		return nil
+	}
+	for _, id := range descendantIds(tx, term.ID) {
+		if id == parent.ID {
+			return errors.New("a category cannot be nested within its own subcategory")
+		}
+	}
+	return nil
+}
+
+// descendantIds returns the ids of every category nested within the category
+func descendantIds(db *gorm.DB, id uint) []uint {
+	var result []uint
+	parents := []uint{id}
+	for len(parents) > 0 {
+		var children []uint
+		if err := db.Model(&Term{}).Where("parent_id IN ?", parents).Pluck("id", &children).Error; err != nil {
+			log.Error("Documents", "Failed to get subcategories: %s", err.Error())
+			return result
+		}
+		result = append(result, children...)
+		parents = children
+	}
+	return result
+}
+
+// treeOrder orders terms depth first, so each is followed by its children, setting their depth. Terms
+// whose parent is missing are treated as top level.
+func treeOrder(terms []Term) []Term {
+	known := make(map[uint]bool, len(terms))
+	for _, term := range terms {
+		known[term.ID] = true
+	}
+	children := map[uint][]Term{}
+	for _, term := range terms {
+		var parent uint
+		if term.ParentId != nil && known[*term.ParentId] {
+			parent = *term.ParentId
+		}
+		children[parent] = append(children[parent], term)
+	}
+
+	result := make([]Term, 0, len(terms))
+	var walk func(parent uint, depth int)
+	walk = func(parent uint, depth int) {
+		for _, term := range children[parent] {
+			term.Depth = depth
+			result = append(result, term)
+			walk(term.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	return result
+}
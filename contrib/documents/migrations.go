@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"github.com/gojicms/goji/contrib/documents/documents"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// documentV1 is the documents table as created by migration 1. Later migrations add their own columns to
+// it, so it must not change along with documents.Document.
+type documentV1 struct {
+	gorm.Model
+	Title       string      `gorm:"size:255"`
+	Description string      `gorm:"size:1000"`
+	Content     string      `gorm:"type:text"`
+	CreatedBy   *users.User `gorm:"foreignKey:CreatedById;constraint:OnUpdate:NO ACTION,OnDelete:SET NULL;"`
+	CreatedById *uint       `gorm:"index"`
+}
+
+func (documentV1) TableName() string {
+	return "documents"
+}
+
+// documentTermV5 is the document_terms table as created by migration 5, with the foreign keys of the join
+// table of documents.Document.Terms
+type documentTermV5 struct {
+	DocumentId uint            `gorm:"primaryKey"`
+	TermId     uint            `gorm:"primaryKey;index"`
+	Document   *documentV1     `gorm:"foreignKey:DocumentId"`
+	Term       *documents.Term `gorm:"foreignKey:TermId"`
+}
+
+func (documentTermV5) TableName() string {
+	return "document_terms"
+}
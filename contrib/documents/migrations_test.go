@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gojicms/goji/contrib/documents/documents"
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+)
+
+// TestMigrationsAddTheirOwnColumns checks that the first migration creates the documents table as it was
+// before the later migrations, which add their columns to existing databases
+func TestMigrationsAddTheirOwnColumns(t *testing.T) {
+	config.ActiveConfig.Application.Database.DSN = "file:" + filepath.Join(t.TempDir(), "test.db")
+	t.Cleanup(func() { _ = database.Close() })
+
+	if _, err := database.Migrate("documents", Service.Migrations[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	migrator := database.GetDB().Migrator()
+	for _, field := range []string{"Status", "PublishedAt", "UnpublishAt", "Slug", "Announced"} {
+		if migrator.HasColumn(&documents.Document{}, field) {
+			t.Errorf("migration 1 created the column of %s", field)
+		}
+	}
+	if migrator.HasTable(&documents.Term{}) || migrator.HasTable(&documents.DocumentTerm{}) {
+		t.Error("migration 1 created the taxonomy tables")
+	}
+
+	if _, err := database.Migrate("documents", Service.Migrations, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"Status", "PublishedAt", "UnpublishAt", "Slug", "Announced"} {
+		if !migrator.HasColumn(&documents.Document{}, field) || !migrator.HasIndex(&documents.Document{}, field) {
+			t.Errorf("the column of %s or its index is missing", field)
+		}
+	}
+	if !migrator.HasConstraint(&documentTermV5{}, "Document") || !migrator.HasConstraint(&documentTermV5{}, "Term") {
+		t.Error("document_terms is missing its foreign keys")
+	}
+}
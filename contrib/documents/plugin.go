@@ -1,9 +1,12 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gojicms/goji/contrib/documents/admin"
 	"github.com/gojicms/goji/contrib/documents/documents"
@@ -11,7 +14,10 @@ import (
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server"
 	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/services/webhooks"
 	"github.com/gojicms/goji/core/utils"
+	"gorm.io/gorm"
 )
 
 //////////////////////////////////
@@ -25,21 +31,44 @@ var PluginService extend.ServiceDef = Service
 //////////////////////////////////
 
 var addDocResource = extend.ResourceDef{
-	HttpValidator: extend.NewHttpValidator(http.MethodPost, "/api/v1/docs"),
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "^/api/v1/docs$"),
 	Description:   "Adds a new document",
 	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "document:add") {
+			return
+		}
+
 		r := flow.Request
 		w := flow.Writer
 
 		var doc documents.Document
-		if err := utils.DecodeJSONBody(r, &doc); err != nil {
-			server.WriteError(w, http.StatusInternalServerError, err.Error())
+		body := docBody{Document: &doc}
+		if err := utils.DecodeJSONBody(r, &body); err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		if !canSetStatus(flow, doc.Status) {
+			server.WriteError(w, http.StatusForbidden, "publishing or archiving documents requires the document:publish permission")
+			return
+		}
+
+		user := flow.Get("user").(*users.User)
+		doc.CreatedBy = user
+
+		terms, err := body.terms(user.HasPermission("term:add"))
+		if err != nil {
+			server.WriteError(w, http.StatusUnprocessableEntity, "%s", err.Error())
 			return
 		}
 
-		addedDoc, err := documents.Create(doc)
+		addedDoc, err := documents.Create(doc, terms)
 		if err != nil {
-			server.WriteError(w, http.StatusInternalServerError, err.Error())
+			server.WriteError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+		if addedDoc, err = documents.GetById(strconv.Itoa(int(addedDoc.ID))); err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
@@ -52,13 +81,16 @@ var addDocResource = extend.ResourceDef{
 }
 
 var deleteDocResource = extend.ResourceDef{
-	HttpValidator: extend.NewHttpValidator(http.MethodDelete, "/api/v1/docs"),
+	HttpValidator: extend.NewHttpValidator(http.MethodDelete, "/api/v1/docs/{id}"),
 	Description:   "Deletes a document",
 	Handler: func(flow *httpflow.HttpFlow) {
-		r := flow.Request
+		if !requirePermission(flow, "document:delete") {
+			return
+		}
+
 		w := flow.Writer
 
-		id := r.PathValue("id")
+		id := flow.Param("id")
 
 		if id == "" {
 			server.WriteError(w, http.StatusUnprocessableEntity, "Id is required")
@@ -67,7 +99,7 @@ var deleteDocResource = extend.ResourceDef{
 
 		count, err := documents.DeleteById(id)
 		if err != nil {
-			server.WriteError(w, http.StatusInternalServerError, err.Error())
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
@@ -81,30 +113,38 @@ var deleteDocResource = extend.ResourceDef{
 }
 
 var getDocResource = extend.ResourceDef{
-	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/docs/.+"),
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/docs/{id}"),
 	Description:   "Returns a single document",
 	Handler: func(flow *httpflow.HttpFlow) {
-		r := flow.Request
 		w := flow.Writer
 
-		id := r.PathValue("id")
+		id := flow.Param("id")
 		if id == "" {
 			server.WriteError(w, http.StatusUnprocessableEntity, "Id is required")
 			return
 		}
 
-		doc, err := documents.GetById(id)
-		if err != nil {
+		var doc *documents.Document
+		var err error
+		if canViewAll(flow) {
+			doc, err = documents.GetById(id)
+		} else {
+			doc, err = documents.GetPublishedById(id)
+		}
+		if err != nil || doc.ID == 0 {
 			server.WriteError(w, http.StatusNotFound, "document not found")
 			return
 		}
+		if !canViewAll(flow) {
+			filterContent(doc)
+		}
 
 		server.WriteJson(w, doc)
 	},
 }
 
 var getDocsResource = extend.ResourceDef{
-	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/docs"),
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/api/v1/docs$"),
 	Description:   "Displays a list of documents",
 	Handler: func(flow *httpflow.HttpFlow) {
 		r := flow.Request
@@ -124,36 +164,101 @@ var getDocsResource = extend.ResourceDef{
 			return
 		}
 
-		docs, err := documents.Get(limitInt, offsetInt, "updated_at desc")
-		if err != nil {
-			server.WriteError(w, http.StatusInternalServerError, err.Error())
+		// Editors see every document, optionally filtered by status; everyone else sees published documents
+		filter := documents.Filter{
+			Category: r.URL.Query().Get("category"),
+			Tag:      r.URL.Query().Get("tag"),
+		}
+		if canViewAll(flow) {
+			filter.Status = r.URL.Query().Get("status")
+			if filter.Status != "" {
+				if _, err := documents.ParseStatus(filter.Status); err != nil {
+					server.WriteError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+					return
+				}
+			}
+		} else {
+			filter.Published = true
 		}
 
-		count, err := documents.Count()
+		sort := "updated_at desc"
+		if filter.Published {
+			sort = ""
+		}
+		docs, err := documents.GetFiltered(filter, limitInt, offsetInt, sort)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		count, _ := documents.CountFiltered(filter)
+		if filter.Published {
+			filterDocs(docs)
+		}
 
 		httpflow.WriteJsonList(flow, limitInt, offsetInt, int(count), "docs", &docs)
 	},
 }
 
 var updateDocResource = extend.ResourceDef{
-	HttpValidator: extend.NewHttpValidator(http.MethodPost, "/api/v1/docs/.+"),
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "/api/v1/docs/{id}"),
 	Description:   "Updates a document",
 	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "document:edit") {
+			return
+		}
+
 		r := flow.Request
 		w := flow.Writer
 
-		id := r.PathValue("id")
+		id := flow.Param("id")
 
 		doc, err := documents.GetById(id)
+		if err != nil || doc.ID == 0 {
+			server.WriteError(w, http.StatusNotFound, "document not found")
+			return
+		}
 
-		if err := utils.DecodeJSONBody(r, &doc); err != nil {
-			server.WriteError(w, http.StatusInternalServerError, err.Error())
+		// The body is decoded over the document, so the schedule is copied rather than shared with previous
+		previous := *doc
+		doc.PublishedAt, doc.UnpublishAt = cloneTime(doc.PublishedAt), cloneTime(doc.UnpublishAt)
+		body := docBody{Document: doc}
+		if err := utils.DecodeJSONBody(r, &body); err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
-		addedDoc, err := documents.Update(*doc)
+		// The document is the one named by the path; its id and creation are not changed by the body
+		if doc.ID != previous.ID {
+			server.WriteError(w, http.StatusUnprocessableEntity, "the id of the body does not match the document")
+			return
+		}
+		doc.CreatedAt, doc.CreatedById, doc.DeletedAt = previous.CreatedAt, previous.CreatedById, previous.DeletedAt
+
+		if doc.Status != previous.Status && (!canSetStatus(flow, previous.Status) || !canSetStatus(flow, doc.Status)) {
+			server.WriteError(w, http.StatusForbidden, "publishing or archiving documents requires the document:publish permission")
+			return
+		}
+		// Rescheduling a published document can take it offline, so it is restricted like unpublishing it
+		rescheduled := !sameTime(doc.PublishedAt, previous.PublishedAt) || !sameTime(doc.UnpublishAt, previous.UnpublishAt)
+		if rescheduled && !canSetStatus(flow, previous.Status) {
+			server.WriteError(w, http.StatusForbidden, "scheduling published or archived documents requires the document:publish permission")
+			return
+		}
+
+		user := flow.Get("user").(*users.User)
+		terms, err := body.terms(user.HasPermission("term:add"))
+		if err != nil {
+			server.WriteError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+
+		addedDoc, err := documents.Update(*doc, user, terms)
 		if err != nil {
-			server.WriteError(w, http.StatusInternalServerError, err.Error())
+			server.WriteError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+		if addedDoc, err = documents.GetById(strconv.Itoa(int(addedDoc.ID))); err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
@@ -165,6 +270,109 @@ var updateDocResource = extend.ResourceDef{
 	},
 }
 
+var getRevisionsResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/docs/{id}/revisions"),
+	Description:   "Lists the revisions of a document",
+	Handler: func(flow *httpflow.HttpFlow) {
+		w := flow.Writer
+
+		if !canViewAll(flow) {
+			server.WriteError(w, http.StatusForbidden, "viewing revisions requires the document:view permission")
+			return
+		}
+
+		doc, err := documents.GetById(flow.Param("id"))
+		if err != nil || doc.ID == 0 {
+			server.WriteError(w, http.StatusNotFound, "document not found")
+			return
+		}
+
+		revisions, err := documents.GetRevisions(doc.ID)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		server.WriteJson(w, utils.Object{"revisions": revisions})
+	},
+}
+
+var getTermsResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/terms/{taxonomy}"),
+	Description:   "Lists the categories or tags of documents",
+	Handler: func(flow *httpflow.HttpFlow) {
+		w := flow.Writer
+
+		taxonomy, err := documents.ParseTaxonomy(flow.Param("taxonomy"))
+		if err != nil {
+			server.WriteError(w, http.StatusNotFound, "%s", err.Error())
+			return
+		}
+
+		terms, err := documents.GetTerms(taxonomy)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		server.WriteJson(w, utils.Object{"terms": terms})
+	},
+}
+
+var searchDocsResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/api/v1/search$"),
+	Description:   "Searches documents, best matches first",
+	Handler: func(flow *httpflow.HttpFlow) {
+		r := flow.Request
+		w := flow.Writer
+
+		query := r.URL.Query().Get("q")
+		if strings.TrimSpace(query) == "" {
+			server.WriteError(w, http.StatusUnprocessableEntity, "q is required")
+			return
+		}
+
+		limitInt, err := strconv.Atoi(utils.OrDefault(r.URL.Query().Get("limit"), "10"))
+		if err != nil {
+			server.WriteError(w, http.StatusUnprocessableEntity, "Limit must be an integer")
+			return
+		}
+		offsetInt, err := strconv.Atoi(utils.OrDefault(r.URL.Query().Get("offset"), "0"))
+		if err != nil {
+			server.WriteError(w, http.StatusUnprocessableEntity, "Offset must be an integer")
+			return
+		}
+
+		// As with the document list, only editors may search documents that are not published
+		filter := documents.Filter{
+			Category: r.URL.Query().Get("category"),
+			Tag:      r.URL.Query().Get("tag"),
+		}
+		if canViewAll(flow) {
+			filter.Status = r.URL.Query().Get("status")
+			if filter.Status != "" {
+				if _, err := documents.ParseStatus(filter.Status); err != nil {
+					server.WriteError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+					return
+				}
+			}
+		} else {
+			filter.Published = true
+		}
+
+		results, count, err := documents.Search(query, filter, limitInt, offsetInt)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		if filter.Published {
+			filterResults(results)
+		}
+
+		httpflow.WriteJsonList(flow, limitInt, offsetInt, int(count), "results", &results)
+	},
+}
+
 //////////////////////////////////
 // Service Definition           //
 //////////////////////////////////
@@ -172,26 +380,358 @@ var updateDocResource = extend.ResourceDef{
 var Service = extend.ServiceDef{
 	Name:         "documents",
 	FriendlyName: "Documents",
+	DependsOn:    []string{"administration", "authentication", "site_config", "webhooks"},
 	Resources: []extend.ResourceDef{
 		getDocsResource,
 		getDocResource,
 		addDocResource,
 		deleteDocResource,
 		updateDocResource,
+		getRevisionsResource,
+		getTermsResource,
+		searchDocsResource,
+	},
+	Permissions: []extend.PermissionDef{
+		{Name: "document:view", Description: "View documents", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "document:add", Description: "Create documents", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "document:edit", Description: "Edit documents", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "document:delete", Description: "Delete documents", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "document:publish", Description: "Publish, schedule and archive documents", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "term:view", Description: "View categories and tags", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "term:add", Description: "Create categories and tags", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "term:edit", Description: "Edit categories and tags", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "term:delete", Description: "Delete categories and tags", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "document:configure", Description: "Configure document settings such as revision retention", DefaultGroups: []string{"administrator"}},
+	},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_documents",
+			Up:      database.AutoMigrateModels(&documentV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&documentV1{})
+			},
+		},
+		{
+			Version: 2,
+			Name:    "add_publishing_workflow",
+			Up: func(tx *gorm.DB) error {
+				if err := addColumns(tx, &documents.Document{}, "Status", "PublishedAt", "UnpublishAt"); err != nil {
+					return err
+				}
+				// Documents predating the workflow were already public, so they remain published
+				return tx.Model(&documents.Document{}).
+					Where("1 = 1").
+					Updates(map[string]interface{}{
+						"status":       documents.StatusPublished,
+						"published_at": gorm.Expr("created_at"),
+					}).Error
+			},
+			Down: func(tx *gorm.DB) error {
+				for _, column := range []string{"Status", "PublishedAt", "UnpublishAt"} {
+					if err := tx.Migrator().DropColumn(&documents.Document{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 3,
+			Name:    "create_document_revisions",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&documents.Revision{}); err != nil {
+					return err
+				}
+				// Existing documents start their history with a snapshot of their current state
+				var docs []documents.Document
+				if err := tx.Find(&docs).Error; err != nil {
+					return err
+				}
+				for i := range docs {
+					if _, err := documents.RecordRevision(tx, &docs[i], nil, "Initial revision"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&documents.Revision{})
+			},
+		},
+		{
+			Version: 4,
+			Name:    "add_document_slugs",
+			Up: func(tx *gorm.DB) error {
+				// The column is filled in before it is uniquely indexed
+				if !tx.Migrator().HasColumn(&documents.Document{}, "Slug") {
+					if err := tx.Migrator().AddColumn(&documents.Document{}, "Slug"); err != nil {
+						return err
+					}
+				}
+				var docs []documents.Document
+				if err := tx.Unscoped().Where("slug IS NULL OR slug = ''").Order("id").Find(&docs).Error; err != nil {
+					return err
+				}
+				for _, doc := range docs {
+					slug, err := documents.GenerateSlug(tx, doc.Title, doc.ID)
+					if err != nil {
+						return err
+					}
+					if err := tx.Unscoped().Model(&documents.Document{}).Where("id = ?", doc.ID).Update("slug", slug).Error; err != nil {
+						return err
+					}
+				}
+				if err := addColumns(tx, &documents.Document{}, "Slug"); err != nil {
+					return err
+				}
+				return tx.AutoMigrate(&documents.SlugRedirect{})
+			},
+			Down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&documents.SlugRedirect{}); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropIndex(&documents.Document{}, "Slug"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&documents.Document{}, "Slug")
+			},
+		},
+		{
+			Version: 5,
+			Name:    "create_taxonomies",
+			Up:      database.AutoMigrateModels(&documents.Term{}, &documentTermV5{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&documentTermV5{}, &documents.Term{})
+			},
+		},
+		{
+			Version: 6,
+			Name:    "add_publish_announcements",
+			Up: func(tx *gorm.DB) error {
+				if err := addColumns(tx, &documents.Document{}, "Announced"); err != nil {
+					return err
+				}
+				// Documents that are already visible were announced when they were published
+				now := time.Now()
+				if err := tx.Unscoped().Model(&documents.Document{}).Where("1 = 1").UpdateColumn("announced", false).Error; err != nil {
+					return err
+				}
+				return tx.Unscoped().Model(&documents.Document{}).
+					Where("status = ? AND published_at <= ?", documents.StatusPublished, now).
+					UpdateColumn("announced", true).Error
+			},
+			Down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropIndex(&documents.Document{}, "Announced"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&documents.Document{}, "Announced")
+			},
+		},
 	},
 	OnInit: func() error {
+		if err := documents.InitSearch(); err != nil {
+			return err
+		}
 		admin.Init()
 
-		database.AutoMigrate(&documents.Document{})
+		// Webhooks receive documents as the API returns them
+		documentData := func(doc *documents.Document) any { return doc }
+		webhooks.Register(documents.EventCreated, "A document was created", documentData)
+		webhooks.Register(documents.EventUpdated, "A document was updated", documentData)
+		webhooks.Register(documents.EventDeleted, "A document was deleted", documentData)
+		webhooks.Register(documents.EventPublished, "A document was published", documentData)
 
+		// Templates are public, so they only ever see published documents
 		extend.RegisterFunction("docs", func(limit int, offset int, sort string) []documents.Document {
-			docs, _ := documents.Get(limit, offset, sort)
+			docs, _ := documents.GetPublished(limit, offset, sort)
+			filterDocs(docs)
 			return docs
 		})
 		extend.RegisterFunction("doc", func(id string) documents.Document {
-			doc, _ := documents.GetById(id)
+			doc, err := documents.GetPublishedById(id)
+			if err != nil {
+				return documents.Document{}
+			}
+			filterContent(doc)
+			return *doc
+		})
+		extend.RegisterFunction("docBySlug", func(slug string) documents.Document {
+			doc, err := documents.GetPublishedBySlug(slug)
+			if err != nil {
+				return documents.Document{}
+			}
+			filterContent(doc)
 			return *doc
 		})
+
+		// search finds published documents for results pages, eg. {{ range search (.query.Get "q") 10 0 }}
+		extend.RegisterFunction("search", func(query string, limit int, offset int) []documents.SearchResult {
+			results, _, _ := documents.Search(query, documents.Filter{Published: true}, limit, offset)
+			filterResults(results)
+			return results
+		})
+		extend.RegisterFunction("searchCount", func(query string) int64 {
+			_, count, _ := documents.Search(query, documents.Filter{Published: true}, 0, 0)
+			return count
+		})
+
+		extend.RegisterFunction("terms", func(taxonomy string) []documents.Term {
+			terms, _ := documents.GetTerms(documents.Taxonomy(taxonomy))
+			return terms
+		})
+		extend.RegisterFunction("term", func(taxonomy string, slug string) documents.Term {
+			term, err := documents.GetTermBySlug(documents.Taxonomy(taxonomy), slug)
+			if err != nil {
+				return documents.Term{}
+			}
+			return *term
+		})
+		extend.RegisterFunction("docsByTerm", func(taxonomy string, slug string, limit int, offset int, sort string) []documents.Document {
+			filter := documents.Filter{Published: true}
+			switch documents.Taxonomy(taxonomy) {
+			case documents.TaxonomyCategory:
+				filter.Category = slug
+			case documents.TaxonomyTag:
+				filter.Tag = slug
+			default:
+				return nil
+			}
+			docs, _ := documents.GetFiltered(filter, limit, offset, sort)
+			filterDocs(docs)
+			return docs
+		})
+
+		// Pages such as web/article/{{slug}}.html are served for published documents, and old slugs redirect;
+		// web/category/{{category}}.html and web/tag/{{tag}}.html are served for existing terms
+		extend.RegisterPathResolver("slug", documents.ResolveSlug)
+		extend.RegisterPathResolver("category", documents.ResolveTerm(documents.TaxonomyCategory))
+		extend.RegisterPathResolver("tag", documents.ResolveTerm(documents.TaxonomyTag))
 		return nil
 	},
+	OnStart: func() error {
+		documents.StartScheduler()
+		return nil
+	},
+	OnShutdown: func(ctx context.Context) error {
+		return documents.StopScheduler(ctx)
+	},
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// docBody is the body of requests that create or update a document. Categories are given by slug and
+// tags by name, creating tags that do not exist for users who may add terms; the terms of a taxonomy are
+// left alone if it is omitted.
+type docBody struct {
+	*documents.Document
+	Categories *[]string `json:"categories"`
+	Tags       *[]string `json:"tags"`
+}
+
+// terms looks up the categories of the request, leaving the tags to be created with the document if
+// createTags is true
+func (body docBody) terms(createTags bool) (*documents.TermAssignment, error) {
+	terms := &documents.TermAssignment{CreateTags: createTags}
+	if body.Categories != nil {
+		categories, err := documents.GetTermsBySlug(documents.TaxonomyCategory, *body.Categories)
+		if err != nil {
+			return nil, err
+		}
+		terms.Categories = append([]documents.Term{}, categories...)
+	}
+	if body.Tags != nil {
+		terms.Tags = append([]string{}, *body.Tags...)
+	}
+	return terms, nil
+}
+
+// addColumns adds the indexed fields of model that are missing from its table. Unlike AutoMigrate, it
+// leaves alone the columns introduced by later migrations.
+func addColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if !tx.Migrator().HasColumn(model, field) {
+			if err := tx.Migrator().AddColumn(model, field); err != nil {
+				return err
+			}
+		}
+		if !tx.Migrator().HasIndex(model, field) {
+			if err := tx.Migrator().CreateIndex(model, field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filterContent passes the content of a document through the content filter, leaving empty documents alone.
+// Every read of documents for the public goes through it; editors get the content as stored.
+func filterContent(doc *documents.Document) {
+	if doc.ID != 0 {
+		doc.Content = documents.FilterContent.Apply(doc.Content)
+	}
+}
+
+// filterDocs passes the content of each document through the content filter
+func filterDocs(docs []documents.Document) {
+	for i := range docs {
+		filterContent(&docs[i])
+	}
+}
+
+// filterResults passes the content of each search result through the content filter
+func filterResults(results []documents.SearchResult) {
+	for i := range results {
+		filterContent(&results[i].Document)
+	}
+}
+
+// requirePermission writes an error response and returns false unless the current user has the permission
+func requirePermission(flow *httpflow.HttpFlow, permission string) bool {
+	user, ok := flow.Get("user").(*users.User)
+	if !ok || user == nil {
+		httpflow.WriteUnauthorizedJson(flow)
+		return false
+	}
+	if !user.HasPermission(permission) {
+		flow.WriteErrorJson(http.StatusForbidden, "forbidden; requires the %s permission", permission)
+		return false
+	}
+	return true
+}
+
+// cloneTime returns a copy of a time that may be unset
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	clone := *t
+	return &clone
+}
+
+// sameTime returns true if both times are unset, or are set to the same instant
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// canViewAll returns true if the current user may see documents that are not published
+func canViewAll(flow *httpflow.HttpFlow) bool {
+	user, ok := flow.Get("user").(*users.User)
+	return ok && user != nil && user.HasPermission("document:view")
+}
+
+// canSetStatus returns true if the current user may move a document to or from the status; drafts and
+// documents pending review may be handled by anyone who may add or edit documents, which the handlers
+// check first
+func canSetStatus(flow *httpflow.HttpFlow, status documents.Status) bool {
+	if status == "" || status == documents.StatusDraft || status == documents.StatusPendingReview {
+		return true
+	}
+	user, ok := flow.Get("user").(*users.User)
+	return ok && user != nil && user.HasPermission("document:publish")
 }
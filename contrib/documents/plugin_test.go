@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gojicms/goji/contrib/documents/documents"
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/groups"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+	"gorm.io/gorm"
+)
+
+// updateDoc posts a body to the update API as a user with the permissions, returning the status
+func updateDoc(t *testing.T, id uint, body string, permissions ...string) int {
+	t.Helper()
+	user := &users.User{Model: gorm.Model{ID: 1}, Group: &groups.Group{Permissions: utils.CSV(permissions)}}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/docs/"+strconv.Itoa(int(id)), strings.NewReader(body))
+	r.SetPathValue("id", strconv.Itoa(int(id)))
+	recorder := httptest.NewRecorder()
+	flow := &httpflow.HttpFlow{Writer: recorder, Request: r}
+	flow.Set("user", user)
+	updateDocResource.Handler(flow)
+	return recorder.Code
+}
+
+func TestUpdateDocPermissions(t *testing.T) {
+	config.ActiveConfig.Application.Database.DSN = "file:" + filepath.Join(t.TempDir(), "test.db")
+	t.Cleanup(func() { _ = database.Close() })
+	if err := database.GetDB().AutoMigrate(&groups.Group{}, &users.User{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Migrate("documents", Service.Migrations, false); err != nil {
+		t.Fatal(err)
+	}
+
+	published := time.Now().Add(-time.Hour)
+	var ids []uint
+	for _, title := range []string{"First", "Second"} {
+		doc, err := documents.Create(documents.Document{Title: title, Status: documents.StatusPublished, PublishedAt: &published}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	first, second := ids[0], ids[1]
+
+	tests := []struct {
+		name        string
+		body        string
+		permissions []string
+		status      int
+	}{
+		{"another document's id", `{"id":` + strconv.Itoa(int(second)) + `,"status":"draft"}`, []string{"document:edit", "document:publish"}, http.StatusUnprocessableEntity},
+		{"unpublishing", `{"status":"draft"}`, []string{"document:edit"}, http.StatusForbidden},
+		{"unpublishing by the past", `{"unpublish_at":"2020-01-01T00:00:00Z"}`, []string{"document:edit"}, http.StatusForbidden},
+		{"publishing in the future", `{"published_at":"2099-01-01T00:00:00Z"}`, []string{"document:edit"}, http.StatusForbidden},
+		{"editing", `{"id":` + strconv.Itoa(int(first)) + `,"title":"Edited","CreatedAt":"2000-01-01T00:00:00Z"}`, []string{"document:edit"}, http.StatusCreated},
+		{"rescheduling as a publisher", `{"unpublish_at":"2099-01-01T00:00:00Z"}`, []string{"document:edit", "document:publish"}, http.StatusCreated},
+	}
+	for _, test := range tests {
+		if status := updateDoc(t, first, test.body, test.permissions...); status != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.status)
+		}
+	}
+
+	doc, err := documents.GetById(strconv.Itoa(int(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Title != "Edited" || doc.Status != documents.StatusPublished || doc.CreatedAt.Year() == 2000 {
+		t.Errorf("first document %q is %s, created %s", doc.Title, doc.Status, doc.CreatedAt)
+	}
+	if doc.UnpublishAt == nil || doc.UnpublishAt.Year() != 2099 {
+		t.Errorf("first document unpublishes at %v, want 2099", doc.UnpublishAt)
+	}
+	if doc, _ = documents.GetById(strconv.Itoa(int(second))); doc.Title != "Second" || doc.Status != documents.StatusPublished {
+		t.Errorf("second document %q is %s, want it untouched", doc.Title, doc.Status)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"time"
 
 	"github.com/gojicms/goji/core/utils/log"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -20,13 +19,22 @@ type ApplicationConfig struct {
 	ApiRootUrl            string
 	Debug                 bool
 	TemplateFileSizeLimit int64
-	LogLevel              log.LogLevel
-	Database              DatabaseConfig
-	Auth                  AuthConfig
-	Pepper                string `json:"-"` // Don't provide the pepper EVEN IF DEBUG IS ENABLED!
+	// ShutdownTimeout How long in-flight requests are given to complete when the server is stopped
+	ShutdownTimeout time.Duration
+	LogLevel        log.LogLevel
+	Database        DatabaseConfig
+	Auth            AuthConfig
+	Media           MediaConfig
+	Jobs            JobsConfig
+	Mail            MailConfig
+	Webhooks        WebhooksConfig
+	Pepper          string `json:"-"` // Don't provide the pepper EVEN IF DEBUG IS ENABLED!
 }
 
 type DatabaseConfig struct {
+	// DSN The SQLite connection string used when no Connector is provided
+	DSN string `json:"-"`
+	// Connector Opens the database; this allows using any GORM dialect in place of SQLite
 	Connector func() gorm.Dialector `json:"-"`
 	Config    gorm.Config           `json:"-"`
 }
@@ -36,10 +44,93 @@ type AuthConfig struct {
 	CookieId string
 	// CSRFId The name of the cookie to use to store the CSRF value
 	CSRFId string
+	// CSRFExemptPaths Regular expressions for paths that do not require a CSRF token on state-changing requests
+	CSRFExemptPaths []string
+	// CSRFRequireForTokens Require CSRF tokens even for requests authenticated with an API token
+	CSRFRequireForTokens bool
 	// CookieLifetime How long the cookie should last
 	CookieLifetime time.Duration
 	// RefreshLifetime How long the cookie should last before a new cookie is provided.
 	RefreshLifetime time.Duration
+	// PasswordMinLength The fewest characters a new password may have
+	PasswordMinLength int
+	// ResetTokenLifetime How long a password reset link may be used
+	ResetTokenLifetime time.Duration
+}
+
+type MediaConfig struct {
+	// Storage The storage driver uploads are kept in; local or s3
+	Storage string
+	// MaxUploadSize The largest upload accepted, in bytes
+	MaxUploadSize int64
+	// AllowedTypes The content types that may be uploaded, as detected from the file contents
+	AllowedTypes []string
+	// LocalPath The directory the local driver stores uploads in
+	LocalPath string
+	// ImagePresets The image derivatives that may be requested, eg. w_800 or w_150,h_150,fit_cover
+	ImagePresets []string
+	// CachePath The directory generated image derivatives are cached in
+	CachePath string
+	S3        S3Config
+}
+
+// MaxFilesPerUpload bounds the size of a multipart upload to this many files of the maximum upload size
+const MaxFilesPerUpload = 20
+
+// MaxRequestSize returns the largest multipart request accepted: MaxFilesPerUpload files of the maximum upload
+// size, along with the other fields of the form
+func (c MediaConfig) MaxRequestSize() int64 {
+	return c.MaxUploadSize*MaxFilesPerUpload + 1<<20
+}
+
+type S3Config struct {
+	// Endpoint The URL of the S3-compatible service, eg. https://s3.us-east-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string `json:"-"`
+	SecretKey string `json:"-"`
+	// PathStyle Address the bucket in the path rather than the host name, as most S3-compatible services expect
+	PathStyle bool
+	// PublicUrl Link to files at this URL, eg. a CDN in front of the bucket, rather than serving them through Goji
+	PublicUrl string
+}
+
+type JobsConfig struct {
+	// HistoryLimit How many runs of each scheduled job are kept
+	HistoryLimit int
+	// Workers How many queued jobs this instance runs at once
+	Workers int
+	// Retention How long succeeded queued jobs are kept before they are deleted
+	Retention time.Duration
+}
+
+type MailConfig struct {
+	// Transport How mail is sent; smtp, outbox to write messages to files, or log to log them
+	Transport string
+	// From The sender of messages that do not name one, eg. "Goji <goji@example.com>"
+	From string
+	// TemplatePath The directory of templates overriding those of each kind of message
+	TemplatePath string
+	// OutboxPath The directory messages are written to by the outbox transport
+	OutboxPath string
+	SMTP       SMTPConfig
+}
+
+type WebhooksConfig struct {
+	// AllowPrivateNetworks Deliver webhooks to loopback, link-local and private addresses, eg. a receiver on
+	// the same machine while developing. They are refused otherwise, so webhooks cannot reach internal
+	// services.
+	AllowPrivateNetworks bool
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string `json:"-"`
+	// TLS How the connection is secured; starttls, tls for implicit TLS, or none
+	TLS string
 }
 
 type Config struct {
@@ -57,18 +148,50 @@ var ActiveConfig Config = Config{
 		RootUrl:               "",
 		ApiRootUrl:            "",
 		Debug:                 false,
-		TemplateFileSizeLimit: 10,
+		TemplateFileSizeLimit: 1024 * 1024 * 10,
+		ShutdownTimeout:       time.Second * 30,
 		LogLevel:              log.LogWarn | log.LogError | log.LogInfo,
 		Pepper:                "pepper",
 		Auth: AuthConfig{
-			CookieId:        "Goji_Auth",
-			CSRFId:          "Goji_CSRF",
-			CookieLifetime:  time.Hour,
-			RefreshLifetime: time.Minute * 45,
+			CookieId:           "Goji_Auth",
+			CSRFId:             "Goji_CSRF",
+			CookieLifetime:     time.Hour,
+			RefreshLifetime:    time.Minute * 45,
+			PasswordMinLength:  8,
+			ResetTokenLifetime: time.Hour,
 		},
 		Database: DatabaseConfig{
-			func() gorm.Dialector { return sqlite.Open("file:mydatabase.db?cache=shared&mode=rwc") },
-			gorm.Config{},
+			DSN:    "file:mydatabase.db?cache=shared&mode=rwc",
+			Config: gorm.Config{},
+		},
+		Media: MediaConfig{
+			Storage:       "local",
+			MaxUploadSize: 1024 * 1024 * 10,
+			AllowedTypes: []string{
+				"image/jpeg", "image/png", "image/gif", "image/webp",
+				"application/pdf", "video/mp4", "video/webm", "audio/mpeg", "audio/wave",
+			},
+			LocalPath:    "media",
+			ImagePresets: []string{"w_150,h_150,fit_cover", "w_400", "w_800", "w_1200", "w_1600"},
+			CachePath:    "cache/media",
+			S3: S3Config{
+				Region: "us-east-1",
+			},
+		},
+		Jobs: JobsConfig{
+			HistoryLimit: 50,
+			Workers:      4,
+			Retention:    time.Hour * 24 * 7,
+		},
+		Mail: MailConfig{
+			Transport:    "log",
+			From:         "Goji <goji@localhost>",
+			TemplatePath: "mail",
+			OutboxPath:   "outbox",
+			SMTP: SMTPConfig{
+				Port: 587,
+				TLS:  "starttls",
+			},
 		},
 	},
 }
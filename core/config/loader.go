@@ -0,0 +1,387 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gojicms/goji/core/utils/log"
+	"gopkg.in/yaml.v3"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// setting describes a single configuration value that may be provided by a config file or the environment.
+// Keys are dotted paths as they appear in config files, eg. auth.cookie_lifetime; the matching environment
+// variable is the key upper-cased, prefixed with GOJI_ and with dots replaced by underscores.
+type setting struct {
+	Key   string
+	Apply func(config *ApplicationConfig, value string) error
+}
+
+var settings = []setting{
+	{"host", func(c *ApplicationConfig, v string) error { c.Host = v; return nil }},
+	{"port", func(c *ApplicationConfig, v string) error {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("%q is not a valid port; expected a number between 1 and 65535", v)
+		}
+		c.Port = v
+		return nil
+	}},
+	{"root_url", func(c *ApplicationConfig, v string) error { c.RootUrl = v; return nil }},
+	{"api_root_url", func(c *ApplicationConfig, v string) error { c.ApiRootUrl = v; return nil }},
+	{"debug", func(c *ApplicationConfig, v string) (err error) {
+		c.Debug, err = parseBool(v)
+		return err
+	}},
+	{"template_file_size_limit", func(c *ApplicationConfig, v string) error {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("%q is not a valid size; expected a non-negative number of bytes", v)
+		}
+		c.TemplateFileSizeLimit = limit
+		return nil
+	}},
+	{"shutdown_timeout", func(c *ApplicationConfig, v string) (err error) {
+		c.ShutdownTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"log_level", func(c *ApplicationConfig, v string) (err error) {
+		c.LogLevel, err = parseLogLevel(v)
+		return err
+	}},
+	{"pepper", func(c *ApplicationConfig, v string) error { c.Pepper = v; return nil }},
+	{"database.dsn", func(c *ApplicationConfig, v string) error { c.Database.DSN = v; return nil }},
+	{"auth.cookie_id", func(c *ApplicationConfig, v string) error { c.Auth.CookieId = v; return nil }},
+	{"auth.csrf_id", func(c *ApplicationConfig, v string) error { c.Auth.CSRFId = v; return nil }},
+	{"auth.csrf_exempt_paths", func(c *ApplicationConfig, v string) error {
+		c.Auth.CSRFExemptPaths = nil
+		for _, path := range splitList(v) {
+			if _, err := regexp.Compile(path); err != nil {
+				return fmt.Errorf("%q is not a valid regular expression: %w", path, err)
+			}
+			c.Auth.CSRFExemptPaths = append(c.Auth.CSRFExemptPaths, path)
+		}
+		return nil
+	}},
+	{"auth.csrf_require_for_tokens", func(c *ApplicationConfig, v string) (err error) {
+		c.Auth.CSRFRequireForTokens, err = parseBool(v)
+		return err
+	}},
+	{"auth.cookie_lifetime", func(c *ApplicationConfig, v string) (err error) {
+		c.Auth.CookieLifetime, err = parseDuration(v)
+		return err
+	}},
+	{"auth.refresh_lifetime", func(c *ApplicationConfig, v string) (err error) {
+		c.Auth.RefreshLifetime, err = parseDuration(v)
+		return err
+	}},
+	{"auth.password_min_length", func(c *ApplicationConfig, v string) error {
+		length, err := strconv.Atoi(v)
+		if err != nil || length < 1 {
+			return fmt.Errorf("%q is not a valid length; expected a positive number of characters", v)
+		}
+		c.Auth.PasswordMinLength = length
+		return nil
+	}},
+	{"auth.reset_token_lifetime", func(c *ApplicationConfig, v string) (err error) {
+		c.Auth.ResetTokenLifetime, err = parseDuration(v)
+		return err
+	}},
+	{"media.storage", func(c *ApplicationConfig, v string) error {
+		if v != "local" && v != "s3" {
+			return fmt.Errorf("%q is not a valid storage driver; expected local or s3", v)
+		}
+		c.Media.Storage = v
+		return nil
+	}},
+	{"media.max_upload_size", func(c *ApplicationConfig, v string) error {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("%q is not a valid size; expected a positive number of bytes", v)
+		}
+		c.Media.MaxUploadSize = size
+		return nil
+	}},
+	{"media.allowed_types", func(c *ApplicationConfig, v string) error {
+		c.Media.AllowedTypes = splitList(v)
+		return nil
+	}},
+	{"media.local_path", func(c *ApplicationConfig, v string) error { c.Media.LocalPath = v; return nil }},
+	{"media.image_presets", func(c *ApplicationConfig, v string) error {
+		// Presets contain commas, so they are separated by whitespace instead
+		c.Media.ImagePresets = strings.Fields(v)
+		return nil
+	}},
+	{"media.cache_path", func(c *ApplicationConfig, v string) error { c.Media.CachePath = v; return nil }},
+	{"media.s3.endpoint", func(c *ApplicationConfig, v string) error { c.Media.S3.Endpoint = v; return nil }},
+	{"media.s3.region", func(c *ApplicationConfig, v string) error { c.Media.S3.Region = v; return nil }},
+	{"media.s3.bucket", func(c *ApplicationConfig, v string) error { c.Media.S3.Bucket = v; return nil }},
+	{"media.s3.access_key", func(c *ApplicationConfig, v string) error { c.Media.S3.AccessKey = v; return nil }},
+	{"media.s3.secret_key", func(c *ApplicationConfig, v string) error { c.Media.S3.SecretKey = v; return nil }},
+	{"media.s3.path_style", func(c *ApplicationConfig, v string) (err error) {
+		c.Media.S3.PathStyle, err = parseBool(v)
+		return err
+	}},
+	{"media.s3.public_url", func(c *ApplicationConfig, v string) error { c.Media.S3.PublicUrl = v; return nil }},
+	{"jobs.history_limit", func(c *ApplicationConfig, v string) error {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("%q is not a valid limit; expected a positive number of runs", v)
+		}
+		c.Jobs.HistoryLimit = limit
+		return nil
+	}},
+	{"jobs.workers", func(c *ApplicationConfig, v string) error {
+		workers, err := strconv.Atoi(v)
+		if err != nil || workers < 1 {
+			return fmt.Errorf("%q is not a valid number of workers; expected a positive number", v)
+		}
+		c.Jobs.Workers = workers
+		return nil
+	}},
+	{"jobs.retention", func(c *ApplicationConfig, v string) (err error) {
+		c.Jobs.Retention, err = parseDuration(v)
+		return err
+	}},
+	{"mail.transport", func(c *ApplicationConfig, v string) error {
+		if v != "smtp" && v != "outbox" && v != "log" {
+			return fmt.Errorf("%q is not a valid mail transport; expected smtp, outbox or log", v)
+		}
+		c.Mail.Transport = v
+		return nil
+	}},
+	{"mail.from", func(c *ApplicationConfig, v string) error { c.Mail.From = v; return nil }},
+	{"mail.template_path", func(c *ApplicationConfig, v string) error { c.Mail.TemplatePath = v; return nil }},
+	{"mail.outbox_path", func(c *ApplicationConfig, v string) error { c.Mail.OutboxPath = v; return nil }},
+	{"mail.smtp.host", func(c *ApplicationConfig, v string) error { c.Mail.SMTP.Host = v; return nil }},
+	{"mail.smtp.port", func(c *ApplicationConfig, v string) error {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("%q is not a valid port; expected a number between 1 and 65535", v)
+		}
+		c.Mail.SMTP.Port = port
+		return nil
+	}},
+	{"mail.smtp.username", func(c *ApplicationConfig, v string) error { c.Mail.SMTP.Username = v; return nil }},
+	{"mail.smtp.password", func(c *ApplicationConfig, v string) error { c.Mail.SMTP.Password = v; return nil }},
+	{"mail.smtp.tls", func(c *ApplicationConfig, v string) error {
+		if v != "starttls" && v != "tls" && v != "none" {
+			return fmt.Errorf("%q is not a valid TLS mode; expected starttls, tls or none", v)
+		}
+		c.Mail.SMTP.TLS = v
+		return nil
+	}},
+	{"webhooks.allow_private_networks", func(c *ApplicationConfig, v string) (err error) {
+		c.Webhooks.AllowPrivateNetworks, err = parseBool(v)
+		return err
+	}},
+}
+
+// listSettings are comma separated in environment variables. Config files give them as lists instead, so
+// their items (such as regular expressions) may contain commas.
+var listSettings = map[string]bool{
+	"auth.csrf_exempt_paths": true,
+	"media.allowed_types":    true,
+}
+
+var logLevels = map[string]log.LogLevel{
+	"verbose": log.LogVerbose,
+	"info":    log.LogInfo,
+	"warn":    log.LogWarn,
+	"error":   log.LogError,
+	"debug":   log.LogDebug,
+}
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Load reads configuration from the file at path (if path is not empty) followed by GOJI_* environment
+// variables, applying both on top of the defaults in ActiveConfig. The resulting precedence is
+// defaults < file < environment < code, as configuration passed to PrepareServer is merged afterward.
+// The file format is determined by its extension: .yaml, .yml, .toml or .json.
+// Every invalid value is reported in the returned error, rather than only the first.
+func Load(path string) error {
+	var errs []error
+	values := map[string]string{}
+	sources := map[string]string{}
+
+	if path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+		for key, value := range fileValues {
+			values[key] = value
+			sources[key] = path
+		}
+	}
+
+	for _, s := range settings {
+		name := EnvName(s.Key)
+		if value, ok := os.LookupEnv(name); ok {
+			if listSettings[s.Key] {
+				value = strings.ReplaceAll(value, ",", "\n")
+			}
+			values[s.Key] = value
+			sources[s.Key] = name
+		}
+	}
+
+	known := map[string]bool{}
+	loaded := ActiveConfig.Application
+	for _, s := range settings {
+		known[s.Key] = true
+		value, ok := values[s.Key]
+		if !ok {
+			continue
+		}
+		if err := s.Apply(&loaded, strings.TrimSpace(value)); err != nil {
+			errs = append(errs, fmt.Errorf("%s (from %s): %w", s.Key, sources[s.Key], err))
+		}
+	}
+
+	var unknown []string
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%s (from %s): unknown setting", key, sources[key]))
+	}
+
+	if loaded.Auth.RefreshLifetime > loaded.Auth.CookieLifetime {
+		errs = append(errs, fmt.Errorf("auth.refresh_lifetime (%s) must not exceed auth.cookie_lifetime (%s)",
+			loaded.Auth.RefreshLifetime, loaded.Auth.CookieLifetime))
+	}
+
+	if loaded.Media.Storage == "s3" && (loaded.Media.S3.Endpoint == "" || loaded.Media.S3.Bucket == "") {
+		errs = append(errs, errors.New("media.s3.endpoint and media.s3.bucket are required when media.storage is s3"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	ActiveConfig.Application = loaded
+	return nil
+}
+
+// EnvName returns the environment variable name for a dotted setting key, eg. auth.cookie_id -> GOJI_AUTH_COOKIE_ID
+func EnvName(key string) string {
+	return "GOJI_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// readConfigFile decodes a config file and flattens it into dotted keys
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	decoded := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &decoded)
+	case ".toml":
+		err = toml.Unmarshal(content, &decoded)
+	case ".json":
+		err = json.Unmarshal(content, &decoded)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %s; expected .yaml, .yml, .toml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", decoded, values)
+	return values, nil
+}
+
+func flatten(prefix string, in map[string]any, out map[string]string) {
+	for key, value := range in {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, out)
+		case []any:
+			parts := make([]string, len(v))
+			for i, part := range v {
+				parts[i] = fmt.Sprint(part)
+			}
+			// Items are separated by newlines so they may contain commas
+			out[key] = strings.Join(parts, "\n")
+		case float64:
+			// JSON decodes every number as a float
+			out[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func parseBool(value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%q is not a valid boolean; expected true or false", value)
+	}
+	return b, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a valid duration; expected a positive value such as 45m or 1h", value)
+	}
+	return d, nil
+}
+
+// splitList splits a list into its items, which are on separate lines; see listSettings
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "\n") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseLogLevel accepts a comma separated list of levels, eg. "error,warn,info", or a list from a config file
+func parseLogLevel(value string) (log.LogLevel, error) {
+	var level log.LogLevel
+	separators := func(r rune) bool { return r == ',' || r == '\n' }
+	for _, name := range strings.FieldsFunc(value, separators) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		l, ok := logLevels[name]
+		if !ok {
+			return 0, fmt.Errorf("%q is not a valid log level; expected a list of verbose, info, warn, error or debug", name)
+		}
+		level |= l
+	}
+	return level, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+func TestLoadLists(t *testing.T) {
+	defaults := ActiveConfig.Application
+	t.Cleanup(func() { ActiveConfig.Application = defaults })
+
+	// A single string in a config file is one item, even when it contains commas
+	path := filepath.Join(t.TempDir(), "goji.yaml")
+	content := "auth:\n  csrf_exempt_paths: ^/hooks/[a-z]{1,3}$\nmedia:\n  allowed_types: [image/png, image/gif]\n" +
+		"log_level: [error, warn]\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := ActiveConfig.Application.Auth.CSRFExemptPaths; !reflect.DeepEqual(got, []string{"^/hooks/[a-z]{1,3}$"}) {
+		t.Errorf("csrf_exempt_paths from a file %q", got)
+	}
+	if got := ActiveConfig.Application.Media.AllowedTypes; !reflect.DeepEqual(got, []string{"image/png", "image/gif"}) {
+		t.Errorf("allowed_types from a file %q", got)
+	}
+	if got := ActiveConfig.Application.LogLevel; got != log.LogError|log.LogWarn {
+		t.Errorf("log_level from a file %v", got)
+	}
+
+	// Environment variables are comma separated
+	t.Setenv("GOJI_MEDIA_ALLOWED_TYPES", "image/jpeg, application/pdf")
+	t.Setenv("GOJI_LOG_LEVEL", "info,debug")
+	if err := Load(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := ActiveConfig.Application.Media.AllowedTypes; !reflect.DeepEqual(got, []string{"image/jpeg", "application/pdf"}) {
+		t.Errorf("allowed_types from the environment %q", got)
+	}
+	if got := ActiveConfig.Application.LogLevel; got != log.LogInfo|log.LogDebug {
+		t.Errorf("log_level from the environment %v", got)
+	}
+}
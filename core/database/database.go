@@ -1,8 +1,12 @@
 package database
 
 import (
+	"errors"
+	"time"
+
 	"github.com/gojicms/goji/core/config"
 	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -13,9 +17,14 @@ var (
 func GetDB() *gorm.DB {
 	var err error
 	if db == nil {
-		db, err = gorm.Open(
-			config.ActiveConfig.Application.Database.Connector(),
-			&config.ActiveConfig.Application.Database.Config)
+		dbConfig := config.ActiveConfig.Application.Database
+		var dialector gorm.Dialector
+		if dbConfig.Connector != nil {
+			dialector = dbConfig.Connector()
+		} else {
+			dialector = sqlite.Open(dbConfig.DSN)
+		}
+		db, err = gorm.Open(dialector, &dbConfig.Config)
 		if err != nil {
 			log.Fatal(log.RCDatabase, "Database", "Failed to connect to database: %s", err.Error())
 		}
@@ -23,6 +32,43 @@ func GetDB() *gorm.DB {
 	return db
 }
 
+// Close closes the underlying database connection, if one has been opened
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	db = nil
+	return sqlDB.Close()
+}
+
+// Ping checks that the database is reachable and, for SQLite, not in query-only mode, without writing to it
+func Ping() error {
+	sqlDB, err := GetDB().DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.Ping(); err != nil {
+		return err
+	}
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	var queryOnly int
+	if err := db.Raw("PRAGMA query_only").Scan(&queryOnly).Error; err != nil {
+		return err
+	}
+	if queryOnly != 0 {
+		return errors.New("the database is in query-only mode")
+	}
+	return nil
+}
+
+// IsReadOnly checks that the database accepts writes by creating and dropping a table; despite its name, it
+// returns true if the database is writable. Prefer Ping for frequent checks.
 func IsReadOnly() (bool, error) {
 	// Try to create the table
 	err := db.Exec("CREATE TABLE IF NOT EXISTS __rw_test (id INT)").Error
@@ -42,6 +88,20 @@ func IsReadOnly() (bool, error) {
 	return true, nil
 }
 
+// Retry retries a write a few times, as a busy database, such as SQLite with several writers, may briefly
+// refuse it; use it for writes made in the background, where there is no request to fail instead
+func Retry(write func() error) (err error) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err = write(); err == nil {
+			return nil
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	return err
+}
+
+// AutoMigrate creates or updates the table for target, exiting on failure.
+// Deprecated: declare Migrations on the service definition instead, which are versioned and reversible.
 func AutoMigrate(target interface{}) {
 	err := GetDB().AutoMigrate(target)
 	if err != nil {
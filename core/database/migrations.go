@@ -0,0 +1,250 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Migration is a single, versioned change to a service's schema. Versions are ordered per service and
+// must never be reused or renumbered once released; add a new migration rather than editing an old one.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the migration; it is run inside a transaction
+	Up func(tx *gorm.DB) error
+	// Down reverts the migration; migrations without Down cannot be rolled back
+	Down func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a migration applied to the database
+type SchemaMigration struct {
+	Service   string `gorm:"primaryKey;size:255"`
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// AutoMigrateModels returns the Up function of a migration that creates or updates the tables for the given
+// models; this is useful as the first migration of a service whose tables predate versioned migrations.
+func AutoMigrateModels(models ...interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.AutoMigrate(models...)
+	}
+}
+
+// SchemaVersion returns the latest migration version applied for the service, or 0 if none have been
+func SchemaVersion(service string) (int, error) {
+	if !hasMigrationsTable() {
+		return 0, nil
+	}
+
+	var version int
+	err := GetDB().Model(&SchemaMigration{}).
+		Where("service = ?", service).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version).Error
+	return version, err
+}
+
+// CheckSchema ensures the database is not ahead of the migrations known to this build, which indicates
+// a newer version of the service has migrated the database and this version must not run against it.
+func CheckSchema(service string, migrations []Migration) error {
+	if err := validateMigrations(service, migrations); err != nil {
+		return err
+	}
+
+	current, err := SchemaVersion(service)
+	if err != nil {
+		return err
+	}
+
+	latest := 0
+	for _, migration := range migrations {
+		latest = max(latest, migration.Version)
+	}
+
+	if current > latest {
+		return fmt.Errorf("the database schema for %s is at version %d, but the latest known version is %d; refusing to start with a newer schema", service, current, latest)
+	}
+	return nil
+}
+
+// PendingMigrations returns the migrations for the service that have not yet been applied, in order
+func PendingMigrations(service string, migrations []Migration) ([]Migration, error) {
+	if err := validateMigrations(service, migrations); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(service)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range sortedMigrations(migrations) {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration for the service, each in its own transaction. If dryRun is true
+// the pending migrations are returned and logged, but not applied, and the database is left untouched.
+func Migrate(service string, migrations []Migration, dryRun bool) ([]Migration, error) {
+	pending, err := PendingMigrations(service, migrations)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun && len(pending) > 0 {
+		if err := ensureMigrationsTable(); err != nil {
+			return nil, err
+		}
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		if dryRun {
+			log.Info("Database", "[dry run] Would apply %s migration %d (%s)", service, migration.Version, migration.Name)
+			applied = append(applied, migration)
+			continue
+		}
+
+		log.Info("Database", "Applying %s migration %d (%s)", service, migration.Version, migration.Name)
+		err := GetDB().Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Service:   service,
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply %s migration %d (%s): %w", service, migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Rollback reverts the most recently applied migrations for the service, up to steps migrations, each in
+// its own transaction. If dryRun is true the migrations are returned and logged, but not reverted.
+func Rollback(service string, migrations []Migration, steps int, dryRun bool) ([]Migration, error) {
+	if err := validateMigrations(service, migrations); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(service)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := sortedMigrations(migrations)
+	var reverted []Migration
+	for i := len(sorted) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := sorted[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		if migration.Down == nil {
+			return reverted, fmt.Errorf("%s migration %d (%s) cannot be rolled back", service, migration.Version, migration.Name)
+		}
+
+		if dryRun {
+			log.Info("Database", "[dry run] Would roll back %s migration %d (%s)", service, migration.Version, migration.Name)
+			reverted = append(reverted, migration)
+			continue
+		}
+
+		log.Info("Database", "Rolling back %s migration %d (%s)", service, migration.Version, migration.Name)
+		err := GetDB().Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("service = ? AND version = ?", service, migration.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("failed to roll back %s migration %d (%s): %w", service, migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func ensureMigrationsTable() error {
+	return GetDB().AutoMigrate(&SchemaMigration{})
+}
+
+// hasMigrationsTable returns false until the first migration has been applied; until then, every
+// migration is pending
+func hasMigrationsTable() bool {
+	return GetDB().Migrator().HasTable(&SchemaMigration{})
+}
+
+func appliedVersions(service string) (map[int]bool, error) {
+	applied := map[int]bool{}
+	if !hasMigrationsTable() {
+		return applied, nil
+	}
+
+	var rows []SchemaMigration
+	if err := GetDB().Where("service = ?", service).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		applied[row.Version] = true
+	}
+	return applied, nil
+}
+
+func validateMigrations(service string, migrations []Migration) error {
+	var errs []error
+	seen := map[int]bool{}
+	for _, migration := range migrations {
+		if migration.Version <= 0 {
+			errs = append(errs, fmt.Errorf("%s migration %q must have a positive version", service, migration.Name))
+		}
+		if seen[migration.Version] {
+			errs = append(errs, fmt.Errorf("%s has more than one migration with version %d", service, migration.Version))
+		}
+		if migration.Up == nil {
+			errs = append(errs, fmt.Errorf("%s migration %d (%s) has no Up function", service, migration.Version, migration.Name))
+		}
+		seen[migration.Version] = true
+	}
+	return errors.Join(errs...)
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
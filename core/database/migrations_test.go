@@ -0,0 +1,57 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gojicms/goji/core/config"
+	"gorm.io/gorm"
+)
+
+type migrationTestRecord struct {
+	ID   uint
+	Name string
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	config.ActiveConfig.Application.Database.DSN = "file:" + filepath.Join(t.TempDir(), "test.db")
+	t.Cleanup(func() { _ = Close() })
+
+	migrations := []Migration{
+		{Version: 1, Name: "create_records", Up: AutoMigrateModels(&migrationTestRecord{}),
+			Down: func(tx *gorm.DB) error { return tx.Migrator().DropTable(&migrationTestRecord{}) }},
+		{Version: 2, Name: "noop", Up: func(tx *gorm.DB) error { return nil }},
+	}
+
+	pending, err := Migrate("test", migrations, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("dry run reported %d pending migrations, want 2", len(pending))
+	}
+	if GetDB().Migrator().HasTable(&SchemaMigration{}) {
+		t.Fatal("dry run created the migrations table")
+	}
+	if GetDB().Migrator().HasTable(&migrationTestRecord{}) {
+		t.Fatal("dry run applied a migration")
+	}
+	if version, err := SchemaVersion("test"); err != nil || version != 0 {
+		t.Fatalf("schema version %d (%v), want 0", version, err)
+	}
+
+	if _, err := Migrate("test", migrations, false); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := SchemaVersion("test"); err != nil || version != 2 {
+		t.Fatalf("schema version %d (%v), want 2", version, err)
+	}
+
+	// Rolling back the migration without Down fails before anything is reverted
+	if _, err := Rollback("test", migrations, 1, false); err == nil {
+		t.Fatal("expected rolling back a migration without Down to fail")
+	}
+	if version, _ := SchemaVersion("test"); version != 2 {
+		t.Fatalf("schema version %d after a failed rollback, want 2", version)
+	}
+}
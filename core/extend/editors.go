@@ -1,6 +1,7 @@
 package extend
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/gojicms/goji/core/server/httpflow"
@@ -16,6 +17,10 @@ type AdminPage struct {
 
 var AdminPages []AdminPage
 
+// ErrForbidden may be returned by the Render of an admin page when the user lacks the permission needed for
+// what they asked of it, such as an action posted to the page; the forbidden page is shown with a 403
+var ErrForbidden = errors.New("forbidden")
+
 func AddAdminPage(AdminPage AdminPage) {
 	AdminPages = append(AdminPages, AdminPage)
 	log.Debug("AdminPages", "Adding AdminPage: %s", AdminPage.Route)
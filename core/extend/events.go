@@ -0,0 +1,211 @@
+package extend
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Event is a named event whose subscribers receive a payload of type T. Events are declared once, as
+// package variables, by the package that publishes them:
+//
+//	var EventCreated = extend.NewEvent[*User]("user.created")
+type Event[T any] struct {
+	name string
+}
+
+// Listener describes a subscriber registered with the event bus
+type Listener struct {
+	Event    string `json:"event"`
+	Name     string `json:"name"`
+	Async    bool   `json:"async"`
+	Priority int    `json:"priority"`
+}
+
+// subscriber is a registered listener along with its handler; handlers are stored untyped so every event
+// shares a single registry
+type subscriber struct {
+	Listener
+	handler func(ctx context.Context, payload any) error
+}
+
+var (
+	subscribers   = map[string][]subscriber{}
+	subscribersMu sync.RWMutex
+	// asyncListeners tracks asynchronous handlers still running, so shutdown can wait for them
+	asyncListeners sync.WaitGroup
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// NewEvent declares an event; the name should be namespaced by the publisher, eg. "user.created"
+func NewEvent[T any](name string) Event[T] {
+	return Event[T]{name: name}
+}
+
+// Name returns the name of the event
+func (e Event[T]) Name() string {
+	return e.name
+}
+
+// On subscribes a synchronous handler, identified by name. Synchronous handlers run in ascending priority
+// order before Publish returns; they may modify pointer payloads, and an error (or panic) vetoes the event,
+// skipping the remaining handlers.
+func (e Event[T]) On(name string, priority int, handler func(ctx context.Context, payload T) error) {
+	subscribe(e.name, Listener{Name: name, Priority: priority}, func(ctx context.Context, payload any) error {
+		return handler(ctx, payload.(T))
+	})
+}
+
+// OnAsync subscribes an asynchronous handler, identified by name. Asynchronous handlers run on their own
+// goroutine once every synchronous handler has accepted the event, and cannot affect it.
+func (e Event[T]) OnAsync(name string, handler func(ctx context.Context, payload T)) {
+	subscribe(e.name, Listener{Name: name, Async: true}, func(ctx context.Context, payload any) error {
+		handler(ctx, payload.(T))
+		return nil
+	})
+}
+
+// Publish delivers an event that has yet to happen. Synchronous handlers run first and the first error
+// vetoes the event and is returned; the publisher should then abandon the action. Otherwise asynchronous
+// handlers are started and nil is returned.
+func (e Event[T]) Publish(ctx context.Context, payload T) error {
+	inline, async := listenersOf(e.name)
+	for _, s := range inline {
+		if err := call(ctx, s, payload); err != nil {
+			return err
+		}
+	}
+	dispatch(ctx, async, payload)
+	return nil
+}
+
+// Notify delivers an event that has already happened. Every handler is called; errors from synchronous
+// handlers are logged rather than returned, since there is nothing left to veto.
+func (e Event[T]) Notify(ctx context.Context, payload T) {
+	inline, async := listenersOf(e.name)
+	for _, s := range inline {
+		if err := call(ctx, s, payload); err != nil {
+			log.Error("Events", "Listener %s failed handling %s: %v", s.Name, e.name, err)
+		}
+	}
+	dispatch(ctx, async, payload)
+}
+
+// Listeners returns every registered listener, ordered by event and then by the order they are called in
+func Listeners() []Listener {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	var listeners []Listener
+	for _, registered := range subscribers {
+		for _, s := range registered {
+			listeners = append(listeners, s.Listener)
+		}
+	}
+	slices.SortStableFunc(listeners, func(a, b Listener) int {
+		if a.Event != b.Event {
+			if a.Event < b.Event {
+				return -1
+			}
+			return 1
+		}
+		return compareListeners(a, b)
+	})
+	return listeners
+}
+
+// WaitForListeners waits for running asynchronous handlers to finish, or for the context to expire
+func WaitForListeners(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		asyncListeners.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("asynchronous event listeners still running: %w", ctx.Err())
+	}
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// subscribe registers a handler, keeping each event's subscribers in the order they are called
+func subscribe(event string, listener Listener, handler func(ctx context.Context, payload any) error) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	listener.Event = event
+	subscribers[event] = append(subscribers[event], subscriber{Listener: listener, handler: handler})
+	slices.SortStableFunc(subscribers[event], func(a, b subscriber) int {
+		return compareListeners(a.Listener, b.Listener)
+	})
+}
+
+// compareListeners orders synchronous listeners before asynchronous ones, and then by priority
+func compareListeners(a, b Listener) int {
+	if a.Async != b.Async {
+		if a.Async {
+			return 1
+		}
+		return -1
+	}
+	return a.Priority - b.Priority
+}
+
+// listenersOf returns a snapshot of the synchronous and asynchronous subscribers of an event
+func listenersOf(event string) (inline []subscriber, async []subscriber) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	for _, s := range subscribers[event] {
+		if s.Async {
+			async = append(async, s)
+		} else {
+			inline = append(inline, s)
+		}
+	}
+	return inline, async
+}
+
+// dispatch starts each asynchronous subscriber on its own goroutine. The request context is detached from
+// its cancellation, since handlers usually outlive the request that published the event.
+func dispatch(ctx context.Context, async []subscriber, payload any) {
+	ctx = context.WithoutCancel(ctx)
+	for _, s := range async {
+		asyncListeners.Add(1)
+		go func() {
+			defer asyncListeners.Done()
+			if err := call(ctx, s, payload); err != nil {
+				log.Error("Events", "Listener %s failed handling %s: %v", s.Name, s.Event, err)
+			}
+		}()
+	}
+}
+
+// call runs a handler, converting a panic into an error so one faulty listener cannot take down the
+// publisher or the other listeners
+func call(ctx context.Context, s subscriber, payload any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Events", "Listener %s panicked handling %s: %v\n%s", s.Name, s.Event, r, debug.Stack())
+			err = fmt.Errorf("listener %s failed handling %s: %v", s.Name, s.Event, r)
+		}
+	}()
+	return s.handler(ctx, payload)
+}
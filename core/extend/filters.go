@@ -0,0 +1,139 @@
+package extend
+
+import (
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// FilterFunc transforms a value passing through a filter, returning the value to pass on
+type FilterFunc func(value any) any
+
+// Filter is a named filter that transforms values of type T. Filters are declared once, as package
+// variables, by the package that applies them:
+//
+//	var FilterContent = extend.NewFilter[string]("document.content")
+type Filter[T any] struct {
+	name string
+}
+
+type registeredFilter struct {
+	priority int
+	fn       FilterFunc
+}
+
+var (
+	filters   = map[string][]registeredFilter{}
+	filtersMu sync.RWMutex
+)
+
+var (
+	// FilterTemplateData transforms the data passed to templates before RenderTemplate executes them
+	FilterTemplateData = NewFilter[any]("template.data")
+	// FilterJson transforms payloads before they are written by httpflow.WriteJson
+	FilterJson = NewFilter[any]("json")
+)
+
+func init() {
+	// httpflow cannot depend on extend, so it is handed the json filter instead
+	httpflow.JsonFilter = func(data any) any {
+		return FilterJson.Apply(data)
+	}
+}
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// AddFilter adds a function to the named filter. Functions are applied in ascending priority order, each
+// receiving the value returned by the one before; functions of equal priority apply in the order added.
+func AddFilter(name string, priority int, fn FilterFunc) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	filters[name] = append(filters[name], registeredFilter{priority: priority, fn: fn})
+	slices.SortStableFunc(filters[name], func(a, b registeredFilter) int {
+		return a.priority - b.priority
+	})
+}
+
+// ApplyFilters passes a value through every function of the named filter and returns the result. A
+// function that panics is logged and skipped, leaving the value as it was.
+func ApplyFilters(name string, value any) any {
+	filtersMu.RLock()
+	registered := filters[name]
+	filtersMu.RUnlock()
+
+	for i, filter := range registered {
+		if filtered, err := applyFilter(filter.fn, value); err != nil {
+			log.Error("Filters", "Filter %d of %s failed: %v", i, name, err)
+		} else {
+			value = filtered
+		}
+	}
+	return value
+}
+
+// HasFilters returns true if any function has been added to the named filter
+func HasFilters(name string) bool {
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+	return len(filters[name]) > 0
+}
+
+// NewFilter declares a filter; the name should be namespaced by the package applying it, eg. "document.content"
+func NewFilter[T any](name string) Filter[T] {
+	return Filter[T]{name: name}
+}
+
+// Name returns the name of the filter
+func (f Filter[T]) Name() string {
+	return f.name
+}
+
+// Add adds a function to the filter; see AddFilter
+func (f Filter[T]) Add(priority int, fn func(value T) T) {
+	AddFilter(f.name, priority, func(value any) any {
+		typed, ok := value.(T)
+		if !ok {
+			return value
+		}
+		return fn(typed)
+	})
+}
+
+// Apply passes a value through the filter; a result that is not a T, as returned by an untyped function
+// added with AddFilter, is discarded in favour of the original value
+func (f Filter[T]) Apply(value T) T {
+	if !HasFilters(f.name) {
+		return value
+	}
+	result := ApplyFilters(f.name, value)
+	filtered, ok := result.(T)
+	if !ok {
+		log.Error("Filters", "Filter %s returned a %T rather than a %T", f.name, result, value)
+		return value
+	}
+	return filtered
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// applyFilter calls a filter function, converting a panic into an error
+func applyFilter(fn FilterFunc, value any) (filtered any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(value), nil
+}
@@ -0,0 +1,22 @@
+package extend
+
+import (
+	"context"
+	"time"
+)
+
+// JobDef describes a task a service runs on a schedule. Jobs are run by the jobs service, which claims each
+// run in the database so that only one of several instances sharing it runs the job.
+type JobDef struct {
+	// Name identifies the job across every service, eg. "sessions.cleanup"
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Schedule is a cron expression such as "*/15 * * * *", a descriptor such as "@daily", or a fixed
+	// interval such as "@every 10m"
+	Schedule string `json:"schedule"`
+	// Timeout bounds a run, defaulting to an hour; should the instance running the job stop, another may run
+	// it once the timeout has passed
+	Timeout time.Duration `json:"timeout"`
+	// Run performs the job; ctx is cancelled once the timeout passes or the server shuts down
+	Run func(ctx context.Context) error `json:"-"`
+}
@@ -0,0 +1,115 @@
+package extend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// PermissionDef declares a permission introduced by a service, eg. document:edit. Permissions are named
+// resource:action, where the action is one of view, edit, add, delete or execute, or are a single word
+// such as admin.
+type PermissionDef struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// DefaultGroups are granted the permission the first time it is seen by an installation
+	DefaultGroups []string `json:"default_groups"`
+	// Service is the name of the declaring service; it is set when the service is registered
+	Service string `json:"service"`
+}
+
+var (
+	permissions   = map[string]PermissionDef{}
+	permissionsMu sync.RWMutex
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// RegisterPermission adds a permission to the registry; services should prefer ServiceDef.Permissions.
+// Wildcards may not be registered, as they are only meaningful when granted.
+func RegisterPermission(permission PermissionDef) error {
+	if permission.Name == "" || strings.Contains(permission.Name, "*") {
+		return fmt.Errorf("invalid permission name %q", permission.Name)
+	}
+
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+	if existing, ok := permissions[permission.Name]; ok && existing.Service != permission.Service {
+		return fmt.Errorf("permission %s is declared by both %s and %s", permission.Name, existing.Service, permission.Service)
+	}
+	permissions[permission.Name] = permission
+	return nil
+}
+
+// GetPermissions returns every declared permission, sorted by name
+func GetPermissions() []PermissionDef {
+	permissionsMu.RLock()
+	defer permissionsMu.RUnlock()
+
+	result := make([]PermissionDef, 0, len(permissions))
+	for _, permission := range permissions {
+		result = append(result, permission)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// GetPermission returns the declared permission with the given name, if any
+func GetPermission(name string) (PermissionDef, bool) {
+	permissionsMu.RLock()
+	defer permissionsMu.RUnlock()
+	permission, ok := permissions[name]
+	return permission, ok
+}
+
+// UndeclaredPermissions reports the permissions required by admin pages and side menu items which no
+// service has declared; these usually indicate a typo or a missing ServiceDef.Permissions entry.
+func UndeclaredPermissions() []string {
+	var report []string
+	check := func(permission string, usage string) {
+		if permission == "" {
+			return
+		}
+		if _, ok := GetPermission(permission); !ok {
+			report = append(report, fmt.Sprintf("%s (required by %s)", permission, usage))
+		}
+	}
+
+	for _, page := range GetAdminPages() {
+		check(page.Permission, "admin page "+page.Route)
+	}
+
+	var walk func(items []*SideMenuItem)
+	walk = func(items []*SideMenuItem) {
+		for _, item := range items {
+			check(item.Permission, "side menu item "+item.Title)
+			walk(item.Children)
+		}
+	}
+	walk(GetSideMenuItems())
+
+	return report
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func registerServicePermissions(service *ServiceDef) error {
+	for i := range service.Permissions {
+		service.Permissions[i].Service = service.Name
+		if err := RegisterPermission(service.Permissions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package extend
+
+import "sync"
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// PathResolver resolves a value captured by a dynamic web route, such as the slug in article/{{slug}}.html.
+// It returns the canonical value and true if the value is known; when the canonical value differs, the
+// request is permanently redirected to it.
+type PathResolver func(value string) (canonical string, found bool)
+
+var (
+	pathResolvers   = map[string]PathResolver{}
+	pathResolversMu sync.RWMutex
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// RegisterPathResolver resolves the named route parameter of dynamic web routes, eg. "slug" for
+// {{slug}}.html. Routes whose parameter has no resolver are rendered for any value.
+func RegisterPathResolver(name string, resolver PathResolver) {
+	pathResolversMu.Lock()
+	defer pathResolversMu.Unlock()
+	pathResolvers[name] = resolver
+}
+
+// GetPathResolver returns the resolver for the named route parameter, if there is one
+func GetPathResolver(name string) (PathResolver, bool) {
+	pathResolversMu.RLock()
+	defer pathResolversMu.RUnlock()
+	resolver, ok := pathResolvers[name]
+	return resolver, ok
+}
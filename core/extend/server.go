@@ -2,8 +2,10 @@ package extend
 
 import (
 	"regexp"
+	"regexp/syntax"
 	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gojicms/goji/core/server/httpflow"
 	"github.com/gojicms/goji/core/utils/log"
@@ -16,7 +18,16 @@ import (
 var globalMiddleware []Middleware
 var globalHandlers []Handler
 
+// routesVersion is bumped whenever the set of routable services or handlers changes, allowing
+// compiled routing tables to detect that they are stale.
+var routesVersion atomic.Uint64
+
+// namedSegment matches path segments in the form {name} or {name...}
+var namedSegment = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)(\.\.\.)?\}$`)
+
 // HttpValidator TODO: This name is odd - rename
+// Path may either be a regular expression or a pattern using named segments, eg. /api/v1/docs/{id} or
+// /files/{path...}; named segments (and named regex groups) are made available through Request.PathValue.
 type HttpValidator struct {
 	Method     string `json:"method"`
 	Path       string `json:"path"`
@@ -41,23 +52,74 @@ type Middleware struct {
 // EnsureRegex ensures that the regular expression - if applicable - is compiled
 func (h *HttpValidator) EnsureRegex() {
 	if h.pathRegexp == nil && h.Path != "" && h.Path != "*" {
-		h.pathRegexp = regexp.MustCompile(h.Path)
+		h.pathRegexp = regexp.MustCompile(pathPatternToRegex(h.Path))
 	}
 }
 
 // CanHandle validates if a given flow matches the given HttpValidator
 func (h *HttpValidator) CanHandle(flow *httpflow.HttpFlow) bool {
-	h.EnsureRegex()
+	return h.Matches(flow.Request.Method, flow.Request.URL.Path)
+}
 
-	method := flow.Request.Method
-	path := flow.Request.URL.Path
+// Matches validates if a given method and path match the given HttpValidator
+func (h *HttpValidator) Matches(method string, path string) bool {
+	h.EnsureRegex()
 
 	matchesPath := h.pathRegexp == nil || h.pathRegexp.MatchString(path)
-	matchesMethod := h.Method == "*" || h.Method == "" || h.Method == method
+	matchesMethod := h.AnyMethod() || h.Method == method
 
 	return matchesPath && matchesMethod
 }
 
+// PathValues returns the named segments captured from path, or nil if there are none
+func (h *HttpValidator) PathValues(path string) map[string]string {
+	h.EnsureRegex()
+	if h.pathRegexp == nil || h.pathRegexp.NumSubexp() == 0 {
+		return nil
+	}
+
+	match := h.pathRegexp.FindStringSubmatch(path)
+	if match == nil {
+		return nil
+	}
+
+	values := map[string]string{}
+	for i, name := range h.pathRegexp.SubexpNames() {
+		if name != "" {
+			values[name] = match[i]
+		}
+	}
+	return values
+}
+
+// AnyMethod returns true if the validator accepts every HTTP method
+func (h *HttpValidator) AnyMethod() bool {
+	return h.Method == "*" || h.Method == ""
+}
+
+// LiteralPrefix returns the fixed prefix every path matched by this validator must start with. Patterns
+// that are not anchored with ^ may match anywhere in the path, and therefore have no usable prefix.
+func (h *HttpValidator) LiteralPrefix() string {
+	h.EnsureRegex()
+	if h.pathRegexp == nil {
+		return ""
+	}
+
+	re, err := syntax.Parse(h.pathRegexp.String(), syntax.Perl)
+	if err != nil || re.Op != syntax.OpConcat || len(re.Sub) == 0 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+
+	var prefix strings.Builder
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix.WriteString(string(sub.Rune))
+	}
+	return prefix.String()
+}
+
 //////////////////////////////////
 // Public Methods               //
 //////////////////////////////////
@@ -91,7 +153,7 @@ func AddMiddleware(middleware Middleware) {
 	sort.Slice(globalMiddleware, func(i, j int) bool {
 		return globalMiddleware[i].Priority < globalMiddleware[j].Priority
 	})
-	log.Debug("AddMiddleware", "Middleware %s, all %s", middleware, globalMiddleware)
+	log.Debug("AddMiddleware", "Middleware %s, total %d", middleware.Path, len(globalMiddleware))
 }
 
 func AddHandler(pattern string, handler func(flow *httpflow.HttpFlow)) {
@@ -100,6 +162,7 @@ func AddHandler(pattern string, handler func(flow *httpflow.HttpFlow)) {
 		HttpValidator: NewHttpValidator(method, path),
 		ServeHTTP:     handler,
 	})
+	routesVersion.Add(1)
 }
 
 func GetAllMiddleware() []Middleware {
@@ -110,6 +173,11 @@ func GetAllHandlers() []Handler {
 	return globalHandlers
 }
 
+// RoutesVersion returns a counter that changes whenever services or handlers are added
+func RoutesVersion() uint64 {
+	return routesVersion.Load()
+}
+
 //////////////////////////////////
 // Private Methods              //
 //////////////////////////////////
@@ -137,3 +205,36 @@ func patternToPathAndMethod(pattern string) (string, string) {
 
 	return method, path
 }
+
+// pathPatternToRegex converts patterns using named segments into an anchored regular expression. Paths
+// without named segments are assumed to already be regular expressions and are returned as-is.
+func pathPatternToRegex(path string) string {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(path, "^"), "$")
+	segments := strings.Split(trimmed, "/")
+
+	hasNamed := false
+	for _, segment := range segments {
+		if namedSegment.MatchString(segment) {
+			hasNamed = true
+			break
+		}
+	}
+	if !hasNamed {
+		return path
+	}
+
+	for i, segment := range segments {
+		match := namedSegment.FindStringSubmatch(segment)
+		switch {
+		case match == nil:
+			segments[i] = regexp.QuoteMeta(segment)
+		case match[2] != "":
+			// Wildcards consume the remainder of the path, including slashes
+			segments[i] = "(?P<" + match[1] + ">.*)"
+		default:
+			segments[i] = "(?P<" + match[1] + ">[^/]+)"
+		}
+	}
+
+	return "^" + strings.Join(segments, "/") + "$"
+}
@@ -0,0 +1,82 @@
+package extend
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPathPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+		values  map[string]string
+	}{
+		{"/api/v1/docs/{id}", "/api/v1/docs/42", true, map[string]string{"id": "42"}},
+		{"/api/v1/docs/{id}", "/api/v1/docs/", false, nil},
+		{"/api/v1/docs/{id}", "/api/v1/docs/42/revisions", false, nil},
+		{"/api/v1/docs/{id}", "/other/api/v1/docs/42", false, nil},
+		{"/api/v1/docs/{id}/revisions/{revision}", "/api/v1/docs/4/revisions/7", true,
+			map[string]string{"id": "4", "revision": "7"}},
+		{"/files/{path...}", "/files/a/b/c.txt", true, map[string]string{"path": "a/b/c.txt"}},
+		{"/files/{path...}", "/files/", true, map[string]string{"path": ""}},
+		{"/files/{path...}", "/file/a", false, nil},
+		{"^/api/v1/docs/{id}$", "/api/v1/docs/42", true, map[string]string{"id": "42"}},
+		// Literal segments are quoted once a pattern uses named segments
+		{"/v1.0/{id}", "/v1.0/1", true, map[string]string{"id": "1"}},
+		{"/v1.0/{id}", "/v1x0/1", false, nil},
+		// Patterns without named segments remain regular expressions
+		{"^/docs/(?P<slug>[a-z-]+)$", "/docs/hello-world", true, map[string]string{"slug": "hello-world"}},
+		{"^/docs/.+", "/docs/anything/at/all", true, nil},
+	}
+	for _, test := range tests {
+		validator := NewHttpValidator(http.MethodGet, test.pattern)
+		if got := validator.Matches(http.MethodGet, test.path); got != test.matches {
+			t.Errorf("%s against %s: matched %v, want %v", test.pattern, test.path, got, test.matches)
+			continue
+		}
+		if !test.matches {
+			continue
+		}
+		if got := validator.PathValues(test.path); !reflect.DeepEqual(got, test.values) {
+			t.Errorf("%s against %s: values %v, want %v", test.pattern, test.path, got, test.values)
+		}
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := map[string]string{
+		"/api/v1/docs/{id}": "/api/v1/docs/",
+		"/files/{path...}":  "/files/",
+		"^/admin/login$":    "/admin/login",
+		"^/public/.+":       "/public/",
+		".+":                "",
+		"/unanchored":       "",
+		"*":                 "",
+	}
+	for pattern, want := range tests {
+		validator := NewHttpValidator(http.MethodGet, pattern)
+		if got := validator.LiteralPrefix(); got != want {
+			t.Errorf("%s: prefix %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestPatternToPathAndMethod(t *testing.T) {
+	tests := []struct {
+		pattern string
+		method  string
+		path    string
+	}{
+		{"/docs", http.MethodGet, "^/docs$"},
+		{"POST /docs", http.MethodPost, "^/docs$"},
+		{"DELETE ^/docs/{id}$", http.MethodDelete, "^/docs/{id}$"},
+	}
+	for _, test := range tests {
+		method, path := patternToPathAndMethod(test.pattern)
+		if method != test.method || path != test.path {
+			t.Errorf("%s: got %s %s, want %s %s", test.pattern, method, path, test.method, test.path)
+		}
+	}
+}
@@ -1,24 +1,69 @@
 package extend
 
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
 var services []*ServiceDef
 
+// orderedServices holds services in dependency order once ResolveServiceOrder has been called
+var orderedServices []*ServiceDef
+
+var (
+	// EventServiceStarted is published for each service, in dependency order, once it has started
+	EventServiceStarted = NewEvent[*ServiceDef]("service.started")
+	// EventServiceStopping is published for each service, in reverse dependency order, before it shuts down
+	EventServiceStopping = NewEvent[*ServiceDef]("service.stopping")
+)
+
 type ServiceDef struct {
 	Name         string        `json:"name"`
 	FriendlyName string        `json:"friendly_name"`
 	Resources    []ResourceDef `json:"resources"`
 	Internal     bool          `json:"internal"`
-	OnInit       func() error  `json:"-"`
+	// DependsOn lists the names (or provided capabilities) of services that must be initialised first
+	DependsOn []string `json:"depends_on"`
+	// Provides lists additional capability names other services may depend on, eg. "mail"
+	Provides []string `json:"provides"`
+	// Permissions declares the permissions this service introduces, so they may be granted in the admin panel
+	Permissions []PermissionDef `json:"permissions"`
+	// Migrations are the versioned schema changes for this service, applied before OnInit
+	Migrations []database.Migration `json:"-"`
+	// Jobs are the tasks this service runs on a schedule once the server has started
+	Jobs []JobDef `json:"jobs"`
+	// OnInit is called for every service before the server is started; this is where services should
+	// register routes, migrate their tables and so on.
+	OnInit func() error `json:"-"`
+	// OnStart is called once every service has initialised, immediately before the server begins listening.
+	OnStart func() error `json:"-"`
+	// OnShutdown is called in reverse dependency order once the server has stopped accepting requests,
+	// allowing services to flush queues and close resources before the context expires.
+	OnShutdown func(ctx context.Context) error `json:"-"`
+	// OnHealthCheck reports whether the service is healthy; a nil error indicates it is.
+	OnHealthCheck func() error `json:"-"`
 }
 
 func (d ServiceDef) ToApiJson() interface{} {
 	return map[string]interface{}{
 		"name":          d.Name,
 		"friendly_name": d.FriendlyName,
+		"depends_on":    d.DependsOn,
+		"provides":      d.Provides,
+		"permissions":   d.Permissions,
 	}
 }
 
 func RegisterService(service *ServiceDef) {
+	if err := registerServicePermissions(service); err != nil {
+		log.Fatal(log.RCServicesConfig, "Extend", "Failed to register service %s: %v", service.Name, err)
+	}
 	services = append(services, service)
+	routesVersion.Add(1)
 }
 
 func GetServices() []*ServiceDef {
@@ -35,3 +80,84 @@ func GetService(name string) *ServiceDef {
 	}
 	return nil
 }
+
+// GetOrderedServices returns services in dependency order; until ResolveServiceOrder has succeeded this
+// is the registration order. Note that routing always uses registration order.
+func GetOrderedServices() []*ServiceDef {
+	source := orderedServices
+	if len(source) != len(services) {
+		source = services
+	}
+	servicesCopy := make([]*ServiceDef, len(source))
+	copy(servicesCopy, source)
+	return servicesCopy
+}
+
+// ResolveServiceOrder sorts the registered services so that every service follows the services it
+// depends on, preserving registration order where no dependency applies. An error is returned if a
+// dependency is not registered or if the dependencies form a cycle.
+func ResolveServiceOrder() error {
+	providers := map[string][]*ServiceDef{}
+	for _, service := range services {
+		providers[service.Name] = append(providers[service.Name], service)
+		for _, capability := range service.Provides {
+			providers[capability] = append(providers[capability], service)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*ServiceDef]int{}
+	var ordered []*ServiceDef
+	var path []string
+
+	var visit func(service *ServiceDef) error
+	visit = func(service *ServiceDef) error {
+		switch state[service] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for i, name := range path {
+				if name == service.Name {
+					start = i
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), service.Name)
+			return fmt.Errorf("service dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[service] = visiting
+		path = append(path, service.Name)
+		for _, dependency := range service.DependsOn {
+			dependencies, ok := providers[dependency]
+			if !ok {
+				return fmt.Errorf("service %s depends on %s, which is not registered", service.Name, dependency)
+			}
+			for _, dependsOn := range dependencies {
+				if dependsOn == service {
+					continue
+				}
+				if err := visit(dependsOn); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[service] = visited
+		ordered = append(ordered, service)
+		return nil
+	}
+
+	for _, service := range services {
+		if err := visit(service); err != nil {
+			return err
+		}
+	}
+
+	orderedServices = ordered
+	return nil
+}
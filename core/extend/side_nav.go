@@ -16,6 +16,10 @@ type SideMenuItem struct {
 
 var rootSideMenu []*SideMenuItem
 
+// FilterSideNav transforms the side navigation before it is shown to a user. The items are shared between
+// requests, so functions should replace items rather than modify them.
+var FilterSideNav = NewFilter[[]*SideMenuItem]("admin.side_nav")
+
 func AddSideMenuItem(title, path string, priority int, parent, permission string) {
 	sideMenuItem := SideMenuItem{
 		Title:      title,
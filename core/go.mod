@@ -4,8 +4,12 @@ go 1.24
 
 require (
 	dario.cat/mergo v1.0.1
+	github.com/BurntSushi/toml v1.5.0
 	github.com/google/uuid v1.6.0
+	github.com/robfig/cron/v3 v3.0.1
 	golang.org/x/crypto v0.36.0
+	golang.org/x/image v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/sqlite v1.5.7
 	gorm.io/gorm v1.25.12
 )
@@ -1,21 +1,68 @@
 package health
 
 import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/gojicms/goji/core/database"
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/utils"
 	"github.com/gojicms/goji/core/utils/log"
 )
 
+//////////////////////////////////
+// Resource Definitions         //
+//////////////////////////////////
+
+var healthResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/api/v1/health$"),
+	Description:   "Reports the health of every service",
+	Handler: func(flow *httpflow.HttpFlow) {
+		healthy := true
+		statuses := utils.Object{}
+
+		for _, service := range extend.GetServices() {
+			if service.OnHealthCheck == nil {
+				continue
+			}
+			if err := service.OnHealthCheck(); err != nil {
+				log.Warn("Health", "Service %s reported unhealthy: %v", service.Name, err)
+				healthy = false
+				statuses[service.Name] = err.Error()
+				continue
+			}
+			statuses[service.Name] = "ok"
+		}
+
+		if !healthy {
+			flow.SetHeader("Content-Type", "application/json; charset=utf-8")
+			flow.WriteHeaders(http.StatusServiceUnavailable)
+		}
+		flow.WriteJson(utils.Object{
+			"healthy":  healthy,
+			"services": statuses,
+		})
+	},
+}
+
+//////////////////////////////////
+// Service Definition           //
+//////////////////////////////////
+
 var Service = extend.ServiceDef{
 	Name:         "health",
 	FriendlyName: "Health Service",
-	Resources:    nil,
-	Internal:     true,
+	Resources: []extend.ResourceDef{
+		healthResource,
+	},
+	Internal: true,
 	OnInit: func() error {
 		extend.AddMiddleware(extend.NewMiddleware("*", "*", 0, func(flow *httpflow.HttpFlow) {
 			var errors []string
-			if b, err := database.IsReadOnly(); b == false || err != nil {
+			if err := checkWritable(); err != nil {
 				log.Error("Health/Database", "Database write check failed - ensure that the database is not loaded in read-only mode and that the connection string is for a user with full database access: %s", err)
 				errors = append(errors, "Database access is insufficient. See log for more details.")
 			}
@@ -26,4 +73,37 @@ var Service = extend.ServiceDef{
 		}))
 		return nil
 	},
+	OnHealthCheck: func() error {
+		return database.Ping()
+	},
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// writeCheckInterval is how long the result of the write check is reused, as it creates and drops a table
+const writeCheckInterval = time.Minute
+
+var writeCheck struct {
+	sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// checkWritable returns an error if the database does not accept writes, checking at most once per interval
+func checkWritable() error {
+	writeCheck.Lock()
+	defer writeCheck.Unlock()
+	if !writeCheck.checkedAt.IsZero() && time.Since(writeCheck.checkedAt) < writeCheckInterval {
+		return writeCheck.err
+	}
+
+	writable, err := database.IsReadOnly()
+	if err == nil && !writable {
+		err = errors.New("the database is not writable")
+	}
+	writeCheck.checkedAt = time.Now()
+	writeCheck.err = err
+	return err
 }
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// CheckSchemas ensures no service's schema is newer than the migrations known to this build. Every
+// service is checked, and all problems are reported together.
+func CheckSchemas() error {
+	var errs []error
+	for _, service := range extend.GetOrderedServices() {
+		if err := database.CheckSchema(service.Name, service.Migrations); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MigrateServices applies pending migrations for every service in dependency order. If dryRun is true,
+// pending migrations are only logged. PrepareServer must be called first.
+func MigrateServices(dryRun bool) error {
+	if err := prepareMigrations(); err != nil {
+		return err
+	}
+
+	for _, service := range extend.GetOrderedServices() {
+		if _, err := database.Migrate(service.Name, service.Migrations, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackService reverts up to steps of the most recently applied migrations of the named service. If
+// dryRun is true, the migrations are only logged. PrepareServer must be called first.
+func RollbackService(name string, steps int, dryRun bool) error {
+	if err := prepareMigrations(); err != nil {
+		return err
+	}
+
+	service := extend.GetService(name)
+	if service == nil {
+		return fmt.Errorf("service %s is not registered", name)
+	}
+
+	reverted, err := database.Rollback(service.Name, service.Migrations, steps, dryRun)
+	if err != nil {
+		return err
+	}
+	if len(reverted) == 0 {
+		log.Info("Core", "Service %s has no migrations to roll back", name)
+	}
+	return nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func prepareMigrations() error {
+	if !config.ActiveConfig.Cms.Configured {
+		return errors.New("configuration not configured; invoke PrepareServer before migrating")
+	}
+	if err := extend.ResolveServiceOrder(); err != nil {
+		return err
+	}
+	return CheckSchemas()
+}
@@ -1,14 +1,20 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"plugin"
+	"syscall"
 
 	"dario.cat/mergo"
 	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/health"
 	"github.com/gojicms/goji/core/server"
@@ -16,8 +22,12 @@ import (
 	"github.com/gojicms/goji/core/services/admin"
 	"github.com/gojicms/goji/core/services/auth"
 	"github.com/gojicms/goji/core/services/core"
+	"github.com/gojicms/goji/core/services/jobs"
+	"github.com/gojicms/goji/core/services/mail"
+	"github.com/gojicms/goji/core/services/media"
 	"github.com/gojicms/goji/core/services/sessions"
 	"github.com/gojicms/goji/core/services/site"
+	"github.com/gojicms/goji/core/services/webhooks"
 	"github.com/gojicms/goji/core/utils/log"
 )
 
@@ -25,12 +35,17 @@ import (
 // Types                        //
 //////////////////////////////////
 
+// startedServices holds the services that have started, in the order they started; only these are shut down
+var startedServices []*extend.ServiceDef
+
 //////////////////////////////////
 // Public Methods               //
 //////////////////////////////////
 
 // PrepareServer sets up the Goji server by providing necessary configuration,
 // checking certain configuration for sanity, and adding the mandatory core services.
+// Fields of inConfig override the active configuration only when they are not zero values, so settings
+// such as Debug cannot be turned off here; change config.ActiveConfig before calling config.Load instead.
 func PrepareServer(inConfig config.ApplicationConfig) {
 	// Update the server config to use the provided app config; this is required
 	err := mergo.Merge(&config.ActiveConfig.Application, inConfig, mergo.WithOverride, mergo.WithoutDereference)
@@ -43,6 +58,9 @@ func PrepareServer(inConfig config.ApplicationConfig) {
 		log.Level = config.ActiveConfig.Application.LogLevel
 	}
 
+	// Registration order determines routing precedence; initialisation order is resolved from each
+	// service's DependsOn, so the order below need not reflect dependencies.
+
 	// The health service checks certain things to alert the user of potential issues.
 	extend.RegisterService(&health.Service)
 
@@ -53,6 +71,9 @@ func PrepareServer(inConfig config.ApplicationConfig) {
 	// The Auth service handles the ability to log in and out
 	extend.RegisterService(&auth.Service)
 
+	// Media manages uploaded files; it serves them under /media/, so it must precede core's web routes
+	extend.RegisterService(&media.Service)
+
 	// Core lays the framework for basic APIs; Technically, despite its name, it isn't strictly
 	// necessary and in fact it MAY work without it, but since core does handle the public web
 	// side of things, this limits you to the core CMS functionality; in a headless setup,
@@ -65,35 +86,66 @@ func PrepareServer(inConfig config.ApplicationConfig) {
 	// Site allows configuring and writing core site details
 	extend.RegisterService(&site.Service)
 
+	// Jobs runs the scheduled tasks services declare, such as cleaning up expired sessions
+	extend.RegisterService(&jobs.Service)
+
+	// Webhooks notify other systems of events, such as documents being published
+	extend.RegisterService(&webhooks.Service)
+
+	// Mail sends templated messages, such as password resets, through the configured transport
+	extend.RegisterService(&mail.Service)
+
 	// Load dynamic modules after core services
 	if err := loadDynamicModules(); err != nil {
 		log.Error("Core", "Failed to load dynamic modules: %v", err)
 	}
 }
 
-func StartServer() {
+// StartServer initialises and starts every registered service, then serves requests until the process
+// receives SIGINT or SIGTERM. In-flight requests are drained for up to ShutdownTimeout before services are
+// shut down in reverse dependency order. An error is returned if the server could not be started or
+// did not shut down cleanly.
+func StartServer() error {
 	if !config.ActiveConfig.Cms.Configured {
 		log.Error("Core", "Configuration not configured. Invoke PrepareServer before calling StartServer.")
-		return
+		return errors.New("configuration not configured; invoke PrepareServer before calling StartServer")
+	}
+
+	// Services are initialised, started and stopped in dependency order
+	if err := extend.ResolveServiceOrder(); err != nil {
+		log.Error("Core", "Failed to resolve service dependencies: %v", err)
+		return err
 	}
 
-	for _, service := range extend.GetServices() {
-		log.Info("Core", "Starting service "+service.Name)
+	// Refuse to run against a database migrated by a newer build
+	if err := CheckSchemas(); err != nil {
+		log.Error("Core", "Database schema check failed: %v", err)
+		return err
+	}
+
+	for _, service := range extend.GetOrderedServices() {
+		log.Info("Core", "Starting service %s", service.Name)
 		if service.OnInit == nil {
-			log.Fatal(log.RCServicesConfig, "Core", "Service "+service.Name+" has no OnInit function")
+			return fmt.Errorf("service %s has no OnInit function", service.Name)
+		}
+		if _, err := database.Migrate(service.Name, service.Migrations, false); err != nil {
+			log.Error("Core", "Failed to migrate service %s (%s): %v", service.FriendlyName, service.Name, err)
+			return err
 		}
 		err := service.OnInit()
 		if err != nil {
-			log.Fatal(log.RCServicesConfig, "Core", "Failed to initialize service %s (%s) - please ensure a valid configuration is provided.", service.FriendlyName, service.Name)
+			log.Error("Core", "Failed to initialize service %s (%s) - please ensure a valid configuration is provided.", service.FriendlyName, service.Name)
+			return fmt.Errorf("failed to initialize service %s: %w", service.Name, err)
 		}
 	}
 
+	for _, permission := range extend.UndeclaredPermissions() {
+		log.Warn("Core", "Permission %s is not declared by any service", permission)
+	}
+
 	host := config.ActiveConfig.Application.Host
 	port := config.ActiveConfig.Application.Port
 
-	log.Log("Core", "Goji Server ")
-	log.Success("Core", "Server listening on %s:%s", host, port)
-
 	serverInst := &http.Server{
 		Addr:    host + ":" + port,
 		Handler: server.ServerMux,
@@ -114,15 +166,91 @@ func StartServer() {
 		})
 	}
 
-	if err := serverInst.ListenAndServe(); err != nil {
+	// Now that services and handlers are registered, build the routing table
+	server.ServerMux.Compile()
+
+	for _, service := range extend.GetOrderedServices() {
+		if service.OnStart != nil {
+			if err := service.OnStart(); err != nil {
+				log.Error("Core", "Failed to start service %s (%s): %v", service.FriendlyName, service.Name, err)
+				return errors.Join(
+					fmt.Errorf("failed to start service %s: %w", service.Name, err),
+					shutdownServices(context.Background()))
+			}
+		}
+		startedServices = append(startedServices, service)
+		extend.EventServiceStarted.Notify(context.Background(), service)
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		log.Log("Core", "Goji Server ")
+		log.Success("Core", "Server listening on %s:%s", host, port)
+		listenErr <- serverInst.ListenAndServe()
+	}()
+
+	select {
+	case err := <-listenErr:
 		log.Error("Core", "Error listening on %s:%s", host, port)
+		return errors.Join(
+			fmt.Errorf("error listening on %s:%s: %w", host, port, err),
+			shutdownServices(context.Background()))
+	case <-signalCtx.Done():
+		log.Info("Core", "Shutdown requested - draining in-flight requests")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ActiveConfig.Application.ShutdownTimeout)
+	defer cancel()
+
+	var shutdownErr error
+	if err := serverInst.Shutdown(shutdownCtx); err != nil {
+		log.Error("Core", "Failed to drain in-flight requests: %v", err)
+		shutdownErr = fmt.Errorf("failed to shut down server: %w", err)
+	}
+
+	shutdownErr = errors.Join(shutdownErr, shutdownServices(shutdownCtx))
+	if err := database.Close(); err != nil {
+		shutdownErr = errors.Join(shutdownErr, fmt.Errorf("failed to close database: %w", err))
+	}
+	if shutdownErr == nil {
+		log.Success("Core", "Server shut down cleanly")
 	}
+	return shutdownErr
 }
 
 //////////////////////////////////
 // Private Methods              //
 //////////////////////////////////
 
+// shutdownServices invokes OnShutdown for every service that started, in reverse dependency order, so
+// services are torn down before the services they depend on.
+func shutdownServices(ctx context.Context) error {
+	var errs []error
+	services := startedServices
+	startedServices = nil
+	for i := len(services) - 1; i >= 0; i-- {
+		service := services[i]
+		extend.EventServiceStopping.Notify(ctx, service)
+		if service.OnShutdown == nil {
+			continue
+		}
+		log.Info("Core", "Stopping service %s", service.Name)
+		if err := service.OnShutdown(ctx); err != nil {
+			log.Error("Core", "Failed to shut down service %s (%s): %v", service.FriendlyName, service.Name, err)
+			errs = append(errs, fmt.Errorf("failed to shut down service %s: %w", service.Name, err))
+		}
+	}
+	// Give asynchronous event listeners a chance to finish before the database is closed
+	if err := extend.WaitForListeners(ctx); err != nil {
+		log.Error("Core", "%v", err)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 // loadDynamicModules loads all .so files from the modules directory and registers their services.
 // This allows for dynamic loading of additional functionality without requiring a server restart.
 // Each module must export a PluginService symbol that is a pointer to a ServiceDef.
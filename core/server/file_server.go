@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/server/httpflow"
 	"github.com/gojicms/goji/core/utils"
 	"github.com/gojicms/goji/core/utils/log"
 )
@@ -31,8 +32,15 @@ type RenderOptions struct {
 	TemplateRoot   string       // TemplateRoot the root folder for partials
 	ErrorRoot      string
 	Functions      template.FuncMap
+	Flow           *httpflow.HttpFlow // Flow The current request, if any; enables request-bound functions such as csrfField
 }
 
+// CSRFFieldName is the form field holding the CSRF token; alternatively, provide it in CSRFHeaderName
+const CSRFFieldName = "_CSRF"
+
+// CSRFHeaderName is the header holding the CSRF token for requests that are not form posts
+const CSRFHeaderName = "X-CSRF-Token"
+
 var DefaultRenderOptions = RenderOptions{
 	SkipValidation: false,
 	Data:           utils.Object{},
@@ -6,6 +6,7 @@ passed through the flow and utilities for properly responding to certain events
 package httpflow
 
 import (
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -126,10 +127,58 @@ func (f *HttpFlow) SetHeader(s string, value string) {
 	f.Writer.Header().Set(s, value)
 }
 
+// Param returns the named path segment captured by the matched route, eg. {id}
+func (f *HttpFlow) Param(name string) string {
+	return f.Request.PathValue(name)
+}
+
+// ParamInt returns the named path segment as an integer, or defaultValue if it is missing or invalid
+func (f *HttpFlow) ParamInt(name string, defaultValue int) int {
+	return utils.Stoid(f.Param(name), defaultValue)
+}
+
 func (f *HttpFlow) PostFormValue(s string) string {
 	return f.Request.PostFormValue(s)
 }
 
+// ParseMultipartForm parses a multipart request body of at most maxBytes; a larger body fails with an
+// *http.MaxBytesError. Files beyond 32MB are buffered on disk rather than in memory. A form that was already
+// parsed, eg. by middleware reading a form field, is checked against maxBytes instead.
+func (f *HttpFlow) ParseMultipartForm(maxBytes int64) error {
+	if form := f.Request.MultipartForm; form != nil {
+		if formSize(form) > maxBytes {
+			return &http.MaxBytesError{Limit: maxBytes}
+		}
+		return nil
+	}
+	f.Request.Body = http.MaxBytesReader(f.Writer, f.Request.Body, maxBytes)
+	return f.Request.ParseMultipartForm(32 << 20)
+}
+
+// FormFiles returns the files uploaded in the named field of a parsed multipart form
+func (f *HttpFlow) FormFiles(name string) []*multipart.FileHeader {
+	if f.Request.MultipartForm == nil {
+		return nil
+	}
+	return f.Request.MultipartForm.File[name]
+}
+
+// formSize returns the bytes of the values and files of a parsed multipart form
+func formSize(form *multipart.Form) int64 {
+	var size int64
+	for _, values := range form.Value {
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	for _, files := range form.File {
+		for _, file := range files {
+			size += file.Size
+		}
+	}
+	return size
+}
+
 func (f *HttpFlow) DecodeJSONBody(m *map[string]interface{}) error {
 	return utils.DecodeJSONBody(f.Request, m)
 }
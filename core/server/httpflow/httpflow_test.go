@@ -0,0 +1,52 @@
+package httpflow
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func multipartRequest(t *testing.T, fileSize int) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("_CSRF", "token")
+	file, err := form.CreateFormFile("file", "upload.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.Write([]byte(strings.Repeat("a", fileSize)))
+	_ = form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/media", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func TestParseMultipartFormLimit(t *testing.T) {
+	var maxBytesError *http.MaxBytesError
+
+	flow := &HttpFlow{Writer: httptest.NewRecorder(), Request: multipartRequest(t, 4096)}
+	if err := flow.ParseMultipartForm(1024); !errors.As(err, &maxBytesError) {
+		t.Fatalf("parsing a body over the limit: %v, want *http.MaxBytesError", err)
+	}
+
+	// Reading a form field parses the form before the handler sets its limit, which must still apply
+	flow = &HttpFlow{Writer: httptest.NewRecorder(), Request: multipartRequest(t, 4096)}
+	if got := flow.PostFormValue("_CSRF"); got != "token" {
+		t.Fatalf("form field %q", got)
+	}
+	if err := flow.ParseMultipartForm(1024); !errors.As(err, &maxBytesError) {
+		t.Fatalf("checking a parsed form over the limit: %v, want *http.MaxBytesError", err)
+	}
+	if err := flow.ParseMultipartForm(8192); err != nil {
+		t.Fatalf("checking a parsed form within the limit: %v", err)
+	}
+	if files := flow.FormFiles("file"); len(files) != 1 || files[0].Size != 4096 {
+		t.Fatalf("files %v", files)
+	}
+}
@@ -72,8 +72,15 @@ func (f *HttpFlow) WriteJsonList(limit int, offset int, total int, collectionNam
 	WriteJsonList[any](f, limit, offset, total, collectionName, data)
 }
 
+// JsonFilter transforms payloads before WriteJson encodes them; it is set by the extend package, which
+// applies its json filter
+var JsonFilter func(data any) any
+
 func WriteJson(flow *HttpFlow, data interface{}) {
 	w := flow.Writer
+	if JsonFilter != nil {
+		data = JsonFilter(data)
+	}
 	jsonResponse, err := json.Marshal(data)
 
 	if err != nil {
@@ -17,7 +17,6 @@ import (
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/utils"
 	"github.com/gojicms/goji/core/utils/log"
-	"github.com/google/uuid"
 )
 
 // TemplateConfig holds configuration for the template renderer
@@ -104,15 +103,19 @@ func init() {
 		"toTime":          func(t time.Time) string { return t.Format(time.TimeOnly) },
 		"toFuzzyTime":     func(t time.Time) string { return utils.FuzzyTime(t) },
 		// Util
-		"CSRF": func() string {
-			return strings.ReplaceAll(uuid.New().String(), "-", "")
-		},
+		// These are replaced with the session-bound token when rendering with RenderOptions.Flow; CSRF is
+		// the former name of csrfToken
+		"CSRF":      func() string { return "" },
+		"csrfToken": func() string { return "" },
+		"csrfField": func() template.HTML { return "" },
 	})
 }
 
 // RenderTemplate processes an HTML template string and replaces template expressions
 // using Go's html/template package
 func RenderTemplate(templateContent []byte, data interface{}, options RenderOptions) ([]byte, error) {
+	data = extend.FilterTemplateData.Apply(data)
+
 	templateConfig := TemplateConfig{
 		Debug: config.ActiveConfig.Application.Debug,
 		PartialLoader: func(templatePath string) ([]byte, error) {
@@ -136,6 +139,18 @@ func RenderTemplate(templateContent []byte, data interface{}, options RenderOpti
 		funcMap[k] = v
 	}
 
+	// Add the CSRF token of the current session
+	if options.Flow != nil {
+		csrfToken, _ := options.Flow.Get("csrf").(string)
+		funcMap["csrfToken"] = func() string {
+			return csrfToken
+		}
+		funcMap["CSRF"] = funcMap["csrfToken"]
+		funcMap["csrfField"] = func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + CSRFFieldName + `" value="` + template.HTMLEscapeString(csrfToken) + `"/>`)
+		}
+	}
+
 	// Add style injection support
 	var styles []string
 	funcMap["style"] = func(path string) (template.HTML, error) {
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// maxRouteCacheEntries bounds the per-path match cache; once exceeded the cache is reset.
+const maxRouteCacheEntries = 4096
+
+// route is a single compiled entry in the routing table; order preserves registration precedence,
+// services (in registration order) followed by handlers.
+type route struct {
+	order     int
+	validator *extend.HttpValidator
+	resource  *extend.ResourceDef
+	handler   *extend.Handler
+}
+
+// routeNode is a node in the literal-prefix trie. Each node holds the routes whose prefix ends at it.
+type routeNode struct {
+	children map[byte]*routeNode
+	routes   []*route
+}
+
+// routeTable is a compiled, method-partitioned index of every resource and handler. Lookups walk a
+// prefix trie to find candidate routes, regex-match only those candidates and cache the result per path.
+type routeTable struct {
+	version  uint64
+	byMethod map[string]*routeNode
+	anyRoot  *routeNode
+
+	cacheMu sync.RWMutex
+	cache   map[string][]*route
+}
+
+//////////////////////////////////
+// Type Methods                 //
+//////////////////////////////////
+
+func (n *routeNode) insert(prefix string, r *route) {
+	node := n
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = map[byte]*routeNode{}
+		}
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = &routeNode{}
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+	node.routes = append(node.routes, r)
+}
+
+// collect appends every route whose literal prefix is a prefix of path
+func (n *routeNode) collect(path string, into []*route) []*route {
+	node := n
+	into = append(into, node.routes...)
+	for i := 0; i < len(path); i++ {
+		child, ok := node.children[path[i]]
+		if !ok {
+			break
+		}
+		node = child
+		into = append(into, node.routes...)
+	}
+	return into
+}
+
+// candidates returns the routes matching the method and path, in precedence order
+func (t *routeTable) candidates(method string, path string) []*route {
+	key := method + " " + path
+
+	t.cacheMu.RLock()
+	matched, ok := t.cache[key]
+	t.cacheMu.RUnlock()
+	if ok {
+		return matched
+	}
+
+	var found []*route
+	if root, ok := t.byMethod[method]; ok {
+		found = root.collect(path, found)
+	}
+	found = t.anyRoot.collect(path, found)
+
+	matched = make([]*route, 0, len(found))
+	for _, r := range found {
+		if r.validator.Matches(method, path) {
+			matched = append(matched, r)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].order < matched[j].order
+	})
+
+	t.cacheMu.Lock()
+	if len(t.cache) >= maxRouteCacheEntries {
+		t.cache = make(map[string][]*route, maxRouteCacheEntries)
+	}
+	t.cache[key] = matched
+	t.cacheMu.Unlock()
+
+	return matched
+}
+
+// dispatch runs the first route willing to handle the flow, returning false if none did
+func (t *routeTable) dispatch(flow *httpflow.HttpFlow) bool {
+	for _, r := range t.candidates(flow.Request.Method, flow.Request.URL.Path) {
+		values := r.validator.PathValues(flow.Request.URL.Path)
+		if r.resource != nil && r.resource.WillHandle != nil && !willHandle(r.resource, flow, values) {
+			continue
+		}
+		for name, value := range values {
+			flow.Request.SetPathValue(name, value)
+		}
+		if r.resource != nil {
+			r.resource.Handler(flow)
+			return true
+		}
+		r.handler.ServeHTTP(flow)
+		return true
+	}
+	return false
+}
+
+// willHandle asks a resource whether it will handle the flow. Path values are set on a copy of the request,
+// so that those of a resource that declines are not left behind.
+func willHandle(resource *extend.ResourceDef, flow *httpflow.HttpFlow, values map[string]string) bool {
+	if len(values) == 0 {
+		return resource.WillHandle(flow)
+	}
+	original := flow.Request
+	flow.Request = original.Clone(original.Context())
+	for name, value := range values {
+		flow.Request.SetPathValue(name, value)
+	}
+	accepted := resource.WillHandle(flow)
+	flow.Request = original
+	return accepted
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// compileRoutes builds a routing table from the currently registered services and handlers
+func compileRoutes() *routeTable {
+	return buildRoutes(extend.RoutesVersion(), extend.GetServices(), extend.GetAllHandlers())
+}
+
+// buildRoutes builds a routing table from services, in registration order, followed by handlers
+func buildRoutes(version uint64, services []*extend.ServiceDef, handlers []extend.Handler) *routeTable {
+	table := &routeTable{
+		version:  version,
+		byMethod: map[string]*routeNode{},
+		anyRoot:  &routeNode{},
+		cache:    map[string][]*route{},
+	}
+
+	add := func(r *route) {
+		r.validator.EnsureRegex()
+		root := table.anyRoot
+		if !r.validator.AnyMethod() {
+			if _, ok := table.byMethod[r.validator.Method]; !ok {
+				table.byMethod[r.validator.Method] = &routeNode{}
+			}
+			root = table.byMethod[r.validator.Method]
+		}
+		root.insert(r.validator.LiteralPrefix(), r)
+	}
+
+	order := 0
+	for _, service := range services {
+		for i := range service.Resources {
+			resource := &service.Resources[i]
+			add(&route{order: order, validator: &resource.HttpValidator, resource: resource})
+			order++
+		}
+	}
+
+	for i := range handlers {
+		handler := &handlers[i]
+		add(&route{order: order, validator: &handler.HttpValidator, handler: handler})
+		order++
+	}
+
+	return table
+}
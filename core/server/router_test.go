@@ -0,0 +1,291 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+)
+
+// recorder returns a handler that records its name as the one that served the flow
+func recorder(name string) func(flow *httpflow.HttpFlow) {
+	return func(flow *httpflow.HttpFlow) {
+		flow.Set("served", name)
+	}
+}
+
+func resource(method string, path string, name string) extend.ResourceDef {
+	return extend.ResourceDef{
+		HttpValidator: extend.NewHttpValidator(method, path),
+		Handler:       recorder(name),
+	}
+}
+
+func serve(table *routeTable, method string, path string) string {
+	flow := &httpflow.HttpFlow{
+		Writer:  httptest.NewRecorder(),
+		Request: httptest.NewRequest(method, path, nil),
+	}
+	if !table.dispatch(flow) {
+		return ""
+	}
+	served, _ := flow.Get("served").(string)
+	return served
+}
+
+func TestRoutePrecedence(t *testing.T) {
+	services := []*extend.ServiceDef{
+		{Name: "first", Resources: []extend.ResourceDef{
+			resource(http.MethodGet, "^/docs/special$", "first.special"),
+			resource(http.MethodPost, "^/docs$", "first.create"),
+		}},
+		{Name: "second", Resources: []extend.ResourceDef{
+			resource(http.MethodGet, "^/docs/.+", "second.doc"),
+			resource("*", "^/any$", "second.any"),
+			{
+				HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/maybe$"),
+				Handler:       recorder("second.maybe"),
+				WillHandle:    func(flow *httpflow.HttpFlow) bool { return false },
+			},
+			resource(http.MethodGet, "/unanchored", "second.unanchored"),
+		}},
+	}
+	handlers := []extend.Handler{
+		extend.NewHandler(http.MethodGet, "^/docs/special$", recorder("handler.special")),
+		extend.NewHandler(http.MethodGet, "^/maybe$", recorder("handler.maybe")),
+		extend.NewHandler(http.MethodGet, "^/handler$", recorder("handler.only")),
+		extend.NewHandler(http.MethodDelete, "^/docs$", recorder("handler.delete")),
+	}
+	table := buildRoutes(1, services, handlers)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   string
+	}{
+		{"earlier service wins", http.MethodGet, "/docs/special", "first.special"},
+		{"later service matches", http.MethodGet, "/docs/42", "second.doc"},
+		{"resources before handlers", http.MethodGet, "/docs/special", "first.special"},
+		{"handler when no resource matches", http.MethodGet, "/handler", "handler.only"},
+		{"rejected resource falls through", http.MethodGet, "/maybe", "handler.maybe"},
+		{"method partitions routes", http.MethodPost, "/docs", "first.create"},
+		{"handler method partition", http.MethodDelete, "/docs", "handler.delete"},
+		{"unregistered method", http.MethodPut, "/docs", ""},
+		{"any method get", http.MethodGet, "/any", "second.any"},
+		{"any method patch", http.MethodPatch, "/any", "second.any"},
+		{"unanchored matches anywhere", http.MethodGet, "/prefix/unanchored/suffix", "second.unanchored"},
+		{"no match", http.MethodGet, "/missing", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Run twice, so that cached results are checked as well
+			for i := 0; i < 2; i++ {
+				if got := serve(table, test.method, test.path); got != test.want {
+					t.Fatalf("%s %s (pass %d): served by %q, want %q", test.method, test.path, i+1, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPathValues(t *testing.T) {
+	var declined string
+	table := buildRoutes(1, []*extend.ServiceDef{
+		{Name: "docs", Resources: []extend.ResourceDef{
+			{
+				HttpValidator: extend.NewHttpValidator(http.MethodGet, "/docs/{slug}"),
+				Handler:       recorder("docs.slug"),
+				WillHandle: func(flow *httpflow.HttpFlow) bool {
+					declined = flow.Request.PathValue("slug")
+					return false
+				},
+			},
+			{
+				HttpValidator: extend.NewHttpValidator(http.MethodGet, "/docs/{id}"),
+				Handler: func(flow *httpflow.HttpFlow) {
+					flow.Set("served", flow.Request.PathValue("id")+"|"+flow.Request.PathValue("slug"))
+				},
+			},
+		}},
+	}, nil)
+
+	if got := serve(table, http.MethodGet, "/docs/42"); got != "42|" {
+		t.Fatalf("served %q, want the id without the slug of the declined route", got)
+	}
+	if declined != "42" {
+		t.Fatalf("WillHandle saw slug %q, want 42", declined)
+	}
+}
+
+func TestRouteCache(t *testing.T) {
+	table := buildRoutes(1, []*extend.ServiceDef{
+		{Name: "docs", Resources: []extend.ResourceDef{resource(http.MethodGet, "^/docs/{id}", "docs.get")}},
+	}, nil)
+
+	first := table.candidates(http.MethodGet, "/docs/1")
+	if len(first) != 1 {
+		t.Fatalf("expected one candidate, got %d", len(first))
+	}
+	if _, ok := table.cache[http.MethodGet+" /docs/1"]; !ok {
+		t.Fatal("expected the match to be cached")
+	}
+	second := table.candidates(http.MethodGet, "/docs/1")
+	if &first[0] != &second[0] {
+		t.Fatal("expected the cached candidates to be reused")
+	}
+
+	// Misses are cached too, and are partitioned by method
+	if len(table.candidates(http.MethodPost, "/docs/1")) != 0 {
+		t.Fatal("expected no candidates for POST")
+	}
+	if _, ok := table.cache[http.MethodPost+" /docs/1"]; !ok {
+		t.Fatal("expected the miss to be cached")
+	}
+
+	for i := 0; i < maxRouteCacheEntries+10; i++ {
+		table.candidates(http.MethodGet, fmt.Sprintf("/docs/%d", i))
+	}
+	if len(table.cache) > maxRouteCacheEntries {
+		t.Fatalf("cache grew to %d entries, limit is %d", len(table.cache), maxRouteCacheEntries)
+	}
+}
+
+func TestServeHTTPPrecedence(t *testing.T) {
+	extend.RegisterService(&extend.ServiceDef{Name: "router_test", Resources: []extend.ResourceDef{
+		resource(http.MethodGet, "^/mw/resource$", "resource"),
+		resource(http.MethodGet, "^/mw/blocked$", "resource.blocked"),
+	}})
+	extend.AddHandler("GET ^/mw/resource$", recorder("handler"))
+	extend.AddHandler("GET ^/mw/handler$", recorder("handler"))
+
+	var order []string
+	extend.AddMiddleware(extend.NewMiddleware("*", "^/mw/", 10, func(flow *httpflow.HttpFlow) {
+		order = append(order, "middleware")
+	}))
+	extend.AddMiddleware(extend.NewMiddleware("*", "^/mw/blocked$", 20, func(flow *httpflow.HttpFlow) {
+		order = append(order, "blocker")
+		flow.WriteHeaders(http.StatusForbidden)
+		flow.Terminate()
+	}))
+
+	tests := []struct {
+		path   string
+		status int
+		order  []string
+	}{
+		{"/mw/resource", http.StatusOK, []string{"middleware"}},
+		{"/mw/handler", http.StatusOK, []string{"middleware"}},
+		{"/mw/blocked", http.StatusForbidden, []string{"middleware", "blocker"}},
+		{"/mw/missing", http.StatusNotFound, []string{"middleware"}},
+	}
+	for _, test := range tests {
+		order = nil
+		w := httptest.NewRecorder()
+		ServerMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.path, w.Code, test.status)
+		}
+		if fmt.Sprint(order) != fmt.Sprint(test.order) {
+			t.Errorf("%s: middleware ran %v, want %v", test.path, order, test.order)
+		}
+	}
+}
+
+// benchmarkRoutes builds a routing table resembling a site with many services, each with a handful of
+// resources
+func benchmarkRoutes() ([]*extend.ServiceDef, []extend.Handler) {
+	var services []*extend.ServiceDef
+	for i := 0; i < 40; i++ {
+		base := fmt.Sprintf("/api/v1/service%d", i)
+		services = append(services, &extend.ServiceDef{Name: fmt.Sprint(i), Resources: []extend.ResourceDef{
+			resource(http.MethodGet, "^"+base+"$", "list"),
+			resource(http.MethodPost, "^"+base+"$", "create"),
+			resource(http.MethodGet, base+"/{id}", "get"),
+			resource(http.MethodPut, base+"/{id}", "update"),
+			resource(http.MethodDelete, base+"/{id}", "delete"),
+		}})
+	}
+	var handlers []extend.Handler
+	for i := 0; i < 20; i++ {
+		handlers = append(handlers, extend.NewHandler(http.MethodGet, fmt.Sprintf("^/page%d$", i), recorder("page")))
+	}
+	return services, handlers
+}
+
+// linearDispatch is the routing used before the routing table: every resource of every service, then every
+// handler, is regex-matched in turn
+func linearDispatch(services []*extend.ServiceDef, handlers []extend.Handler, flow *httpflow.HttpFlow) bool {
+	for _, service := range services {
+		for _, resource := range service.Resources {
+			if resource.CanHandle(flow) {
+				resource.Handler(flow)
+				return true
+			}
+		}
+	}
+	for _, handler := range handlers {
+		if handler.CanHandle(flow) {
+			handler.ServeHTTP(flow)
+			return true
+		}
+	}
+	return false
+}
+
+var benchmarkPaths = []struct {
+	name   string
+	method string
+	path   string
+}{
+	{"first", http.MethodGet, "/api/v1/service0"},
+	{"last", http.MethodDelete, "/api/v1/service39/12"},
+	{"handler", http.MethodGet, "/page19"},
+	{"miss", http.MethodGet, "/not/found"},
+}
+
+func BenchmarkLinearDispatch(b *testing.B) {
+	services, handlers := benchmarkRoutes()
+	for _, bench := range benchmarkPaths {
+		b.Run(bench.name, func(b *testing.B) {
+			request := httptest.NewRequest(bench.method, bench.path, nil)
+			for i := 0; i < b.N; i++ {
+				linearDispatch(services, handlers, &httpflow.HttpFlow{Request: request})
+			}
+		})
+	}
+}
+
+func BenchmarkTableDispatch(b *testing.B) {
+	services, handlers := benchmarkRoutes()
+	table := buildRoutes(1, services, handlers)
+	for _, bench := range benchmarkPaths {
+		b.Run(bench.name, func(b *testing.B) {
+			request := httptest.NewRequest(bench.method, bench.path, nil)
+			for i := 0; i < b.N; i++ {
+				table.dispatch(&httpflow.HttpFlow{Request: request})
+			}
+		})
+	}
+}
+
+// BenchmarkTableDispatchUncached measures lookups of paths that are never repeated, so that every lookup
+// walks the trie
+func BenchmarkTableDispatchUncached(b *testing.B) {
+	services, handlers := benchmarkRoutes()
+	table := buildRoutes(1, services, handlers)
+	requests := make([]*http.Request, 1024)
+	for i := range requests {
+		requests[i] = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/service39/%d", i), nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(requests) == 0 {
+			table.cache = map[string][]*route{}
+		}
+		table.dispatch(&httpflow.HttpFlow{Request: requests[i%len(requests)]})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/utils"
 )
 
@@ -30,6 +31,7 @@ func WriteRedirect(w http.ResponseWriter, url string) {
 }
 
 func WriteJson(w http.ResponseWriter, data interface{}) {
+	data = extend.FilterJson.Apply(data)
 	jsonResponse, err := json.Marshal(data)
 
 	if err != nil {
@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server/httpflow"
@@ -13,7 +14,25 @@ var ServerMux = &GojiServerMux{
 }
 
 type GojiServerMux struct {
-	mux *http.ServeMux
+	mux    *http.ServeMux
+	routes atomic.Pointer[routeTable]
+}
+
+// Compile builds the routing table from all registered services and handlers. It is invoked once services
+// have been initialised; should routes change afterward, the table is rebuilt on the next request.
+func (server *GojiServerMux) Compile() {
+	server.routes.Store(compileRoutes())
+}
+
+// table returns the compiled routing table, rebuilding it if it is missing or stale
+func (server *GojiServerMux) table() *routeTable {
+	table := server.routes.Load()
+	if table == nil || table.version != extend.RoutesVersion() {
+		log.Debug("Server", "Routing table is stale - recompiling")
+		table = compileRoutes()
+		server.routes.Store(table)
+	}
+	return table
 }
 
 // Handle is a lightweight API for handling callbacks
@@ -47,25 +66,9 @@ func (server *GojiServerMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// We'll check services first; in the future, we'll cache requests to which resource/handler they may be tied to
-	for _, service := range extend.GetServices() {
-		log.Debug("Server", "Service %s", service.FriendlyName)
-		for _, resource := range service.Resources {
-			log.Debug("Server", "Resource %s", resource.Path)
-			if resource.CanHandle(flow) {
-				log.Debug("Server", "Running Service %s > Resource %s", service.FriendlyName, resource.Path)
-				resource.Handler(flow)
-				return
-			}
-		}
-	}
-
-	for _, handler := range extend.GetAllHandlers() {
-		if handler.CanHandle(flow) {
-			log.Debug("Server", "Running Handler %s", handler.Path)
-			handler.ServeHTTP(flow)
-			return
-		}
+	// Services are checked first, in registration order, followed by handlers
+	if server.table().dispatch(flow) {
+		return
 	}
 
 	log.Warn("Server", "No handler found")
@@ -2,12 +2,14 @@ package admin
 
 import (
 	_ "embed"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server"
 	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/twofactor"
 	"github.com/gojicms/goji/core/services/auth/users"
 	"github.com/gojicms/goji/core/services/sessions"
 	"github.com/gojicms/goji/core/utils"
@@ -50,12 +52,17 @@ func resHandler(flow *httpflow.HttpFlow) {
 }
 
 var renderLoginPage = func(flow *httpflow.HttpFlow) {
+	renderPublicPage(flow, "admin/login.html")
+}
+
+// renderPublicPage renders a page of the admin panel that is shown without a session, such as the login page
+func renderPublicPage(flow *httpflow.HttpFlow, path string) {
 	templateData := flow.Get("templateData")
 	if templateData == nil {
 		templateData = utils.Object{}
 	}
 
-	res, err := server.RenderFile("admin/login.html", server.RenderOptions{
+	res, err := server.RenderFile(path, server.RenderOptions{
 		TemplateRoot: "admin/!partials",
 		Data:         templateData.(utils.Object),
 	})
@@ -77,6 +84,9 @@ var loginHandler = func(flow *httpflow.HttpFlow) {
 	if user != nil {
 		flow.Redirect("/admin/dashboard", http.StatusFound)
 	}
+	if flow.Request.URL.Query().Get("reset") == "done" {
+		flow.Append("templateData", "message", "Your password has been reset; you may now log in.")
+	}
 	renderLoginPage(flow)
 }
 
@@ -89,7 +99,6 @@ var logoutHandler = func(flow *httpflow.HttpFlow) {
 var loginPostHandler = func(flow *httpflow.HttpFlow) {
 	username := flow.PostFormValue("username")
 	password := flow.PostFormValue("password")
-	nonce := flow.PostFormValue("_CSRF")
 
 	loginError := "Invalid username or password"
 
@@ -97,21 +106,30 @@ var loginPostHandler = func(flow *httpflow.HttpFlow) {
 		loginError = "Username or password is empty"
 	}
 
-	user, err := users.ValidateLogin(username, password)
+	user, err := users.Login(flow.Request.Context(), username, password, flow.Request.RemoteAddr)
 	if err != nil {
 		flow.Append("templateData", "error", loginError)
 		renderLoginPage(flow)
 		return
 	}
-	log.Debug("Admin", "User Found", user)
+	log.Debug("Admin", "User Found: %s", user.Username)
 
 	if user.HasPermission("admin") == false {
 		flow.Append("templateData", "error", "You are not an admin and cannot access this page.")
 		renderLoginPage(flow)
+		return
+	}
 
+	// Users with two-factor authentication enter a code before their session is created
+	if twofactor.IsEnabled(user.ID) {
+		beginTwoFactorLogin(flow, user.ID)
+		return
 	}
 
-	_, _ = sessions.CreateSession(flow, nonce, user.ID)
+	// Users whose group requires two-factor authentication but who have not set it up are given a session
+	// that only reaches the enrollment page: the admin middleware below redirects every other page there,
+	// and the auth service refuses their API requests
+	_, _ = sessions.CreateSession(flow, user.ID)
 	flow.Redirect("/admin/dashboard", http.StatusFound)
 	return
 }
@@ -167,12 +185,21 @@ var Service = extend.ServiceDef{
 	FriendlyName: "Administration Panel Service",
 	Resources: []extend.ResourceDef{
 		publicResource,
+		verifyLoginResource,
+		doVerifyLoginResource,
+		forgotPasswordResource,
+		doForgotPasswordResource,
+		resetPasswordResource,
+		doResetPasswordResource,
 		loginResource,
 		doLoginResource,
 		logoutResource,
 		subRouteResource,
 		rootResource,
 	},
+	Permissions: []extend.PermissionDef{
+		{Name: "admin", Description: "Access the administration panel", DefaultGroups: []string{"administrator", "editor"}},
+	},
 	OnInit: func() error {
 		extend.AddSideMenuItem("Home", "dashboard", 0, "", "")
 		extend.AddSideMenuItem("System", "#", 500, "", "admin")
@@ -182,10 +209,14 @@ var Service = extend.ServiceDef{
 			Route: "dashboard",
 			Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
 				flow.Append("templateData", "title", "Goji - Welcome")
-				return server.RenderTemplate(dashboardTemplate, flow.Get("templateData"), server.DefaultRenderOptions)
+				return server.RenderTemplate(dashboardTemplate, flow.Get("templateData"), server.RenderOptions{Flow: flow})
 			},
 		})
 
+		// The login form is posted before a session exists, or while replacing one
+		sessions.ExemptFromCSRF("^/admin/login$")
+		sessions.ExemptFromCSRF("^/admin/login/(forgot|reset|verify)$")
+
 		extend.AddMiddleware(extend.NewMiddleware("*", "^/admin", 50, func(flow *httpflow.HttpFlow) {
 			requestPath := flow.Request.URL.Path
 
@@ -197,6 +228,16 @@ var Service = extend.ServiceDef{
 				log.Debug("Admin", "Session not found - Directing user to login")
 				flow.Redirect("/admin/login", http.StatusFound)
 				flow.Terminate()
+				return
+			}
+
+			// Groups may require their members to set up two-factor authentication before going any further
+			if user, ok := flow.Get("user").(*users.User); ok && twofactor.IsRequired(user) && !twofactor.IsEnabled(user.ID) {
+				enrollPath := fmt.Sprintf("/admin/users/%d/two-factor", user.ID)
+				if requestPath != enrollPath && requestPath != "/admin/logout" {
+					flow.Redirect(enrollPath, http.StatusFound)
+					flow.Terminate()
+				}
 			}
 		}))
 		return nil
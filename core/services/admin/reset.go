@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/resets"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Private Methods - Handlers   //
+//////////////////////////////////
+
+var forgotPasswordHandler = func(flow *httpflow.HttpFlow) {
+	renderPublicPage(flow, "admin/forgot.html")
+}
+
+var forgotPasswordPostHandler = func(flow *httpflow.HttpFlow) {
+	err := resets.Request(flow.Request.Context(), flow.PostFormValue("username"), flow.Request.RemoteAddr,
+		config.ActiveConfig.Application.RootUrl)
+	if errors.Is(err, resets.ErrNoRootUrl) {
+		log.Warn("Admin", "A password reset was requested, but root_url is not configured")
+		flow.Append("templateData", "error", "Password resets are not available until the address of the site is configured.")
+		renderPublicPage(flow, "admin/forgot.html")
+		return
+	}
+	if err != nil {
+		log.Error("Admin", "Failed to send a password reset: %s", err.Error())
+		flow.Append("templateData", "error", "The reset email could not be sent; please try again later.")
+		renderPublicPage(flow, "admin/forgot.html")
+		return
+	}
+
+	// The same message is shown whether or not the account exists
+	flow.Append("templateData", "message", "If the account exists and has an email address, a link to reset its password has been sent to it.")
+	renderPublicPage(flow, "admin/forgot.html")
+}
+
+var resetPasswordHandler = func(flow *httpflow.HttpFlow) {
+	token := flow.Request.URL.Query().Get("token")
+	if _, err := resets.Validate(token); err != nil {
+		flow.Append("templateData", "invalid", true)
+		flow.Append("templateData", "error", resets.ErrInvalidToken.Error())
+	}
+	flow.Append("templateData", "token", token)
+	renderPublicPage(flow, "admin/reset.html")
+}
+
+var resetPasswordPostHandler = func(flow *httpflow.HttpFlow) {
+	token := flow.PostFormValue("token")
+	password := flow.PostFormValue("password")
+	flow.Append("templateData", "token", token)
+
+	if password != flow.PostFormValue("confirm") {
+		flow.Append("templateData", "error", "The passwords do not match.")
+		renderPublicPage(flow, "admin/reset.html")
+		return
+	}
+
+	if _, err := resets.Complete(flow.Request.Context(), token, password); err != nil {
+		if errors.Is(err, resets.ErrInvalidToken) {
+			flow.Append("templateData", "invalid", true)
+		}
+		flow.Append("templateData", "error", err.Error())
+		renderPublicPage(flow, "admin/reset.html")
+		return
+	}
+
+	flow.Redirect("/admin/login?reset=done", http.StatusFound)
+}
+
+//////////////////////////////////
+// Resource Definitions         //
+//////////////////////////////////
+
+// These precede the login resources, whose paths they would otherwise match
+var forgotPasswordResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/admin/login/forgot$"),
+	Handler:       forgotPasswordHandler,
+}
+
+var doForgotPasswordResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "^/admin/login/forgot$"),
+	Handler:       forgotPasswordPostHandler,
+}
+
+var resetPasswordResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^"+resets.ResetPath+"$"),
+	Handler:       resetPasswordHandler,
+}
+
+var doResetPasswordResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "^"+resets.ResetPath+"$"),
+	Handler:       resetPasswordPostHandler,
+}
@@ -2,8 +2,10 @@ package admin
 
 import (
 	_ "embed"
+	"errors"
 	"html/template"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gojicms/goji/core/extend"
@@ -67,7 +69,8 @@ func subRouteHandler(flow *httpflow.HttpFlow) {
 	var user *users.User
 	user = flow.Get("user").(*users.User)
 
-	flow.Append("templateData", "sideNav", sideNavToScopedObject(*user, flow.Request.URL.Path, extend.GetSideMenuItems()))
+	sideNav := extend.FilterSideNav.Apply(slices.Clone(extend.GetSideMenuItems()))
+	flow.Append("templateData", "sideNav", sideNavToScopedObject(*user, flow.Request.URL.Path, sideNav))
 
 	renderServerError := func(serveError *server.HttpServeError) {
 		res := server.RenderErrorPage(http.StatusInternalServerError, "Internal Server Error", server.RenderOptions{
@@ -86,6 +89,7 @@ func subRouteHandler(flow *httpflow.HttpFlow) {
 	if route, extras := extend.GetAdminPageByRoute(r.URL.Path); route != nil {
 		var rendered []byte
 		var err error
+		status := http.StatusOK
 
 		if route.Permission == "" || user.HasPermission(route.Permission) {
 			for k, v := range extras {
@@ -109,13 +113,17 @@ func subRouteHandler(flow *httpflow.HttpFlow) {
 				rendered, err = route.Render(flow)
 			}()
 		} else {
+			err = extend.ErrForbidden
+		}
+
+		if errors.Is(err, extend.ErrForbidden) {
 			log.Error("Security", "User %s (%d) attempted to access %s", user.DisplayName, user.ID, r.URL.Path)
-			rendered = forbidden
+			rendered, err, status = forbidden, nil, http.StatusForbidden
 		}
 
 		if err != nil {
 			renderServerError(nil)
-			log.Error("Admin/Subroutes", "An unknown error has occurred", err)
+			log.Error("Admin/Subroutes", "An unknown error has occurred: %v", err)
 			return
 		}
 
@@ -134,6 +142,7 @@ func subRouteHandler(flow *httpflow.HttpFlow) {
 				},
 			},
 			Data: flow.Get("templateData").(utils.Object),
+			Flow: flow,
 		})
 
 		if serr != nil {
@@ -142,7 +151,7 @@ func subRouteHandler(flow *httpflow.HttpFlow) {
 		}
 
 		w.Header().Set("Content-Type", res.ContentType)
-		w.WriteHeader(200)
+		w.WriteHeader(status)
 		_, _ = w.Write(res.Body)
 		return
 	}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/twofactor"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/services/sessions"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+const verifyLoginPath = "/admin/login/verify"
+
+const expiredLoginMessage = "Your login has expired; please log in again."
+
+//////////////////////////////////
+// Private Methods - Handlers   //
+//////////////////////////////////
+
+// beginTwoFactorLogin starts the second login step of a user whose password has been checked, identifying
+// the login with a short-lived cookie
+func beginTwoFactorLogin(flow *httpflow.HttpFlow, userId uint) {
+	token, err := twofactor.NewChallenge(userId)
+	if err != nil {
+		flow.Append("templateData", "error", "Failed to log in; please try again.")
+		renderLoginPage(flow)
+		return
+	}
+	setChallengeCookie(flow, token, time.Now().Add(twofactor.ChallengeLifetime))
+	flow.Redirect(verifyLoginPath, http.StatusFound)
+}
+
+var verifyLoginHandler = func(flow *httpflow.HttpFlow) {
+	if _, err := twofactor.GetChallenge(challengeToken(flow)); err != nil {
+		flow.Redirect("/admin/login", http.StatusFound)
+		return
+	}
+	renderPublicPage(flow, "admin/verify.html")
+}
+
+var verifyLoginPostHandler = func(flow *httpflow.HttpFlow) {
+	challenge, err := twofactor.GetChallenge(challengeToken(flow))
+	if err != nil {
+		flow.Append("templateData", "error", expiredLoginMessage)
+		renderLoginPage(flow)
+		return
+	}
+	user, err := users.GetById(challenge.UserId)
+	if err != nil {
+		challenge.End()
+		flow.Append("templateData", "error", expiredLoginMessage)
+		renderLoginPage(flow)
+		return
+	}
+
+	if err := twofactor.Verify(flow.Request.Context(), user, flow.PostFormValue("code"), flow.Request.RemoteAddr); err != nil {
+		if failErr := challenge.Fail(); errors.Is(failErr, twofactor.ErrTooManyAttempts) {
+			setChallengeCookie(flow, "", time.Unix(0, 0))
+			flow.Append("templateData", "error", "Too many invalid codes; please log in again.")
+			renderLoginPage(flow)
+			return
+		}
+		flow.Append("templateData", "error", "The code is invalid; please try again.")
+		renderPublicPage(flow, "admin/verify.html")
+		return
+	}
+
+	challenge.End()
+	setChallengeCookie(flow, "", time.Unix(0, 0))
+	log.Debug("Admin", "User %s passed two-factor authentication", user.Username)
+
+	_, _ = sessions.CreateSession(flow, user.ID)
+	flow.Redirect("/admin/dashboard", http.StatusFound)
+}
+
+func challengeToken(flow *httpflow.HttpFlow) string {
+	cookie, err := flow.Request.Cookie(challengeCookieName())
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func setChallengeCookie(flow *httpflow.HttpFlow, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     challengeCookieName(),
+		Value:    token,
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+		Path:     verifyLoginPath,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	flow.SetCookie(cookie)
+}
+
+func challengeCookieName() string {
+	return config.ActiveConfig.Application.Auth.CookieId + "_2FA"
+}
+
+//////////////////////////////////
+// Resource Definitions         //
+//////////////////////////////////
+
+var verifyLoginResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^"+verifyLoginPath+"$"),
+	Handler:       verifyLoginHandler,
+}
+
+var doVerifyLoginResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "^"+verifyLoginPath+"$"),
+	Handler:       verifyLoginPostHandler,
+}
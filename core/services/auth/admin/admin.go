@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server"
 	"github.com/gojicms/goji/core/server/httpflow"
 	"github.com/gojicms/goji/core/services/auth/groups"
+	"github.com/gojicms/goji/core/services/auth/tokens"
+	"github.com/gojicms/goji/core/services/auth/twofactor"
 	"github.com/gojicms/goji/core/services/auth/users"
 	"github.com/gojicms/goji/core/utils"
 )
@@ -22,7 +25,10 @@ var editorHtml []byte
 
 func Register() {
 	extend.AddSideMenuItem("Users", "users", 10, "System", "user:view")
-	extend.AddSideMenuItem("Groups", "groups", 20, "System", "admin")
+	extend.AddSideMenuItem("Groups", "groups", 20, "System", "group:view")
+
+	registerGroupPages()
+	registerTwoFactorPages()
 
 	extend.AddAdminPage(extend.AdminPage{
 		Permission: "user:view",
@@ -41,7 +47,7 @@ func Register() {
 				"offset":    offset,
 				"count":     count,
 				"itemCount": userCount,
-			}, server.DefaultRenderOptions)
+			}, server.RenderOptions{Flow: flow})
 			if err != nil {
 				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
 				return d, nil
@@ -68,9 +74,6 @@ func Register() {
 			}
 
 			if flow.Request.Method == "POST" {
-				result["status"] = "success"
-				result["message"] = "Document created."
-
 				displayName := flow.PostFormValue("display_name")
 				userName := flow.PostFormValue("user_name")
 				email := flow.PostFormValue("email")
@@ -83,6 +86,17 @@ func Register() {
 					goto render
 				}
 
+				// Users may only be put in groups whose permissions the acting user holds
+				groupObj, err := groups.GetByName(group)
+				if err == nil {
+					err = groups.CheckGrants(flow.Get("user").(*users.User).Group, nil, groupObj.Permissions)
+				}
+				if err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to create user: " + err.Error()
+					goto render
+				}
+
 				var user = users.User{
 					Username:    userName,
 					DisplayName: displayName,
@@ -91,15 +105,20 @@ func Register() {
 					Email:       email,
 				}
 
-				_, err = users.Create(&user)
-
+				if _, err := users.Create(&user); err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to create user: " + err.Error()
+					goto render
+				}
+				result["status"] = "success"
+				result["message"] = "User created."
 			}
 		render:
 			content, err := server.RenderTemplate(editorHtml, utils.Object{
 				"groups": allGroups,
 				"create": true,
 				"result": result,
-			}, server.DefaultRenderOptions)
+			}, server.RenderOptions{Flow: flow})
 			if err != nil {
 				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
 				return d, nil
@@ -122,6 +141,9 @@ func Register() {
 				"message": nil,
 			}
 
+			var newToken string
+			var userTokens []tokens.Token
+
 			user, err := users.GetById(uint(idInt))
 			if err != nil {
 				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
@@ -137,7 +159,63 @@ func Register() {
 			if flow.Request.Method == "POST" {
 				action := flow.PostFormValue("action")
 
+				if action == "create_token" || action == "revoke_token" {
+					// Users may manage their own tokens; managing another user's tokens requires user:edit
+					currentUser := flow.Get("user").(*users.User)
+					if currentUser.ID != user.ID && !currentUser.HasPermission("user:edit") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to manage this user's tokens."
+						goto render
+					}
+				}
+
+				if action == "create_token" {
+					newToken, err = createToken(flow, user.ID)
+					if err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to create token: " + err.Error()
+						goto render
+					}
+					result["status"] = "success"
+					result["message"] = "Token created. Copy it now; it will not be shown again."
+				}
+				if action == "revoke_token" {
+					tokenId, _ := strconv.Atoi(flow.PostFormValue("token_id"))
+					err = tokens.Revoke(user.ID, uint(tokenId))
+					if err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to revoke token: " + err.Error()
+						goto render
+					}
+					result["status"] = "success"
+					result["message"] = "Token revoked."
+				}
+				if action == "reset_two_factor" {
+					currentUser := flow.Get("user").(*users.User)
+					if !currentUser.HasPermission("user:edit") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to reset this user's two-factor authentication."
+						goto render
+					}
+					// Users turn off their own two-factor authentication with a code, from their two-factor page
+					if currentUser.ID == user.ID {
+						result["status"] = "error"
+						result["message"] = "You cannot reset your own two-factor authentication; manage it from your two-factor page."
+						goto render
+					}
+					err = twofactor.Disable(flow.Request.Context(), user, currentUser)
+					if err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to reset two-factor authentication: " + err.Error()
+						goto render
+					}
+					result["status"] = "success"
+					result["message"] = "Two-factor authentication was reset; the user will be asked to set it up again if their group requires it."
+				}
 				if action == "delete" {
+					if !flow.Get("user").(*users.User).HasPermission("user:delete") {
+						return nil, extend.ErrForbidden
+					}
 					err := users.Delete(user)
 					if err != nil {
 						result["status"] = "error"
@@ -147,6 +225,9 @@ func Register() {
 					flow.Redirect("/admin/users", http.StatusFound)
 				}
 				if action == "save" {
+					if !flow.Get("user").(*users.User).HasPermission("user:edit") {
+						return nil, extend.ErrForbidden
+					}
 					result["status"] = "success"
 					result["message"] = "User updated successfully"
 
@@ -156,6 +237,14 @@ func Register() {
 					email := flow.PostFormValue("email")
 
 					groupObj, err := groups.GetByName(group)
+					if err == nil {
+						// Moving a user to another group grants its permissions, so the acting user must hold them
+						var previous []string
+						if user.Group != nil {
+							previous = user.Group.Permissions
+						}
+						err = groups.CheckGrants(flow.Get("user").(*users.User).Group, previous, groupObj.Permissions)
+					}
 					if err != nil {
 						result["status"] = "error"
 						result["message"] = "Failed to update user: " + err.Error()
@@ -180,11 +269,20 @@ func Register() {
 			}
 
 		render:
+			userTokens, _ = tokens.GetByUser(user.ID)
+			currentUser := flow.Get("user").(*users.User)
 			content, err := server.RenderTemplate(editorHtml, utils.Object{
-				"user":   user,
-				"groups": allGroups,
-				"result": result,
-			}, server.DefaultRenderOptions)
+				"user":              user,
+				"groups":            allGroups,
+				"result":            result,
+				"tokens":            userTokens,
+				"newToken":          newToken,
+				"isSelf":            currentUser.ID == user.ID,
+				"canEdit":           currentUser.HasPermission("user:edit"),
+				"canDelete":         currentUser.HasPermission("user:delete"),
+				"twoFactorEnabled":  twofactor.IsEnabled(user.ID),
+				"twoFactorRequired": twofactor.IsRequired(user),
+			}, server.RenderOptions{Flow: flow})
 			if err != nil {
 				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
 				return d, nil
@@ -193,3 +291,19 @@ func Register() {
 		},
 	})
 }
+
+// createToken mints an API token for the user from the token form of the user editor
+func createToken(flow *httpflow.HttpFlow, userId uint) (string, error) {
+	if err := flow.Request.ParseForm(); err != nil {
+		return "", err
+	}
+
+	var expiresAt *time.Time
+	if days := utils.Stoid(flow.PostFormValue("token_expiry"), 0); days > 0 {
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	_, value, err := tokens.Create(userId, flow.PostFormValue("token_name"), flow.Request.PostForm["token_scopes"], expiresAt)
+	return value, err
+}
@@ -0,0 +1,338 @@
+package admin
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/groups"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//go:embed groups_listing.gohtml
+var groupsListingHtml []byte
+
+//go:embed groups_editor.gohtml
+var groupsEditorHtml []byte
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// permissionActions maps the buttons of the gc-permission-editor element, in bit order, to the action
+// suffix of a permission, eg. the second bit of the "user" resource grants user:edit
+var permissionActions = []string{"view", "edit", "add", "delete", "execute"}
+
+// resourcePermission is a row of the group editor, a resource and the bitmask of the actions granted on it
+type resourcePermission struct {
+	Name  string
+	Value int
+}
+
+// flagPermission is a permission that does not follow the resource:action convention, such as admin,
+// or a wildcard
+type flagPermission struct {
+	Name        string
+	Description string
+	Granted     bool
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func registerGroupPages() {
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "group:view",
+		Route:      "groups",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Groups")
+
+			allGroups, err := groups.GetAll()
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			userCounts := map[string]int64{}
+			for _, group := range allGroups {
+				userCounts[group.Name], _ = groups.CountUsers(group.Name)
+			}
+
+			content, err := server.RenderTemplate(groupsListingHtml, utils.Object{
+				"items":      allGroups,
+				"userCounts": userCounts,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "group:add",
+		Route:      "groups/new",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Create Group")
+
+			group := groups.Group{}
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				group.Name = strings.TrimSpace(flow.PostFormValue("name"))
+				group.Permissions = permissionsFromForm(flow)
+				group.RequireTwoFactor = flow.PostFormValue("require_two_factor") != ""
+
+				currentUser := flow.Get("user").(*users.User)
+				err := groups.CheckGrants(currentUser.Group, nil, group.Permissions)
+				if err == nil {
+					err = groups.Create(&group)
+				}
+				if err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to create group: " + err.Error()
+				} else {
+					flow.Redirect(fmt.Sprintf("/admin/groups/%d", group.ID), http.StatusFound)
+					return []byte{}, nil
+				}
+			}
+
+			return renderGroupEditor(flow, &group, true, result)
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "group:view",
+		Route:      "groups/{id}",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Edit Group")
+
+			id, _ := strconv.Atoi(flow.GetKvp("admin_meta", "id"))
+			group, err := groups.GetById(uint(id))
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				currentUser := flow.Get("user").(*users.User)
+
+				switch flow.PostFormValue("action") {
+				case "save":
+					if !currentUser.HasPermission("group:edit") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to edit groups."
+						break
+					}
+
+					previous := group.Permissions
+					group.Name = strings.TrimSpace(flow.PostFormValue("name"))
+					group.Permissions = permissionsFromForm(flow)
+					group.RequireTwoFactor = flow.PostFormValue("require_two_factor") != ""
+
+					if err := groups.CheckGrants(currentUser.Group, previous, group.Permissions); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to update group: " + err.Error()
+						break
+					}
+					if err := groups.Update(group); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to update group: " + err.Error()
+						break
+					}
+					result["status"] = "success"
+					result["message"] = "Group updated successfully"
+				case "delete":
+					if !currentUser.HasPermission("group:delete") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to delete groups."
+						break
+					}
+
+					if err := groups.Delete(group, flow.PostFormValue("reassign_to")); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to delete group: " + err.Error()
+						break
+					}
+					flow.Redirect("/admin/groups", http.StatusFound)
+					return []byte{}, nil
+				}
+			}
+
+			return renderGroupEditor(flow, group, false, result)
+		},
+	})
+}
+
+// permissionsToMasks splits permissions into the bitmasks of each resource, as used by the
+// gc-permission-editor element, and the remaining permissions which do not name a known action
+func permissionsToMasks(permissions []string) (map[string]int, []string) {
+	masks := map[string]int{}
+	var flags []string
+	for _, permission := range permissions {
+		if permission == "" {
+			continue
+		}
+		resource, action, found := strings.Cut(permission, ":")
+		bit := actionBit(action)
+		if !found || bit == 0 {
+			flags = append(flags, permission)
+			continue
+		}
+		masks[resource] |= bit
+	}
+	return masks, flags
+}
+
+// maskToPermissions expands a gc-permission-editor bitmask into the permissions of the resource
+func maskToPermissions(resource string, mask int) []string {
+	var permissions []string
+	for i, action := range permissionActions {
+		if mask&(1<<i) != 0 {
+			permissions = append(permissions, resource+":"+action)
+		}
+	}
+	return permissions
+}
+
+func actionBit(action string) int {
+	for i, a := range permissionActions {
+		if a == action {
+			return 1 << i
+		}
+	}
+	return 0
+}
+
+// knownPermissions collects the resources and flags declared by services or used by any group, so each
+// may be granted in the editor, along with the wildcards covering them
+func knownPermissions() ([]string, []string) {
+	names := []string{"*"}
+	for _, permission := range extend.GetPermissions() {
+		names = append(names, permission.Name)
+	}
+
+	allGroups, _ := groups.GetAll()
+	for _, group := range allGroups {
+		names = append(names, group.Permissions...)
+	}
+
+	resourceSet := map[string]bool{}
+	flagSet := map[string]bool{}
+	masks, flags := permissionsToMasks(names)
+	for resource := range masks {
+		resourceSet[resource] = true
+		flagSet[resource+":*"] = true
+	}
+	for _, flag := range flags {
+		flagSet[flag] = true
+	}
+
+	return sortedKeys(resourceSet), sortedKeys(flagSet)
+}
+
+// describePermission returns the description of a declared permission or wildcard
+func describePermission(name string) string {
+	if name == "*" {
+		return "Every permission, including those added later"
+	}
+	if resource, ok := strings.CutSuffix(name, ":*"); ok {
+		return "Every " + resource + " permission"
+	}
+	if permission, ok := extend.GetPermission(name); ok {
+		return permission.Description
+	}
+	return ""
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// permissionsFromForm reads the permissions submitted by the group editor
+func permissionsFromForm(flow *httpflow.HttpFlow) utils.CSV {
+	permissions := utils.CSV{}
+	if err := flow.Request.ParseForm(); err != nil {
+		return permissions
+	}
+
+	for key, values := range flow.Request.PostForm {
+		resource, ok := strings.CutPrefix(key, "resource_")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		mask, _ := strconv.Atoi(values[0])
+		permissions = append(permissions, maskToPermissions(resource, mask)...)
+	}
+
+	permissions = append(permissions, flow.Request.PostForm["flags"]...)
+
+	for _, permission := range strings.Split(flow.PostFormValue("additional"), ",") {
+		if permission = strings.TrimSpace(permission); permission != "" && !permissions.Includes(permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	sort.Strings(permissions)
+	return permissions
+}
+
+func renderGroupEditor(flow *httpflow.HttpFlow, group *groups.Group, create bool, result utils.Object) ([]byte, error) {
+	resourceNames, flagNames := knownPermissions()
+	masks, granted := permissionsToMasks(group.Permissions)
+
+	resources := make([]resourcePermission, 0, len(resourceNames))
+	for _, name := range resourceNames {
+		resources = append(resources, resourcePermission{Name: name, Value: masks[name]})
+	}
+
+	grantedFlags := utils.CSV(granted)
+	flags := make([]flagPermission, 0, len(flagNames))
+	for _, name := range flagNames {
+		flags = append(flags, flagPermission{
+			Name:        name,
+			Description: describePermission(name),
+			Granted:     grantedFlags.Includes(name),
+		})
+	}
+
+	allGroups, _ := groups.GetAll()
+	userCount, _ := groups.CountUsers(group.Name)
+
+	content, err := server.RenderTemplate(groupsEditorHtml, utils.Object{
+		"group":     group,
+		"groups":    allGroups,
+		"userCount": userCount,
+		"resources": resources,
+		"flags":     flags,
+		"create":    create,
+		"result":    result,
+	}, server.RenderOptions{Flow: flow})
+	if err != nil {
+		d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+		return d, nil
+	}
+	return content, nil
+}
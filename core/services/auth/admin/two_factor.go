@@ -0,0 +1,138 @@
+package admin
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/twofactor"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+	"github.com/gojicms/goji/core/utils/qrcode"
+)
+
+//go:embed two_factor.gohtml
+var twoFactorHtml []byte
+
+// registerTwoFactorPages adds the page users set up two-factor authentication on. It is open to every user,
+// but only for their own account; administrators reset other users from the user editor.
+func registerTwoFactorPages() {
+	extend.AddAdminPage(extend.AdminPage{
+		Route: "users/{id}/two-factor",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Two-Factor Authentication")
+
+			user := flow.Get("user").(*users.User)
+			if fmt.Sprint(user.ID) != flow.GetKvp("admin_meta", "id") {
+				return []byte("<b>Two-factor authentication can only be set up by the user themself.</b>"), nil
+			}
+
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+			var recoveryCodes []string
+
+			if flow.Request.Method == "POST" {
+				code := flow.PostFormValue("code")
+
+				switch flow.PostFormValue("action") {
+				case "enable":
+					codes, err := twofactor.Enable(flow.Request.Context(), user, code)
+					if err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to enable two-factor authentication: " + err.Error()
+						break
+					}
+					recoveryCodes = codes
+					result["status"] = "success"
+					result["message"] = "Two-factor authentication is enabled."
+				case "regenerate":
+					if err := twofactor.Verify(flow.Request.Context(), user, code, flow.Request.RemoteAddr); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to create recovery codes: " + err.Error()
+						break
+					}
+					codes, err := twofactor.RegenerateRecoveryCodes(user.ID)
+					if err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to create recovery codes: " + err.Error()
+						break
+					}
+					recoveryCodes = codes
+					result["status"] = "success"
+					result["message"] = "New recovery codes were created; the old ones no longer work."
+				case "disable":
+					if twofactor.IsRequired(user) {
+						result["status"] = "error"
+						result["message"] = "Your group requires two-factor authentication."
+						break
+					}
+					if err := twofactor.Verify(flow.Request.Context(), user, code, flow.Request.RemoteAddr); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to disable two-factor authentication: " + err.Error()
+						break
+					}
+					if err := twofactor.Disable(flow.Request.Context(), user, user); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to disable two-factor authentication: " + err.Error()
+						break
+					}
+					result["status"] = "success"
+					result["message"] = "Two-factor authentication is disabled."
+				}
+			}
+
+			data := utils.Object{
+				"user":          user,
+				"required":      twofactor.IsRequired(user),
+				"recoveryCodes": recoveryCodes,
+				"result":        result,
+			}
+
+			twoFactor, err := twofactor.GetByUser(user.ID)
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			if twoFactor != nil && twoFactor.Enabled {
+				remaining, _ := twofactor.CountRecoveryCodes(user.ID)
+				data["twoFactor"] = twoFactor
+				data["remaining"] = remaining
+			} else {
+				// Show the pending secret to add to an authenticator app
+				if twoFactor, err = twofactor.Begin(user); err != nil {
+					d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+					return d, nil
+				}
+				qr, err := qrcode.Encode(twofactor.ProvisioningUri(twofactor.Issuer, user.Username, twoFactor.Secret))
+				if err != nil {
+					d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+					return d, nil
+				}
+				data["qrCode"] = template.HTML(qr.SVG(4))
+				data["secret"] = groupSecret(twoFactor.Secret)
+			}
+
+			content, err := server.RenderTemplate(twoFactorHtml, data, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+}
+
+// groupSecret splits a secret into groups of four characters, to make it easier to type
+func groupSecret(secret string) string {
+	var groups []string
+	for i := 0; i < len(secret); i += 4 {
+		groups = append(groups, secret[i:min(i+4, len(secret))])
+	}
+	return strings.Join(groups, " ")
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/groups"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// groupRequest is the body accepted when creating or updating a group
+type groupRequest struct {
+	Name        *string  `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+//////////////////////////////////
+// Resource Definitions         //
+//////////////////////////////////
+
+var getGroupsResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/api/v1/groups$"),
+	Description:   "Lists every group",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "group:view") {
+			return
+		}
+
+		allGroups, err := groups.GetAll()
+		if err != nil {
+			flow.WriteErrorJson(http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		httpflow.WriteJsonList(flow, len(allGroups), 0, len(allGroups), "groups", &allGroups)
+	},
+}
+
+var addGroupResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "^/api/v1/groups$"),
+	Description:   "Adds a new group",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "group:add") {
+			return
+		}
+
+		var body groupRequest
+		if err := utils.DecodeJSONBody(flow.Request, &body); err != nil {
+			flow.WriteErrorJson(http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		group := groups.Group{Permissions: utils.CSV{}}
+		if body.Name != nil {
+			group.Name = *body.Name
+		}
+		if body.Permissions != nil {
+			group.Permissions = body.Permissions
+		}
+
+		if !checkGrants(flow, nil, group.Permissions) {
+			return
+		}
+		if err := groups.Create(&group); err != nil {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+
+		flow.SetHeader("Content-Type", "application/json")
+		flow.WriteHeaders(http.StatusCreated)
+		flow.WriteJson(group)
+	},
+}
+
+var getGroupResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/groups/{id}"),
+	Description:   "Returns a single group",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "group:view") {
+			return
+		}
+
+		group := groupFromPath(flow)
+		if group == nil {
+			return
+		}
+
+		flow.WriteJson(group)
+	},
+}
+
+var updateGroupResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "/api/v1/groups/{id}"),
+	Description:   "Updates a group; omitted fields are left unchanged",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "group:edit") {
+			return
+		}
+
+		group := groupFromPath(flow)
+		if group == nil {
+			return
+		}
+
+		var body groupRequest
+		if err := utils.DecodeJSONBody(flow.Request, &body); err != nil {
+			flow.WriteErrorJson(http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		if body.Name != nil {
+			group.Name = *body.Name
+		}
+		previous := group.Permissions
+		if body.Permissions != nil {
+			group.Permissions = body.Permissions
+		}
+
+		if !checkGrants(flow, previous, group.Permissions) {
+			return
+		}
+		if err := groups.Update(group); err != nil {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+
+		flow.WriteJson(group)
+	},
+}
+
+var deleteGroupResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodDelete, "/api/v1/groups/{id}"),
+	Description:   "Deletes a group, moving its users to the group named by the reassign_to query parameter",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "group:delete") {
+			return
+		}
+
+		group := groupFromPath(flow)
+		if group == nil {
+			return
+		}
+
+		if err := groups.Delete(group, flow.Request.URL.Query().Get("reassign_to")); err != nil {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+
+		flow.WriteJson(utils.Object{"success": true})
+	},
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// requirePermission writes an error response and returns false unless the current user has the permission
+func requirePermission(flow *httpflow.HttpFlow, permission string) bool {
+	user, ok := flow.Get("user").(*users.User)
+	if !ok || user == nil {
+		httpflow.WriteUnauthorizedJson(flow)
+		return false
+	}
+	if !user.HasPermission(permission) {
+		flow.WriteErrorJson(http.StatusForbidden, "forbidden; requires the %s permission", permission)
+		return false
+	}
+	return true
+}
+
+// checkGrants writes an error response and returns false if the current user may not grant the
+// permissions added to a group
+func checkGrants(flow *httpflow.HttpFlow, previous []string, updated []string) bool {
+	user, _ := flow.Get("user").(*users.User)
+	var granter *groups.Group
+	if user != nil {
+		granter = user.Group
+	}
+	if err := groups.CheckGrants(granter, previous, updated); err != nil {
+		flow.WriteErrorJson(http.StatusForbidden, "%s", err.Error())
+		return false
+	}
+	return true
+}
+
+// groupFromPath returns the group named by the id path parameter, or writes a 404 and returns nil
+func groupFromPath(flow *httpflow.HttpFlow) *groups.Group {
+	id, err := strconv.Atoi(flow.Param("id"))
+	if err != nil {
+		flow.WriteErrorJson(http.StatusNotFound, "group not found")
+		return nil
+	}
+
+	group, err := groups.GetById(uint(id))
+	if err != nil {
+		flow.WriteErrorJson(http.StatusNotFound, "group not found")
+		return nil
+	}
+	return group
+}
@@ -1,6 +1,11 @@
 package groups
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/gojicms/goji/core/database"
 	"github.com/gojicms/goji/core/utils"
 	"github.com/gojicms/goji/core/utils/log"
@@ -9,11 +14,67 @@ import (
 
 type Group struct {
 	gorm.Model
-	Name        string    `gorm:"unique"`
-	Permissions utils.CSV `gorm:"type:VARCHAR(512)"`
+	Name        string    `json:"name" gorm:"unique"`
+	Permissions utils.CSV `json:"permissions" gorm:"type:VARCHAR(512)"`
+	// RequireTwoFactor requires members to set up two-factor authentication before using the admin panel
+	RequireTwoFactor bool `json:"require_two_factor"`
+}
+
+// DeclaredPermission records a permission that has been declared by a service, so its default groups
+// are only granted it once; an administrator may later revoke it without it being granted again.
+type DeclaredPermission struct {
+	Name      string `gorm:"primaryKey;size:255"`
+	CreatedAt time.Time
+}
+
+// ErrCannotGrant is returned when a user grants a permission they do not hold themselves
+var ErrCannotGrant = errors.New("a permission may only be granted by a user who holds it")
+
+// adminPermission grants access to the administration panel; at least one group must always hold it
+const adminPermission = "admin"
+
+// HasPermission returns true if any of the group's permissions grants s, including by wildcard
+func (g Group) HasPermission(s string) bool {
+	for _, granted := range g.Permissions {
+		if Grants(granted, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grants returns true if the granted permission covers the requested permission. "*" grants every
+// permission and a resource wildcard such as "document:*" grants every document permission.
+func Grants(granted string, permission string) bool {
+	if granted == "*" || granted == permission {
+		return true
+	}
+	if resource, ok := strings.CutSuffix(granted, ":*"); ok {
+		return strings.HasPrefix(permission, resource+":")
+	}
+	return false
+}
+
+// CheckGrants returns an error unless every permission in updated that is not already in previous is held
+// by the granting group, so users cannot give themselves or others more than they have. A wildcard is only
+// held through itself or a broader wildcard, so only holders of "*" may grant "*".
+func CheckGrants(granter *Group, previous []string, updated []string) error {
+	existing := utils.CSV(previous)
+	for _, permission := range updated {
+		if existing.Includes(permission) {
+			continue
+		}
+		if granter == nil || !granter.HasPermission(permission) {
+			return fmt.Errorf("%w: %s", ErrCannotGrant, permission)
+		}
+	}
+	return nil
 }
 
 func Create(group *Group) error {
+	if group.Name == "" {
+		return errors.New("group name is empty")
+	}
 	db := database.GetDB()
 	return db.Create(group).Error
 }
@@ -50,3 +111,169 @@ func GetAll() ([]*Group, error) {
 	}
 	return groups, nil
 }
+
+func GetById(id uint) (*Group, error) {
+	db := database.GetDB()
+	var group Group
+	res := db.First(&group, id)
+	if res.Error != nil {
+		log.Error("Auth/Groups", "Failed to get group with ID of %d: %s", id, res.Error.Error())
+		return nil, res.Error
+	}
+	return &group, nil
+}
+
+// CountUsers returns the number of users in the group
+func CountUsers(name string) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Table("users").Where("group_name = ? AND deleted_at IS NULL", name).Count(&count)
+	if res.Error != nil {
+		log.Error("Auth/Groups", "Failed to count users of group %s: %s", name, res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// Update saves the group's name, permissions and two-factor requirement. Users are moved along with a renamed group, and the
+// admin permission may not be removed from the last group holding it.
+func Update(group *Group) error {
+	if group.Name == "" {
+		return errors.New("group name is empty")
+	}
+
+	existing, err := GetById(group.ID)
+	if err != nil {
+		return err
+	}
+
+	if existing.HasPermission(adminPermission) && !group.HasPermission(adminPermission) {
+		if err := ensureOtherAdminGroup(group.ID); err != nil {
+			return err
+		}
+	}
+
+	db := database.GetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Select is required so that an empty permission list is saved
+		if err := tx.Model(&Group{}).Where("id = ?", group.ID).
+			Select("Name", "Permissions", "RequireTwoFactor").
+			Updates(group).Error; err != nil {
+			return err
+		}
+		if existing.Name != group.Name {
+			return tx.Table("users").Where("group_name = ?", existing.Name).Update("group_name", group.Name).Error
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Auth/Groups", "Failed to update group with ID of %d: %s", group.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Delete removes the group, moving its users to the group named reassignTo. reassignTo may be empty if the
+// group has no users. The last group holding the admin permission cannot be deleted.
+func Delete(group *Group, reassignTo string) error {
+	if group.HasPermission(adminPermission) {
+		if err := ensureOtherAdminGroup(group.ID); err != nil {
+			return err
+		}
+	}
+
+	userCount, err := CountUsers(group.Name)
+	if err != nil {
+		return err
+	}
+
+	if userCount > 0 {
+		if reassignTo == "" {
+			return fmt.Errorf("group %s has %d users; choose a group to move them to", group.Name, userCount)
+		}
+		if reassignTo == group.Name {
+			return errors.New("users cannot be moved to the group being deleted")
+		}
+		if _, err := GetByName(reassignTo); err != nil {
+			return fmt.Errorf("group %s does not exist", reassignTo)
+		}
+	}
+
+	db := database.GetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if userCount > 0 {
+			if err := tx.Table("users").Where("group_name = ?", group.Name).Update("group_name", reassignTo).Error; err != nil {
+				return err
+			}
+		}
+		// Delete permanently, so the unique name may be reused
+		return tx.Unscoped().Delete(&Group{}, group.ID).Error
+	})
+	if err != nil {
+		log.Error("Auth/Groups", "Failed to delete group with ID of %d: %s", group.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// GrantDefaults grants each permission that has not been seen before to its default groups, given as a
+// map of permission names to group names. Groups that do not exist are skipped.
+func GrantDefaults(defaults map[string][]string) error {
+	db := database.GetDB()
+
+	var seen []DeclaredPermission
+	if err := db.Find(&seen).Error; err != nil {
+		log.Error("Auth/Groups", "Failed to get declared permissions: %s", err.Error())
+		return err
+	}
+	known := map[string]bool{}
+	for _, permission := range seen {
+		known[permission.Name] = true
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for name, groupNames := range defaults {
+			if known[name] {
+				continue
+			}
+
+			for _, groupName := range groupNames {
+				var group Group
+				if err := tx.Where("name = ?", groupName).Limit(1).Find(&group).Error; err != nil {
+					return err
+				}
+				if group.ID == 0 || group.Permissions.Includes(name) {
+					continue
+				}
+				log.Info("Auth/Groups", "Granting new permission %s to group %s", name, groupName)
+				permissions := append(group.Permissions, name)
+				if err := tx.Model(&group).Update("permissions", permissions).Error; err != nil {
+					return err
+				}
+			}
+
+			if err := tx.Create(&DeclaredPermission{Name: name}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// ensureOtherAdminGroup returns an error unless a group other than the one given holds the admin permission
+func ensureOtherAdminGroup(id uint) error {
+	all, err := GetAll()
+	if err != nil {
+		return err
+	}
+	for _, group := range all {
+		if group.ID != id && group.HasPermission(adminPermission) {
+			return nil
+		}
+	}
+	return errors.New("at least one group must keep the admin permission")
+}
@@ -0,0 +1,41 @@
+package groups
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckGrants(t *testing.T) {
+	editor := &Group{Name: "editor", Permissions: []string{"group:edit", "document:*", "user:view"}}
+	root := &Group{Name: "administrator", Permissions: []string{"*"}}
+
+	tests := []struct {
+		name     string
+		granter  *Group
+		previous []string
+		updated  []string
+		allowed  bool
+	}{
+		{"held permission", editor, nil, []string{"user:view"}, true},
+		{"covered by wildcard", editor, nil, []string{"document:publish"}, true},
+		{"same wildcard", editor, nil, []string{"document:*"}, true},
+		{"not held", editor, nil, []string{"user:delete"}, false},
+		{"broader wildcard", editor, nil, []string{"user:*"}, false},
+		{"everything", editor, nil, []string{"*"}, false},
+		{"admin", editor, nil, []string{"admin"}, false},
+		{"kept permission", editor, []string{"*"}, []string{"*", "user:view"}, true},
+		{"removed permission", editor, []string{"*", "user:delete"}, []string{}, true},
+		{"root grants everything", root, nil, []string{"*", "admin", "user:delete"}, true},
+		{"no group", nil, nil, []string{"user:view"}, false},
+		{"no group, nothing added", nil, []string{"user:view"}, []string{"user:view"}, true},
+	}
+	for _, test := range tests {
+		err := CheckGrants(test.granter, test.previous, test.updated)
+		if test.allowed && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.allowed && !errors.Is(err, ErrCannotGrant) {
+			t.Errorf("%s: expected ErrCannotGrant, got %v", test.name, err)
+		}
+	}
+}
@@ -1,19 +1,25 @@
 package auth
 
 import (
+	"context"
 	"encoding/base64"
 	"net/http"
+	"strings"
 
 	"github.com/gojicms/goji/core/database"
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server/httpflow"
 	"github.com/gojicms/goji/core/services/auth/admin"
 	"github.com/gojicms/goji/core/services/auth/groups"
+	"github.com/gojicms/goji/core/services/auth/resets"
+	"github.com/gojicms/goji/core/services/auth/tokens"
+	"github.com/gojicms/goji/core/services/auth/twofactor"
 	"github.com/gojicms/goji/core/services/auth/users"
 	"github.com/gojicms/goji/core/services/sessions"
 	"github.com/gojicms/goji/core/utils"
 	"github.com/gojicms/goji/core/utils/log"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 //////////////////////////////////
@@ -27,28 +33,24 @@ var loginResource = extend.ResourceDef{
 		data := make(map[string]interface{})
 		err := flow.DecodeJSONBody(&data)
 		if err != nil {
-			flow.WriteErrorJson(http.StatusInternalServerError, err.Error())
+			flow.WriteErrorJson(http.StatusInternalServerError, "%s", err.Error())
+			return
 		}
 
 		username := data["username"].(string)
 		password := data["password"].(string)
-		csrf := data["_CSRF"].(string)
-
-		if csrf == "" {
-			flow.WriteErrorJson(http.StatusBadRequest, "csrf is missing")
-		}
 
 		if username == "" || password == "" {
 			flow.WriteErrorJson(http.StatusBadRequest, "username or password is empty")
 		}
 
-		user, err := users.ValidateLogin(data["username"].(string), data["password"].(string))
+		user, err := users.Login(flow.Request.Context(), username, password, flow.Request.RemoteAddr)
 
 		if err != nil {
 			flow.WriteErrorJson(http.StatusForbidden, "username or password is invalid")
 		}
 
-		session, _ := sessions.CreateSession(flow, csrf, user.ID)
+		session, _ := sessions.CreateSession(flow, user.ID)
 		flow.WriteJson(utils.Object{
 			"session": session.SessionId,
 		})
@@ -64,6 +66,18 @@ var logoutResource = extend.ResourceDef{
 	},
 }
 
+// groupPermissions grant management of groups; they were granted to the administrator group by migration 3,
+// before permissions were declared by services
+var groupPermissions = utils.CSV{"group:view", "group:edit", "group:delete", "group:add"}
+
+// legacyPermissions were given to the default groups when they were created, before permissions were
+// declared by services
+var legacyPermissions = utils.CSV{
+	"admin",
+	"user:view", "user:edit", "user:delete", "user:add",
+	"document:view", "document:add", "document:edit", "document:delete",
+}
+
 //////////////////////////////////
 // Service Definition           //
 //////////////////////////////////
@@ -71,38 +85,174 @@ var logoutResource = extend.ResourceDef{
 var Service = extend.ServiceDef{
 	Name:         "authentication",
 	FriendlyName: "Authentication",
+	DependsOn:    []string{"administration"},
 	Resources: []extend.ResourceDef{
 		loginResource,
 		logoutResource,
+		getGroupsResource,
+		addGroupResource,
+		getGroupResource,
+		updateGroupResource,
+		deleteGroupResource,
+	},
+	Permissions: []extend.PermissionDef{
+		{Name: "user:view", Description: "View users", DefaultGroups: []string{"administrator"}},
+		{Name: "user:add", Description: "Create users", DefaultGroups: []string{"administrator"}},
+		{Name: "user:edit", Description: "Edit users and manage their API tokens", DefaultGroups: []string{"administrator"}},
+		{Name: "user:delete", Description: "Delete users", DefaultGroups: []string{"administrator"}},
+		{Name: "group:view", Description: "View groups", DefaultGroups: []string{"administrator"}},
+		{Name: "group:add", Description: "Create groups", DefaultGroups: []string{"administrator"}},
+		{Name: "group:edit", Description: "Edit groups and their permissions", DefaultGroups: []string{"administrator"}},
+		{Name: "group:delete", Description: "Delete groups", DefaultGroups: []string{"administrator"}},
+	},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_users_and_groups",
+			Up:      database.AutoMigrateModels(&groupV1{}, &userV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&userV1{}, &groupV1{})
+			},
+		},
+		{
+			Version: 2,
+			Name:    "create_api_tokens",
+			Up:      database.AutoMigrateModels(&tokens.Token{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&tokens.Token{})
+			},
+		},
+		{
+			Version: 3,
+			Name:    "grant_group_permissions",
+			Up: func(tx *gorm.DB) error {
+				return updateGroupPermissions(tx, "administrator", func(permissions utils.CSV) utils.CSV {
+					for _, permission := range groupPermissions {
+						if !permissions.Includes(permission) {
+							permissions = append(permissions, permission)
+						}
+					}
+					return permissions
+				})
+			},
+			Down: func(tx *gorm.DB) error {
+				return updateGroupPermissions(tx, "administrator", func(permissions utils.CSV) utils.CSV {
+					kept := utils.CSV{}
+					for _, permission := range permissions {
+						if !groupPermissions.Includes(permission) {
+							kept = append(kept, permission)
+						}
+					}
+					return kept
+				})
+			},
+		},
+		{
+			Version: 4,
+			Name:    "create_declared_permissions",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&groups.DeclaredPermission{}); err != nil {
+					return err
+				}
+				return seedDeclaredPermissions(tx)
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&groups.DeclaredPermission{})
+			},
+		},
+		{
+			Version: 5,
+			Name:    "create_password_resets",
+			Up:      database.AutoMigrateModels(&resets.PasswordReset{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&resets.PasswordReset{})
+			},
+		},
+		{
+			Version: 6,
+			Name:    "create_two_factor",
+			Up: database.AutoMigrateModels(&groups.Group{}, &twofactor.TwoFactor{}, &twofactor.RecoveryCode{},
+				&twofactor.Challenge{}),
+			Down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&twofactor.Challenge{}, &twofactor.RecoveryCode{}, &twofactor.TwoFactor{}); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&groups.Group{}, "RequireTwoFactor")
+			},
+		},
+	},
+	Jobs: []extend.JobDef{
+		{
+			Name:        "auth.password_reset_cleanup",
+			Description: "Deletes expired password reset links",
+			Schedule:    "@hourly",
+			Run: func(ctx context.Context) error {
+				return resets.CleanUp()
+			},
+		},
+		{
+			Name:        "auth.login_challenge_cleanup",
+			Description: "Deletes expired two-factor login challenges",
+			Schedule:    "@hourly",
+			Run: func(ctx context.Context) error {
+				return twofactor.CleanUp()
+			},
+		},
 	},
 	OnInit: func() error {
 		admin.Register()
 
-		database.AutoMigrate(&users.User{})
-		database.AutoMigrate(&groups.Group{})
+		// Resolve personal API tokens; this runs after the sessions middleware, so a bearer token takes
+		// precedence over a session cookie.
+		extend.AddMiddleware(extend.NewMiddleware("*", "*", 1, func(flow *httpflow.HttpFlow) {
+			value, ok := strings.CutPrefix(flow.Request.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				return
+			}
+
+			token, user, err := tokens.Resolve(strings.TrimSpace(value))
+			if err != nil {
+				log.Warn("Auth", "Rejected API token for %s: %s", flow.Request.URL.Path, err.Error())
+				httpflow.WriteUnauthorizedJson(flow)
+				flow.Terminate()
+				return
+			}
 
-		// Ensure the default groups exist
+			flow.Set("token", token)
+			flow.Set("user", user)
+			flow.Append("templateData", "user", user)
+		}))
+
+		// Members of groups requiring two-factor authentication are held on the enrollment page of the admin
+		// panel until they set it up, so their sessions and tokens may not be used with the API until then
+		extend.AddMiddleware(extend.NewMiddleware("*", "^/api/", 3, func(flow *httpflow.HttpFlow) {
+			user, ok := flow.Get("user").(*users.User)
+			if !ok || user == nil || !twofactor.IsRequired(user) || twofactor.IsEnabled(user.ID) {
+				return
+			}
+			flow.WriteErrorJson(http.StatusForbidden, "two-factor authentication must be set up before using the API")
+			flow.Terminate()
+		}))
+
+		// Ensure the default groups exist; their permissions are granted below
 		if c, _ := groups.Count(); c == 0 {
-			_ = groups.Create(&groups.Group{
-				Name: "administrator",
-				Permissions: utils.CSV{
-					"admin",
-					"user:view", "user:edit", "user:delete", "user:add",
-					"document:view", "document:add", "document:edit", "document:delete"},
-			})
-			_ = groups.Create(&groups.Group{
-				Name:        "editor",
-				Permissions: utils.CSV{"admin", "document:view", "document:add", "document:edit", "document:delete"},
-			})
-			_ = groups.Create(&groups.Group{
-				Name:        "user",
-				Permissions: utils.CSV{},
-			})
+			for _, name := range []string{"administrator", "editor", "user"} {
+				_ = groups.Create(&groups.Group{Name: name, Permissions: utils.CSV{}})
+			}
 			if c, _ = groups.Count(); c == 0 {
 				log.Fatal(log.RCDatabase, "Auth", "Failed to create default user groups")
 			}
 		}
 
+		// Grant newly declared permissions, including those of plugins, to their default groups
+		defaults := map[string][]string{}
+		for _, permission := range extend.GetPermissions() {
+			defaults[permission.Name] = permission.DefaultGroups
+		}
+		if err := groups.GrantDefaults(defaults); err != nil {
+			return err
+		}
+
 		// Ensure at least one user exists!
 		if c, _ := users.Count(); c == 0 {
 			group, err := groups.GetByName("administrator")
@@ -134,3 +284,45 @@ var Service = extend.ServiceDef{
 		return nil
 	},
 }
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// updateGroupPermissions replaces the permissions of the named group, if it exists, within a migration
+func updateGroupPermissions(tx *gorm.DB, name string, update func(permissions utils.CSV) utils.CSV) error {
+	var group groups.Group
+	res := tx.Where("name = ?", name).Limit(1).Find(&group)
+	if res.Error != nil || group.ID == 0 {
+		return res.Error
+	}
+	return tx.Model(&group).Update("permissions", update(group.Permissions)).Error
+}
+
+// seedDeclaredPermissions records the permissions an existing install already knows as declared, so an
+// administrator who revoked one does not see it granted again; only permissions that are new to the install
+// are then granted to their default groups. A new install has no groups yet, and is left for OnInit to seed.
+func seedDeclaredPermissions(tx *gorm.DB) error {
+	var existing []groups.Group
+	if err := tx.Find(&existing).Error; err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	known := append(append(utils.CSV{}, legacyPermissions...), groupPermissions...)
+	for _, group := range existing {
+		for _, permission := range group.Permissions {
+			if permission != "" && !known.Includes(permission) {
+				known = append(known, permission)
+			}
+		}
+	}
+	for _, name := range known {
+		if err := tx.Create(&groups.DeclaredPermission{Name: name}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"github.com/gojicms/goji/core/utils"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// groupV1 and userV1 are the tables as created by migration 1. Later migrations add their own columns to
+// them, so they must not change along with groups.Group and users.User.
+type groupV1 struct {
+	gorm.Model
+	Name        string    `gorm:"unique"`
+	Permissions utils.CSV `gorm:"type:VARCHAR(512)"`
+}
+
+func (groupV1) TableName() string {
+	return "groups"
+}
+
+type userV1 struct {
+	gorm.Model
+	Uuid        string `gorm:"unique"`
+	Username    string `gorm:"unique"`
+	Password    string
+	Salt        string
+	Email       string
+	DisplayName string
+	GroupName   string
+	Group       *groupV1 `gorm:"foreignKey:GroupName;references:Name"`
+}
+
+func (userV1) TableName() string {
+	return "users"
+}
@@ -0,0 +1,264 @@
+package resets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/services/jobs"
+	"github.com/gojicms/goji/core/services/mail"
+	"github.com/gojicms/goji/core/services/sessions"
+	"github.com/gojicms/goji/core/utils"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// PasswordReset is a request to reset the password of a user. Only a hash of the token is stored; the token
+// itself is only sent to the user, in the reset link. A reset may be used once, before it expires.
+type PasswordReset struct {
+	ID         uint      `gorm:"primarykey"`
+	UserId     uint      `gorm:"index"`
+	Hash       string    `gorm:"uniqueIndex;size:64"`
+	RemoteAddr string    `gorm:"size:64"` // The address the reset was requested from
+	ExpiresAt  time.Time `gorm:"index"`
+	UsedAt     *time.Time
+	CreatedAt  time.Time
+}
+
+// resetRequest is the payload of a queued reset email. It holds no token: the token is created as the
+// email is sent, so it is never stored outside the email itself.
+type resetRequest struct {
+	UserId     uint
+	RemoteAddr string
+	RootUrl    string
+}
+
+// ResetPath is the admin page users follow from the reset email
+const ResetPath = "/admin/login/reset"
+
+// requestInterval limits how often a user is sent a reset email
+const requestInterval = time.Minute
+
+// ErrInvalidToken is returned for reset tokens that are unknown, used or expired
+var ErrInvalidToken = errors.New("the reset link is invalid or has expired")
+
+// ErrNoRootUrl is returned when a reset is requested without the address of the site to link to
+var ErrNoRootUrl = errors.New("password resets require root_url to be configured")
+
+// EventPasswordReset is published once a user has reset their password
+var EventPasswordReset = extend.NewEvent[*users.User]("user.password_reset")
+
+//go:embed reset.html.gohtml
+var resetHtml []byte
+
+//go:embed reset.txt.gohtml
+var resetText []byte
+
+var resetMail = mail.NewTemplate("users.password_reset", "Sent to users who forgot their password",
+	"Reset your password", resetHtml, resetText)
+
+// sendTask creates a reset and emails its link to the user, retrying while mail cannot be sent
+var sendTask = jobs.NewTask("auth.password_reset", jobs.TaskOptions{
+	MaxAttempts: 5,
+	Timeout:     time.Minute,
+	Backoff:     time.Minute,
+}, func(ctx context.Context, request resetRequest) error {
+	return send(ctx, request)
+})
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Request queues an email with a reset link to the user with the username or email address given, if they
+// have an email address. Nothing is sent for unknown users, but no error is returned either, so the response does not
+// reveal which accounts exist. rootUrl is the configured address of the site, eg. https://example.com; it
+// must never come from the request, whose host the client controls.
+func Request(ctx context.Context, identifier string, remoteAddr string, rootUrl string) error {
+	if rootUrl == "" {
+		return ErrNoRootUrl
+	}
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil
+	}
+	user, err := users.GetByUsernameOrEmail(identifier)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Info("Auth/Resets", "Password reset requested for unknown user %q from %s", identifier, remoteAddr)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		log.Warn("Auth/Resets", "Password reset requested for %s, who has no email address", user.Username)
+		return nil
+	}
+
+	db := database.GetDB()
+	var recent int64
+	db.Model(&PasswordReset{}).Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-requestInterval)).Count(&recent)
+	if recent > 0 {
+		log.Info("Auth/Resets", "Password reset for %s was already requested recently", user.Username)
+		return nil
+	}
+
+	// Requests made while the email is waiting to be sent are folded into it
+	_, err = sendTask.EnqueueWith(resetRequest{UserId: user.ID, RemoteAddr: remoteAddr, RootUrl: rootUrl},
+		jobs.EnqueueOptions{Key: strconv.FormatUint(uint64(user.ID), 10)})
+	return err
+}
+
+// Validate returns the reset with a token, or ErrInvalidToken if it may not be used
+func Validate(token string) (*PasswordReset, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	db := database.GetDB()
+	var reset PasswordReset
+	res := db.Where("hash = ? AND used_at IS NULL AND expires_at > ?", hash(token), time.Now()).Limit(1).Find(&reset)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if reset.ID == 0 {
+		return nil, ErrInvalidToken
+	}
+	return &reset, nil
+}
+
+// Complete sets a new password for the user a reset token was sent to, subject to the password policy. The
+// token may not be used again, and every session of the user is ended.
+func Complete(ctx context.Context, token string, password string) (*users.User, error) {
+	reset, err := Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := users.ValidatePassword(password); err != nil {
+		return nil, err
+	}
+	user, err := users.GetById(reset.UserId)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Claim the reset, so that it cannot be used twice by concurrent requests
+	db := database.GetDB()
+	now := time.Now()
+	res := db.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", &now)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	user.Password = password
+	if err := users.Update(user); err != nil {
+		db.Model(&PasswordReset{}).Where("id = ?", reset.ID).Update("used_at", nil)
+		return nil, err
+	}
+	user.Password = ""
+
+	// Any other links sent to the user are no longer needed
+	db.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&PasswordReset{})
+	if err := sessions.EndUserSessions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	log.Info("Auth/Resets", "Password of %s was reset", user.Username)
+	EventPasswordReset.Notify(ctx, user)
+	return user, nil
+}
+
+// CleanUp deletes resets that have expired
+func CleanUp() error {
+	db := database.GetDB()
+	return db.Where("expires_at < ?", time.Now()).Delete(&PasswordReset{}).Error
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// send creates a reset for a queued request and emails its link. The email is sent directly rather than
+// queued, so that the link is not stored in the payload of a mail job.
+func send(ctx context.Context, request resetRequest) error {
+	user, err := users.GetById(request.UserId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(secret)
+	lifetime := config.ActiveConfig.Application.Auth.ResetTokenLifetime
+
+	message, err := resetMail.Render(user.Email, utils.Object{
+		"user":     user,
+		"link":     strings.TrimSuffix(request.RootUrl, "/") + ResetPath + "?token=" + url.QueryEscape(token),
+		"lifetime": describe(lifetime),
+	})
+	if err != nil {
+		log.Error("Auth/Resets", "Failed to render the reset email: %s", err.Error())
+		return jobs.Permanent(err)
+	}
+
+	db := database.GetDB()
+	reset := PasswordReset{
+		UserId:     user.ID,
+		Hash:       hash(token),
+		RemoteAddr: request.RemoteAddr,
+		ExpiresAt:  time.Now().Add(lifetime),
+	}
+	if err := db.Create(&reset).Error; err != nil {
+		log.Error("Auth/Resets", "Failed to create password reset: %s", err.Error())
+		return err
+	}
+	if err := mail.SendNow(ctx, message); err != nil {
+		// The link never reached the user, so the reset is removed; a retry creates another
+		db.Delete(&reset)
+		return err
+	}
+	return nil
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// describe words a lifetime for the reset email, eg. "1 hour" or "30 minutes"
+func describe(d time.Duration) string {
+	value, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		value, unit = int(d/time.Hour), "hour"
+	}
+	if value != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", value, unit)
+}
@@ -0,0 +1,171 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/services/auth/groups"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+// tokenPrefix identifies Goji API tokens, which helps secret scanners and humans recognise them
+const tokenPrefix = "goji_"
+
+// lastUsedResolution limits how often LastUsedAt is written for a busy token
+const lastUsedResolution = time.Minute
+
+// Token is a personal API token. Only a hash of the token is stored; the token itself is shown once, when
+// it is created. Revoked tokens are soft-deleted.
+type Token struct {
+	gorm.Model
+	Name       string    `gorm:"size:255"`
+	Hash       string    `gorm:"uniqueIndex;size:64"`
+	Hint       string    `gorm:"size:16"` // The first characters of the token, to help users identify it
+	Scopes     utils.CSV `gorm:"type:VARCHAR(512)"`
+	UserId     uint      `gorm:"index"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// IsExpired returns true if the token has an expiry that has passed
+func (t Token) IsExpired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+// Create mints a new token for the user, returning the stored token and the plain-text token value.
+// scopes limits the permissions of the token to those listed; use "*" to grant every permission of the user.
+// A nil expiresAt creates a token that does not expire.
+func Create(userId uint, name string, scopes []string, expiresAt *time.Time) (*Token, string, error) {
+	if name == "" {
+		return nil, "", errors.New("token name is empty")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("token must have at least one scope")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	value := tokenPrefix + hex.EncodeToString(secret)
+
+	token := Token{
+		Name:      name,
+		Hash:      hash(value),
+		Hint:      value[:len(tokenPrefix)+6],
+		Scopes:    scopes,
+		UserId:    userId,
+		ExpiresAt: expiresAt,
+	}
+
+	db := database.GetDB()
+	if err := db.Create(&token).Error; err != nil {
+		log.Error("Auth/Tokens", "Failed to create token: %s", err.Error())
+		return nil, "", err
+	}
+	return &token, value, nil
+}
+
+// GetByUser returns every active token belonging to the user
+func GetByUser(userId uint) ([]Token, error) {
+	db := database.GetDB()
+	var tokens []Token
+	res := db.Where("user_id = ?", userId).Order("created_at desc").Find(&tokens)
+	if res.Error != nil {
+		log.Error("Auth/Tokens", "Failed to get tokens: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	return tokens, nil
+}
+
+// Revoke revokes the token with the given id, provided it belongs to the user
+func Revoke(userId uint, id uint) error {
+	db := database.GetDB()
+	res := db.Where("user_id = ?", userId).Delete(&Token{}, id)
+	if res.Error != nil {
+		log.Error("Auth/Tokens", "Failed to revoke token %d: %s", id, res.Error.Error())
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+// Resolve validates a plain-text token, returning the token and its user. The user's permissions are
+// narrowed to the token's scopes, so the user may be used exactly as a session user would be.
+func Resolve(value string) (*Token, *users.User, error) {
+	if !strings.HasPrefix(value, tokenPrefix) {
+		return nil, nil, errors.New("malformed token")
+	}
+
+	db := database.GetDB()
+	var token Token
+	res := db.Where("hash = ?", hash(value)).Limit(1).Find(&token)
+	if res.Error != nil || token.ID == 0 {
+		return nil, nil, errors.New("token not found")
+	}
+	if token.IsExpired() {
+		return nil, nil, errors.New("token has expired")
+	}
+
+	user, err := users.GetById(token.UserId)
+	if err != nil {
+		return nil, nil, errors.New("token user not found")
+	}
+	user.Group = scopedGroup(user.Group, token.Scopes)
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedResolution {
+		token.LastUsedAt = &now
+		db.Model(&token).UpdateColumn("last_used_at", now)
+	}
+
+	return &token, user, nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// scopedGroup returns a copy of group whose permissions are limited to the given scopes. Either may use
+// wildcards, so the result holds each group permission a scope grants, and each scope the group grants.
+func scopedGroup(group *groups.Group, scopes utils.CSV) *groups.Group {
+	if group == nil {
+		return nil
+	}
+
+	scoped := *group
+	if scopes.Includes("*") {
+		return &scoped
+	}
+
+	scoped.Permissions = utils.CSV{}
+	for _, permission := range group.Permissions {
+		for _, scope := range scopes {
+			if groups.Grants(scope, permission) {
+				scoped.Permissions = append(scoped.Permissions, permission)
+				break
+			}
+		}
+	}
+	for _, scope := range scopes {
+		if group.HasPermission(scope) && !scoped.Permissions.Includes(scope) {
+			scoped.Permissions = append(scoped.Permissions, scope)
+		}
+	}
+	return &scoped
+}
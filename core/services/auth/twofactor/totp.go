@@ -0,0 +1,91 @@
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Codes are the six digit, 30 second, HMAC-SHA1 codes of RFC 6238, which every authenticator app supports
+const (
+	period    = 30
+	digits    = 6
+	secretLen = 20
+	// skew is how many periods either side of the current one are accepted, to allow for clock drift
+	skew = 1
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// NewSecret returns a random secret, base32 encoded as authenticator apps expect
+func NewSecret() (string, error) {
+	secret := make([]byte, secretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+// Code returns the code of a secret at a time
+func Code(secret string, t time.Time) (string, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %w", err)
+	}
+	return code(key, t.Unix()/period), nil
+}
+
+// ProvisioningUri returns the otpauth:// address that authenticator apps scan to add an account
+func ProvisioningUri(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// match returns the time step a code is valid for, if it is valid within the allowed skew
+func match(secret string, input string, t time.Time) (int64, bool) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(input) != digits {
+		return 0, false
+	}
+	current := t.Unix() / period
+	for step := current - skew; step <= current+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, step)), []byte(input)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func code(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
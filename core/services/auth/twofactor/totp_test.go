@@ -0,0 +1,119 @@
+package twofactor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCode checks codes against the RFC 6238 test vectors, which have eight digits; the last six are ours
+func TestCode(t *testing.T) {
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range tests {
+		got, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d is %s, want %s", unix, got, want)
+		}
+	}
+
+	if got, _ := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", time.Unix(59, 0)); got != "287082" {
+		t.Errorf("lower case secret gives %s, want 287082", got)
+	}
+	if _, err := Code("not base32!", time.Now()); err == nil {
+		t.Error("expected an invalid secret to be rejected")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / period
+	for _, offset := range []int64{-1, 0, 1} {
+		input, _ := Code(rfcSecret, now.Add(time.Duration(offset*period)*time.Second))
+		if step, ok := match(rfcSecret, input, now); !ok || step != current+offset {
+			t.Errorf("code %d steps away: step %d (%v), want %d", offset, step, ok, current+offset)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		input, _ := Code(rfcSecret, now.Add(time.Duration(offset*period)*time.Second))
+		if _, ok := match(rfcSecret, input, now); ok {
+			t.Errorf("code %d steps away was accepted", offset)
+		}
+	}
+
+	input, _ := Code(rfcSecret, now)
+	for _, wrong := range []string{"", input[:5], input + "0", "abcdef"} {
+		if _, ok := match(rfcSecret, wrong, now); ok {
+			t.Errorf("%q was accepted", wrong)
+		}
+	}
+}
+
+func TestProvisioningUri(t *testing.T) {
+	got := ProvisioningUri("Goji", "jane doe", rfcSecret)
+	want := "otpauth://totp/Goji:jane%20doe?algorithm=SHA1&digits=6&issuer=Goji&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCodesAreSingleUse(t *testing.T) {
+	config.ActiveConfig.Application.Database.DSN = "file:" + filepath.Join(t.TempDir(), "test.db")
+	t.Cleanup(func() { _ = database.Close() })
+	db := database.GetDB()
+	if err := db.AutoMigrate(&TwoFactor{}, &RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&TwoFactor{UserId: 1, Secret: rfcSecret, Enabled: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Steer clear of the end of a step, so both codes stay within the allowed skew
+	if time.Now().Unix()%period >= period-2 {
+		time.Sleep(3 * time.Second)
+	}
+
+	// The code of the previous step is still accepted, until a newer one has been used
+	previous, _ := Code(rfcSecret, time.Now().Add(-period*time.Second))
+	current, _ := Code(rfcSecret, time.Now())
+	if err := verifyTotp(1, previous); err != nil {
+		t.Fatalf("first use of the previous code: %v", err)
+	}
+	if err := verifyTotp(1, previous); err == nil {
+		t.Error("the previous code was accepted twice")
+	}
+	if err := verifyTotp(1, current); err != nil {
+		t.Fatalf("first use of the current code: %v", err)
+	}
+	if err := verifyTotp(1, current); err == nil {
+		t.Error("the current code was accepted twice")
+	}
+	if err := verifyTotp(2, current); err != ErrNotEnrolled {
+		t.Errorf("code of a user without two-factor authentication: %v, want ErrNotEnrolled", err)
+	}
+
+	if err := db.Create(&RecoveryCode{UserId: 1, Hash: hash("abcd1234")}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := useRecoveryCode(1, "abcd1234"); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if err := useRecoveryCode(1, "abcd1234"); err != ErrInvalidCode {
+		t.Errorf("second use of a recovery code: %v, want ErrInvalidCode", err)
+	}
+}
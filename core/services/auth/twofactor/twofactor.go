@@ -0,0 +1,398 @@
+package twofactor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// TwoFactor holds the TOTP secret of a user. It is created when the user starts enrolling, and enabled once
+// they confirm a code from their authenticator app.
+type TwoFactor struct {
+	ID        uint   `gorm:"primarykey"`
+	UserId    uint   `gorm:"uniqueIndex"`
+	Secret    string `gorm:"size:64" json:"-"`
+	Enabled   bool
+	EnabledAt *time.Time
+	// LastStep is the time step of the last code accepted, so a code cannot be used twice
+	LastStep  int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// RecoveryCode lets a user log in without their authenticator app, once. Only a hash is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primarykey"`
+	UserId    uint   `gorm:"index"`
+	Hash      string `gorm:"uniqueIndex;size:64"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Challenge is a login awaiting its second step, once the password of the user has been checked
+type Challenge struct {
+	ID        uint   `gorm:"primarykey"`
+	Hash      string `gorm:"uniqueIndex;size:64"`
+	UserId    uint   `gorm:"index"`
+	Attempts  int
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// Change describes two-factor authentication being enabled or disabled for a user, as passed to
+// EventEnabled and EventDisabled
+type Change struct {
+	User *users.User
+	// By is the user who made the change; an administrator resetting another user, or the user themself
+	By *users.User
+}
+
+// Attempt describes a code being checked, at login or to confirm a change, as passed to EventVerified and
+// EventFailed
+type Attempt struct {
+	User       *users.User
+	RemoteAddr string
+	// Method is MethodTotp or MethodRecoveryCode
+	Method string
+	// Reason explains why a failed attempt was refused
+	Reason string
+}
+
+const (
+	MethodTotp         = "totp"
+	MethodRecoveryCode = "recovery_code"
+)
+
+const (
+	// Issuer names the site in authenticator apps
+	Issuer = "Goji"
+	// RecoveryCodeCount is how many recovery codes a user is given
+	RecoveryCodeCount = 10
+	// ChallengeLifetime is how long a user has to complete the second login step
+	ChallengeLifetime = 5 * time.Minute
+	// MaxAttempts is how many codes may be tried before the login must be started again
+	MaxAttempts = 5
+)
+
+var (
+	ErrNotEnrolled     = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrInvalidCode     = errors.New("the code is invalid")
+	ErrInvalidLogin    = errors.New("the login has expired; please log in again")
+	ErrTooManyAttempts = errors.New("too many invalid codes; please log in again")
+)
+
+var (
+	EventEnabled  = extend.NewEvent[*Change]("user.two_factor.enabled")
+	EventDisabled = extend.NewEvent[*Change]("user.two_factor.disabled")
+	// EventVerified is published when a code of a user is accepted
+	EventVerified = extend.NewEvent[*Attempt]("user.two_factor.verified")
+	// EventFailed is published when a code of a user is refused
+	EventFailed = extend.NewEvent[*Attempt]("user.two_factor.failed")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// GetByUser returns the two-factor settings of a user, or nil if they have not started enrolling
+func GetByUser(userId uint) (*TwoFactor, error) {
+	db := database.GetDB()
+	var twoFactor TwoFactor
+	res := db.Where("user_id = ?", userId).Limit(1).Find(&twoFactor)
+	if res.Error != nil {
+		log.Error("Auth/TwoFactor", "Failed to get two-factor settings of user %d: %s", userId, res.Error.Error())
+		return nil, res.Error
+	}
+	if twoFactor.ID == 0 {
+		return nil, nil
+	}
+	return &twoFactor, nil
+}
+
+// IsEnabled returns true if the user must enter a code to log in
+func IsEnabled(userId uint) bool {
+	twoFactor, err := GetByUser(userId)
+	return err == nil && twoFactor != nil && twoFactor.Enabled
+}
+
+// IsRequired returns true if the group of the user requires two-factor authentication
+func IsRequired(user *users.User) bool {
+	return user.Group != nil && user.Group.RequireTwoFactor
+}
+
+// Begin starts enrolling a user, returning a pending secret for them to add to their authenticator app. A
+// pending secret is kept until it is confirmed with Enable.
+func Begin(user *users.User) (*TwoFactor, error) {
+	twoFactor, err := GetByUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor != nil {
+		if twoFactor.Enabled {
+			return nil, ErrAlreadyEnabled
+		}
+		return twoFactor, nil
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	twoFactor = &TwoFactor{UserId: user.ID, Secret: secret}
+	db := database.GetDB()
+	if err := db.Create(twoFactor).Error; err != nil {
+		log.Error("Auth/TwoFactor", "Failed to begin enrolling user %d: %s", user.ID, err.Error())
+		return nil, err
+	}
+	return twoFactor, nil
+}
+
+// Enable enables two-factor authentication once the user confirms a code of their pending secret, returning
+// their recovery codes
+func Enable(ctx context.Context, user *users.User, code string) ([]string, error) {
+	twoFactor, err := GetByUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor == nil {
+		return nil, ErrNotEnrolled
+	}
+	if twoFactor.Enabled {
+		return nil, ErrAlreadyEnabled
+	}
+	step, ok := match(twoFactor.Secret, normalize(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	now := time.Now()
+	db := database.GetDB()
+	err = db.Model(twoFactor).Updates(map[string]any{"enabled": true, "enabled_at": &now, "last_step": step}).Error
+	if err != nil {
+		log.Error("Auth/TwoFactor", "Failed to enable two-factor authentication for user %d: %s", user.ID, err.Error())
+		return nil, err
+	}
+	codes, err := RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Auth/TwoFactor", "Two-factor authentication enabled for %s", user.Username)
+	EventEnabled.Notify(ctx, &Change{User: user, By: user})
+	return codes, nil
+}
+
+// Disable removes the secret and recovery codes of a user. by is the user making the change.
+func Disable(ctx context.Context, user *users.User, by *users.User) error {
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&TwoFactor{}).Error
+	})
+	if err != nil {
+		log.Error("Auth/TwoFactor", "Failed to disable two-factor authentication for user %d: %s", user.ID, err.Error())
+		return err
+	}
+
+	log.Warn("Auth/TwoFactor", "Two-factor authentication disabled for %s by %s", user.Username, by.Username)
+	EventDisabled.Notify(ctx, &Change{User: user, By: by})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, returning the new codes. They are only
+// available now; only their hashes are kept.
+func RegenerateRecoveryCodes(userId uint) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	records := make([]RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		value := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+		codes[i] = value[:4] + "-" + value[4:]
+		records[i] = RecoveryCode{UserId: userId, Hash: hash(value)}
+	}
+
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		log.Error("Auth/TwoFactor", "Failed to create recovery codes for user %d: %s", userId, err.Error())
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has
+func CountRecoveryCodes(userId uint) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userId).Count(&count)
+	return count, res.Error
+}
+
+// Verify checks a code from the authenticator app of the user, or one of their recovery codes, which is
+// then used up. EventVerified or EventFailed is published with the outcome.
+func Verify(ctx context.Context, user *users.User, input string, remoteAddr string) error {
+	attempt := &Attempt{User: user, RemoteAddr: remoteAddr, Method: MethodTotp}
+	input = normalize(input)
+
+	var err error
+	if len(input) == digits {
+		err = verifyTotp(user.ID, input)
+	} else {
+		attempt.Method = MethodRecoveryCode
+		err = useRecoveryCode(user.ID, input)
+	}
+
+	if err != nil {
+		attempt.Reason = err.Error()
+		log.Warn("Security", "Refused a two-factor code for %s from %s: %s", user.Username, remoteAddr, err.Error())
+		EventFailed.Notify(ctx, attempt)
+		return err
+	}
+	if attempt.Method == MethodRecoveryCode {
+		remaining, _ := CountRecoveryCodes(user.ID)
+		log.Warn("Security", "%s logged in with a recovery code; %d remain", user.Username, remaining)
+	}
+	EventVerified.Notify(ctx, attempt)
+	return nil
+}
+
+// NewChallenge starts the second login step for a user, returning the token identifying it
+func NewChallenge(userId uint) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	db := database.GetDB()
+	challenge := Challenge{Hash: hash(token), UserId: userId, ExpiresAt: time.Now().Add(ChallengeLifetime)}
+	if err := db.Create(&challenge).Error; err != nil {
+		log.Error("Auth/TwoFactor", "Failed to create login challenge: %s", err.Error())
+		return "", err
+	}
+	return token, nil
+}
+
+// GetChallenge returns the unexpired challenge with a token
+func GetChallenge(token string) (*Challenge, error) {
+	if token == "" {
+		return nil, ErrInvalidLogin
+	}
+	db := database.GetDB()
+	var challenge Challenge
+	res := db.Where("hash = ? AND expires_at > ?", hash(token), time.Now()).Limit(1).Find(&challenge)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if challenge.ID == 0 {
+		return nil, ErrInvalidLogin
+	}
+	return &challenge, nil
+}
+
+// Fail records an invalid code for the challenge, ending it once MaxAttempts have been made; it then
+// returns ErrTooManyAttempts
+func (challenge *Challenge) Fail() error {
+	db := database.GetDB()
+	challenge.Attempts++
+	if challenge.Attempts >= MaxAttempts {
+		challenge.End()
+		return ErrTooManyAttempts
+	}
+	return db.Model(challenge).Update("attempts", challenge.Attempts).Error
+}
+
+// End deletes the challenge, once the login has completed or failed
+func (challenge *Challenge) End() {
+	db := database.GetDB()
+	db.Delete(&Challenge{}, challenge.ID)
+}
+
+// CleanUp deletes expired challenges
+func CleanUp() error {
+	db := database.GetDB()
+	return db.Where("expires_at < ?", time.Now()).Delete(&Challenge{}).Error
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// verifyTotp checks a code of the authenticator app, refusing codes of steps that were already used
+func verifyTotp(userId uint, input string) error {
+	twoFactor, err := GetByUser(userId)
+	if err != nil {
+		return err
+	}
+	if twoFactor == nil || !twoFactor.Enabled {
+		return ErrNotEnrolled
+	}
+	step, ok := match(twoFactor.Secret, input, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	db := database.GetDB()
+	res := db.Model(&TwoFactor{}).Where("id = ? AND last_step < ?", twoFactor.ID, step).Update("last_step", step)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("the code was already used")
+	}
+	return nil
+}
+
+func useRecoveryCode(userId uint, input string) error {
+	db := database.GetDB()
+	now := time.Now()
+	res := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", userId, hash(input)).
+		Update("used_at", &now)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// normalize removes the spaces and dashes users may type in codes
+func normalize(input string) string {
+	input = strings.ToLower(strings.TrimSpace(input))
+	return strings.NewReplacer(" ", "", "-", "").Replace(input)
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
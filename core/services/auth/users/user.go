@@ -1,11 +1,15 @@
 package users
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"unicode/utf8"
 
 	"github.com/gojicms/goji/core/config"
 	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/services/auth/groups"
 	"github.com/gojicms/goji/core/utils/log"
 	"github.com/google/uuid"
@@ -25,16 +29,48 @@ type User struct {
 	Group       *groups.Group `gorm:"foreignKey:GroupName;references:Name"`
 }
 
+// LoginAttempt describes an attempt to log in, as passed to EventLogin and EventLoginFailed
+type LoginAttempt struct {
+	Username   string
+	RemoteAddr string
+	// User is the user logging in; it is nil when the credentials were invalid
+	User *User
+	// Reason explains why a failed attempt was refused
+	Reason string
+}
+
+var (
+	// EventCreating is published before a user is created; listeners may modify or veto the user. The
+	// password has not yet been hashed, so listeners may enforce password rules.
+	EventCreating = extend.NewEvent[*User]("user.creating")
+	EventCreated  = extend.NewEvent[*User]("user.created")
+	EventUpdated  = extend.NewEvent[*User]("user.updated")
+	EventDeleted  = extend.NewEvent[*User]("user.deleted")
+	// EventLogin is published once a user's credentials are validated; listeners may veto the login
+	EventLogin       = extend.NewEvent[*LoginAttempt]("user.login")
+	EventLoginFailed = extend.NewEvent[*LoginAttempt]("user.login.failed")
+)
+
 func (u User) HasPermission(s string) bool {
 	if s == "" {
 		return true
 	}
-	for _, v := range u.Group.Permissions {
-		if v == s {
-			return true
-		}
+	if u.Group == nil {
+		return false
 	}
-	return false
+	return u.Group.HasPermission(s)
+}
+
+// ValidatePassword checks a new password against the password policy
+func ValidatePassword(password string) error {
+	if password == "" {
+		return errors.New("password is empty")
+	}
+	minLength := config.ActiveConfig.Application.Auth.PasswordMinLength
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("password must be at least %d characters long", minLength)
+	}
+	return nil
 }
 
 func encodePassword(password string) (string, string, error) {
@@ -49,15 +85,17 @@ func encodePassword(password string) (string, string, error) {
 }
 
 func Create(user *User) (*User, error) {
-	// TODO: Add a config for user credential restrictions such as username/password length
-	// Ensure a password was provided
-	if user.Password == "" {
-		return nil, errors.New("password is empty")
+	// TODO: Add a config for user credential restrictions such as username length
+	if err := ValidatePassword(user.Password); err != nil {
+		return nil, err
 	}
 	// Ensure a username is provided
 	if user.Username == "" {
 		return nil, errors.New("username is empty")
 	}
+	if err := EventCreating.Publish(context.Background(), user); err != nil {
+		return nil, err
+	}
 	// Generate a salt and store the password
 	passwordHashed, salt, err := encodePassword(user.Password)
 
@@ -74,6 +112,7 @@ func Create(user *User) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	EventCreated.Notify(context.Background(), user)
 	return user, nil
 }
 
@@ -103,6 +142,21 @@ func GetById(id uint) (*User, error) {
 	return &user, nil
 }
 
+// GetByUsernameOrEmail returns the user with a username or email address
+func GetByUsernameOrEmail(identifier string) (*User, error) {
+	db := database.GetDB()
+	var user User
+	res := db.Model(&User{}).Preload("Group").
+		Where("username = ?", identifier).
+		Or("email <> '' AND lower(email) = lower(?)", identifier).
+		First(&user)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	user.Password = ""
+	return &user, nil
+}
+
 func Count() (int64, error) {
 	db := database.GetDB()
 	var count int64
@@ -117,6 +171,9 @@ func Count() (int64, error) {
 func Update(user *User) error {
 	db := database.GetDB()
 	if user.Password != "" {
+		if err := ValidatePassword(user.Password); err != nil {
+			return err
+		}
 		passwordHashed, salt, err := encodePassword(user.Password)
 		if err != nil {
 			return err
@@ -129,6 +186,7 @@ func Update(user *User) error {
 		log.Error("Users", "Failed to update user with ID of %d", user.ID)
 		return err
 	}
+	EventUpdated.Notify(context.Background(), user)
 	return nil
 }
 
@@ -139,6 +197,7 @@ func Delete(user *User) error {
 		log.Error("Users", "Failed to delete user with ID of %d", user.ID)
 		return err
 	}
+	EventDeleted.Notify(context.Background(), user)
 	return nil
 }
 
@@ -160,3 +219,24 @@ func ValidateLogin(username string, password string) (*User, error) {
 	user.Password = ""
 	return user, nil
 }
+
+// Login validates the username/password like ValidateLogin, publishing EventLogin so listeners may veto the
+// login and EventLoginFailed when it is refused. remoteAddr is the address the attempt was made from.
+func Login(ctx context.Context, username string, password string, remoteAddr string) (*User, error) {
+	attempt := &LoginAttempt{Username: username, RemoteAddr: remoteAddr}
+
+	user, err := ValidateLogin(username, password)
+	if err != nil {
+		attempt.Reason = "invalid username or password"
+		EventLoginFailed.Notify(ctx, attempt)
+		return nil, err
+	}
+
+	attempt.User = user
+	if err := EventLogin.Publish(ctx, attempt); err != nil {
+		attempt.Reason = err.Error()
+		EventLoginFailed.Notify(ctx, attempt)
+		return nil, err
+	}
+	return user, nil
+}
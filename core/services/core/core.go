@@ -9,6 +9,7 @@ var Service = extend.ServiceDef{
 	FriendlyName: "Core",
 	Resources: []extend.ResourceDef{
 		coreInfoResource,
+		eventListenersResource,
 		publicResResource,
 		httpResource,
 	},
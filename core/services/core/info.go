@@ -1,24 +1,49 @@
 package core
 
 import (
+	"net/http"
+
 	"github.com/gojicms/goji/core/config"
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
 	"github.com/gojicms/goji/core/utils"
 )
 
 var coreInfoResource = extend.ResourceDef{
-	HttpValidator: extend.NewHttpValidator("GET", "/api/v1"),
+	HttpValidator: extend.NewHttpValidator("GET", "^/api/v1/?$"),
 	Handler: func(flow *httpflow.HttpFlow) {
 		var services []interface{}
 
-		for _, service := range extend.GetServices() {
+		// Services are listed in the order they were initialised
+		for _, service := range extend.GetOrderedServices() {
 			services = append(services, service.ToApiJson())
 		}
 
 		httpflow.WriteJson(flow, utils.Object{
 			"_version": config.ActiveConfig.Cms.Version,
-			"services": "",
+			"services": services,
 		})
 	},
 }
+
+// eventListenersResource lists the registered event listeners; they reveal which plugins react to what, so
+// only administrators may see them
+var eventListenersResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator("GET", "^/api/v1/events/?$"),
+	Description:   "Lists the registered event listeners",
+	Handler: func(flow *httpflow.HttpFlow) {
+		user, ok := flow.Get("user").(*users.User)
+		if !ok || user == nil {
+			httpflow.WriteUnauthorizedJson(flow)
+			return
+		}
+		if !user.HasPermission("admin") {
+			flow.WriteErrorJson(http.StatusForbidden, "forbidden; requires the admin permission")
+			return
+		}
+
+		listeners := extend.Listeners()
+		httpflow.WriteJsonList(flow, len(listeners), 0, len(listeners), "listeners", &listeners)
+	},
+}
@@ -3,9 +3,11 @@ package core
 import (
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/gojicms/goji/core/config"
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server"
 	"github.com/gojicms/goji/core/server/httpflow"
@@ -92,33 +94,31 @@ var rootDocHandler = func(flow *httpflow.HttpFlow) {
 		}
 	}
 
-	// 3. Check for dynamic routes like /a/b/{{id}}.html
+	// 3. Check for dynamic routes like /a/b/{{id}}.html or /a/b/{{slug}}.html
 	if !found {
-		// Split the path into segments
-		segments := strings.Split(path, "/")
-		if len(segments) > 0 {
-			// Try replacing the last segment with {{id}}
-			dynamicSegments := make([]string, len(segments))
-			copy(dynamicSegments, segments)
-
-			// Store the potential ID value
-			lastSegmentIndex := len(dynamicSegments) - 1
-			potentialID := dynamicSegments[lastSegmentIndex]
-
-			// Replace last segment with {{id}}
-			dynamicSegments[lastSegmentIndex] = "{{id}}"
-			dynamicPath := strings.Join(dynamicSegments, "/")
-
-			if !strings.HasSuffix(dynamicPath, ".html") {
-				dynamicPath += ".html"
-			}
+		dir, value := "", path
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			dir, value = path[:i+1], path[i+1:]
+		}
+		value = strings.TrimSuffix(value, ".html")
 
-			if fileExists("web/" + dynamicPath) {
-				filePath = dynamicPath
-				found = true
-				flow.Set("path_id", potentialID)
-				flow.Append("templateData", "path_id", potentialID)
+		route, ok := resolveDynamicRoute(dir, value)
+		if ok && route.redirect != "" {
+			target := "/" + dir + route.redirect
+			if strings.HasSuffix(path, ".html") {
+				target += ".html"
+			}
+			if flow.Request.URL.RawQuery != "" {
+				target += "?" + flow.Request.URL.RawQuery
 			}
+			flow.Redirect(target, http.StatusMovedPermanently)
+			return
+		}
+		if ok {
+			filePath = route.file
+			found = true
+			flow.Set("path_"+route.name, value)
+			flow.Append("templateData", "path_"+route.name, value)
 		}
 	}
 
@@ -130,10 +130,14 @@ var rootDocHandler = func(flow *httpflow.HttpFlow) {
 		return
 	}
 
+	// Templates may read the query string, eg. {{ .query.Get "q" }}
+	flow.Append("templateData", "query", flow.Request.URL.Query())
+
 	// Render the file
 	res, err := server.RenderFile("web/"+filePath, server.RenderOptions{
 		TemplateRoot: "web/!partials",
 		Data:         flow.Get("templateData").(utils.Object),
+		Flow:         flow,
 	})
 
 	if err != nil {
@@ -161,12 +165,66 @@ var publicResResource = extend.ResourceDef{
 var httpResource = extend.ResourceDef{
 	HttpValidator: extend.NewHttpValidator(http.MethodGet, ".+"),
 	Handler:       rootDocHandler,
+	// Leave API routes to the services registered after core, such as plugins
+	WillHandle: func(flow *httpflow.HttpFlow) bool {
+		apiRoot := config.ActiveConfig.Application.ApiRootUrl
+		return apiRoot == "" || !strings.HasPrefix(flow.Request.URL.Path, apiRoot+"/")
+	},
 }
 
 //////////////////////////////////
 // Private Methods              //
 //////////////////////////////////
 
+var dynamicRoutePattern = regexp.MustCompile(`^\{\{(\w+)\}\}\.html$`)
+
+type dynamicRoute struct {
+	file     string
+	name     string
+	redirect string
+}
+
+// resolveDynamicRoute finds the dynamic template in dir, eg. {{slug}}.html, that accepts value. Templates
+// whose parameter has a path resolver are tried first and only match values it knows; the rest match any
+// value. redirect is set when the resolver returns a different canonical value.
+func resolveDynamicRoute(dir string, value string) (dynamicRoute, bool) {
+	if value == "" {
+		return dynamicRoute{}, false
+	}
+	entries, err := os.ReadDir("web/" + dir)
+	if err != nil {
+		return dynamicRoute{}, false
+	}
+
+	var fallback *dynamicRoute
+	for _, entry := range entries {
+		match := dynamicRoutePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		route := dynamicRoute{file: dir + entry.Name(), name: match[1]}
+
+		resolver, ok := extend.GetPathResolver(route.name)
+		if !ok {
+			if fallback == nil {
+				fallback = &route
+			}
+			continue
+		}
+		if canonical, known := resolver(value); known {
+			if canonical != value {
+				route.redirect = canonical
+			}
+			return route, true
+		}
+	}
+
+	if fallback != nil {
+		return *fallback, true
+	}
+	return dynamicRoute{}, false
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
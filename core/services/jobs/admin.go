@@ -0,0 +1,269 @@
+package jobs
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//go:embed jobs.gohtml
+var jobsHtml []byte
+
+//go:embed job.gohtml
+var jobHtml []byte
+
+//go:embed queue.gohtml
+var queueHtml []byte
+
+//go:embed queued_job.gohtml
+var queuedJobHtml []byte
+
+func registerAdminPages() {
+	extend.AddSideMenuItem("Jobs", "jobs", 30, "System", "job:view")
+	extend.AddSideMenuItem("Queue", "queue", 31, "System", "job:view")
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "job:view",
+		Route:      "jobs",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Scheduled Jobs")
+
+			user := flow.Get("user").(*users.User)
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				requestRun(flow, user, result)
+			}
+
+			items, err := GetStatuses()
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			content, err := server.RenderTemplate(jobsHtml, utils.Object{
+				"items":  items,
+				"canRun": user.HasPermission("job:run"),
+				"result": result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "job:view",
+		Route:      "jobs/{name}",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Job History")
+
+			user := flow.Get("user").(*users.User)
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				requestRun(flow, user, result)
+			}
+
+			job, err := GetStatus(flow.GetKvp("admin_meta", "name"))
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			query := flow.Request.URL.Query()
+			offset := utils.Stoid(query.Get("offset"), 0)
+			count := utils.Stoid(query.Get("count"), 20)
+			runs, _ := GetRuns(job.Name, count, offset)
+			total, _ := CountRuns(job.Name)
+
+			content, err := server.RenderTemplate(jobHtml, utils.Object{
+				"job":        job,
+				"runs":       runs,
+				"offset":     offset,
+				"count":      count,
+				"totalItems": total,
+				"canRun":     user.HasPermission("job:run"),
+				"result":     result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "job:view",
+		Route:      "queue",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Job Queue")
+
+			user := flow.Get("user").(*users.User)
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			query := flow.Request.URL.Query()
+			status := query.Get("status")
+			if !slices.Contains(QueueStatuses, status) {
+				status = ""
+			}
+
+			if flow.Request.Method == "POST" && flow.PostFormValue("action") == "purge" {
+				if !user.HasPermission("job:delete") {
+					result["status"] = "error"
+					result["message"] = "You do not have permission to purge jobs."
+					goto render
+				}
+				purged, err := PurgeQueuedJobs(flow.PostFormValue("status"))
+				if err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to purge jobs: " + err.Error()
+					goto render
+				}
+				result["status"] = "success"
+				result["message"] = fmt.Sprintf("Purged %d jobs.", purged)
+			}
+
+		render:
+			counts := map[string]int64{}
+			for _, s := range QueueStatuses {
+				counts[s], _ = CountQueuedJobs(s)
+			}
+
+			offset := utils.Stoid(query.Get("offset"), 0)
+			count := utils.Stoid(query.Get("count"), 20)
+			items, err := GetQueuedJobs(status, count, offset)
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			total, _ := CountQueuedJobs(status)
+
+			content, err := server.RenderTemplate(queueHtml, utils.Object{
+				"items":      items,
+				"status":     status,
+				"statuses":   QueueStatuses,
+				"counts":     counts,
+				"offset":     offset,
+				"count":      count,
+				"totalItems": total,
+				"canPurge":   user.HasPermission("job:delete"),
+				"result":     result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "job:view",
+		Route:      "queue/{id}",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Queued Job")
+
+			user := flow.Get("user").(*users.User)
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			id := uint(utils.Stoid(flow.GetKvp("admin_meta", "id"), 0))
+
+			if flow.Request.Method == "POST" {
+				action := flow.PostFormValue("action")
+
+				if action == "purge" {
+					if !user.HasPermission("job:delete") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to purge jobs."
+						goto render
+					}
+					if err := PurgeQueuedJob(id); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to purge the job: " + err.Error()
+						goto render
+					}
+					flow.SetHeader("Location", "/admin/queue")
+					flow.WriteHeaders(http.StatusFound)
+					return []byte{}, nil
+				}
+				if action == "retry" {
+					if !user.HasPermission("job:run") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to retry jobs."
+						goto render
+					}
+					if err := RetryQueuedJob(id); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to retry the job: " + err.Error()
+						goto render
+					}
+					result["status"] = "success"
+					result["message"] = "The job will run shortly."
+				}
+			}
+
+		render:
+			job, err := GetQueuedJob(id)
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			content, err := server.RenderTemplate(queuedJobHtml, utils.Object{
+				"job":      job,
+				"canRetry": user.HasPermission("job:run") && (job.Status == QueuePending || job.Status == QueueDead),
+				"canPurge": user.HasPermission("job:delete") && job.Status != QueueRunning,
+				"result":   result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+}
+
+// requestRun handles the run now button, reporting the outcome in result
+func requestRun(flow *httpflow.HttpFlow, user *users.User, result utils.Object) {
+	if flow.PostFormValue("action") != "run" {
+		return
+	}
+	if !user.HasPermission("job:run") {
+		result["status"] = "error"
+		result["message"] = "You do not have permission to run jobs."
+		return
+	}
+
+	name := flow.PostFormValue("name")
+	if err := RunNow(name); err != nil {
+		result["status"] = "error"
+		result["message"] = "Failed to run " + name + ": " + err.Error()
+		return
+	}
+	result["status"] = "success"
+	result["message"] = name + " will run shortly."
+}
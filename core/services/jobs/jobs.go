@@ -0,0 +1,406 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/utils/log"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm/clause"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Job is the schedule and lock of a job, shared by every instance using the database
+type Job struct {
+	Name     string `gorm:"primaryKey;size:255"`
+	Schedule string `gorm:"size:255"`
+	// NextRunAt is when the job is next due
+	NextRunAt time.Time `gorm:"index"`
+	// RunRequested is set when a run is requested from the admin panel rather than by the schedule
+	RunRequested bool
+	// LockedBy is the instance running the job, which holds the job until LockedUntil
+	LockedBy    string `gorm:"size:255"`
+	LockedUntil *time.Time
+	UpdatedAt   time.Time
+}
+
+// JobRun records a single run of a job
+type JobRun struct {
+	ID       uint   `gorm:"primaryKey"`
+	JobName  string `gorm:"size:255;index"`
+	Instance string `gorm:"size:255"`
+	// Trigger is schedule for scheduled runs and manual for runs requested from the admin panel
+	Trigger    string    `gorm:"size:16"`
+	Status     string    `gorm:"size:16"`
+	Error      string    `gorm:"type:text"`
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt *time.Time
+	Duration   time.Duration
+}
+
+// Status describes a registered job for the admin panel
+type Status struct {
+	extend.JobDef
+	// Service is the name of the service declaring the job
+	Service   string
+	NextRunAt *time.Time
+	Running   bool
+	// LastRun is the most recent run, or nil if the job has never run
+	LastRun *JobRun
+}
+
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+
+	defaultTimeout = time.Hour
+)
+
+// ErrJobNotFound is returned when no job is registered with a name
+var ErrJobNotFound = errors.New("job not found")
+
+// registeredJob is a job declared by a service, along with its parsed schedule
+type registeredJob struct {
+	extend.JobDef
+	service  string
+	schedule cron.Schedule
+}
+
+var (
+	// registered holds the jobs of every service by name, and jobOrder their names in service order
+	registered = map[string]*registeredJob{}
+	jobOrder   []string
+	// instance identifies this process in the locks it holds and the runs it records
+	instance = newInstanceId()
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// ParseSchedule parses a cron expression, descriptor or "@every" interval
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// GetStatuses returns the status of every registered job, in the order services declare them
+func GetStatuses() ([]Status, error) {
+	db := database.GetDB()
+	var rows []Job
+	if err := db.Find(&rows).Error; err != nil {
+		log.Error("Jobs", "Failed to get jobs: %s", err.Error())
+		return nil, err
+	}
+	byName := map[string]Job{}
+	for _, row := range rows {
+		byName[row.Name] = row
+	}
+
+	now := time.Now()
+	var statuses []Status
+	for _, name := range jobOrder {
+		job := registered[name]
+		status := Status{JobDef: job.JobDef, Service: job.service}
+		if row, ok := byName[name]; ok {
+			status.NextRunAt = &row.NextRunAt
+			status.Running = row.LockedUntil != nil && row.LockedUntil.After(now)
+		}
+
+		var last []JobRun
+		if err := db.Where("job_name = ?", name).Order("id desc").Limit(1).Find(&last).Error; err != nil {
+			log.Error("Jobs", "Failed to get the last run of %s: %s", name, err.Error())
+			return nil, err
+		}
+		if len(last) > 0 {
+			status.LastRun = &last[0]
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// GetStatus returns the status of the named job
+func GetStatus(name string) (*Status, error) {
+	statuses, err := GetStatuses()
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		if status.Name == name {
+			return &status, nil
+		}
+	}
+	return nil, ErrJobNotFound
+}
+
+// GetRuns returns the runs of the named job, most recent first
+func GetRuns(name string, limit int, offset int) ([]JobRun, error) {
+	db := database.GetDB()
+	var runs []JobRun
+	res := db.Where("job_name = ?", name).Order("id desc").Limit(limit).Offset(offset).Find(&runs)
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to get the runs of %s: %s", name, res.Error.Error())
+		return nil, res.Error
+	}
+	return runs, nil
+}
+
+// CountRuns counts the recorded runs of the named job
+func CountRuns(name string) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&JobRun{}).Where("job_name = ?", name).Count(&count)
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to count the runs of %s: %s", name, res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// RunNow makes the named job due immediately; it is run by the first instance to claim it
+func RunNow(name string) error {
+	if _, ok := registered[name]; !ok {
+		return ErrJobNotFound
+	}
+	db := database.GetDB()
+	res := db.Model(&Job{}).Where("name = ?", name).Updates(map[string]any{
+		"next_run_at":   time.Now(),
+		"run_requested": true,
+	})
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to request a run of %s: %s", name, res.Error.Error())
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrJobNotFound
+	}
+	scheduler.poke()
+	return nil
+}
+
+// FormattedDuration returns the duration of the run rounded to the millisecond, or an empty string while
+// the run is in progress
+func (run JobRun) FormattedDuration() string {
+	if run.FinishedAt == nil {
+		return ""
+	}
+	return run.Duration.Round(time.Millisecond).String()
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// register collects the jobs declared by every service, parsing their schedules
+func register(services []*extend.ServiceDef) error {
+	registered = map[string]*registeredJob{}
+	jobOrder = nil
+
+	for _, service := range services {
+		for _, def := range service.Jobs {
+			if def.Name == "" || def.Run == nil {
+				return fmt.Errorf("a job of service %s has no name or no Run function", service.Name)
+			}
+			if existing, ok := registered[def.Name]; ok {
+				return fmt.Errorf("job %s is declared by both %s and %s", def.Name, existing.service, service.Name)
+			}
+			schedule, err := ParseSchedule(def.Schedule)
+			if err != nil {
+				return fmt.Errorf("job %s has an invalid schedule %q: %w", def.Name, def.Schedule, err)
+			}
+			if def.Timeout <= 0 {
+				def.Timeout = defaultTimeout
+			}
+			registered[def.Name] = &registeredJob{JobDef: def, service: service.Name, schedule: schedule}
+			jobOrder = append(jobOrder, def.Name)
+		}
+	}
+	return nil
+}
+
+// syncJobs creates the rows of new jobs and reschedules jobs whose schedule has changed
+func syncJobs() error {
+	db := database.GetDB()
+	now := time.Now()
+
+	for _, name := range jobOrder {
+		job := registered[name]
+
+		var rows []Job
+		if err := db.Where("name = ?", name).Limit(1).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			// Another instance may create the row first, in which case its schedule stands
+			row := Job{Name: name, Schedule: job.Schedule, NextRunAt: job.schedule.Next(now)}
+			if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if rows[0].Schedule != job.Schedule {
+			err := db.Model(&Job{}).Where("name = ?", name).Updates(map[string]any{
+				"schedule":    job.Schedule,
+				"next_run_at": job.schedule.Next(now),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextRunAt returns when the next registered job that is not running is due, or nil if there are none
+func nextRunAt() (*time.Time, error) {
+	if len(jobOrder) == 0 {
+		return nil, nil
+	}
+	db := database.GetDB()
+	var rows []Job
+	res := db.Where("name IN ? AND (locked_until IS NULL OR locked_until < ?)", jobOrder, time.Now()).
+		Order("next_run_at").Limit(1).Find(&rows)
+	if res.Error != nil || len(rows) == 0 {
+		return nil, res.Error
+	}
+	return &rows[0].NextRunAt, nil
+}
+
+// dueJobs returns the registered jobs that are due and not held by any instance
+func dueJobs() ([]Job, error) {
+	if len(jobOrder) == 0 {
+		return nil, nil
+	}
+	db := database.GetDB()
+	now := time.Now()
+	var rows []Job
+	err := db.Where("name IN ? AND next_run_at <= ? AND (locked_until IS NULL OR locked_until < ?)", jobOrder, now, now).
+		Find(&rows).Error
+	return rows, err
+}
+
+// claim takes the lock of a due job and schedules its next run, returning false if another instance got
+// there first. Any run of the job still marked as running was abandoned by an instance that stopped.
+func claim(row Job) (bool, error) {
+	job := registered[row.Name]
+	db := database.GetDB()
+	now := time.Now()
+	lockedUntil := now.Add(job.Timeout)
+
+	res := db.Model(&Job{}).
+		Where("name = ? AND next_run_at <= ? AND (locked_until IS NULL OR locked_until < ?)", row.Name, now, now).
+		Updates(map[string]any{
+			"locked_by":     instance,
+			"locked_until":  lockedUntil,
+			"next_run_at":   job.schedule.Next(now),
+			"run_requested": false,
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+
+	err := db.Model(&JobRun{}).Where("job_name = ? AND status = ?", row.Name, RunRunning).Updates(map[string]any{
+		"status": RunFailed,
+		"error":  "the run was abandoned before it finished",
+	}).Error
+	return true, err
+}
+
+// release gives up the lock of a job held by this instance
+func release(name string) {
+	db := database.GetDB()
+	// A lock left unreleased would hold the job until its timeout
+	err := database.Retry(func() error {
+		return db.Model(&Job{}).Where("name = ? AND locked_by = ?", name, instance).Updates(map[string]any{
+			"locked_by":    "",
+			"locked_until": nil,
+		}).Error
+	})
+	if err != nil {
+		log.Error("Jobs", "Failed to release the lock of %s: %s", name, err.Error())
+	}
+}
+
+// execute runs a claimed job, recording the run and releasing the lock once it finishes
+func execute(ctx context.Context, row Job) {
+	job := registered[row.Name]
+	defer release(job.Name)
+
+	trigger := TriggerSchedule
+	if row.RunRequested {
+		trigger = TriggerManual
+	}
+	run := JobRun{JobName: job.Name, Instance: instance, Trigger: trigger, Status: RunRunning, StartedAt: time.Now()}
+	db := database.GetDB()
+	if err := database.Retry(func() error { return db.Create(&run).Error }); err != nil {
+		log.Error("Jobs", "Failed to record a run of %s: %s", job.Name, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	log.Debug("Jobs", "Running %s", job.Name)
+	err := call(ctx, job.Name, job.Run)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Duration = finished.Sub(run.StartedAt)
+	run.Status = RunSucceeded
+	if err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+		log.Error("Jobs", "Job %s failed: %s", job.Name, err.Error())
+	}
+	if err := database.Retry(func() error { return db.Save(&run).Error }); err != nil {
+		log.Error("Jobs", "Failed to record a run of %s: %s", job.Name, err.Error())
+	}
+	pruneRuns(job.Name, config.ActiveConfig.Application.Jobs.HistoryLimit)
+}
+
+// call runs a job, converting a panic into an error
+func call(ctx context.Context, name string, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Jobs", "Job %s panicked: %v\n%s", name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+// pruneRuns deletes all but the most recent limit runs of a job
+func pruneRuns(name string, limit int) {
+	if limit <= 0 {
+		return
+	}
+	db := database.GetDB()
+	var oldest []JobRun
+	if err := db.Where("job_name = ?", name).Order("id desc").Offset(limit - 1).Limit(1).Find(&oldest).Error; err != nil || len(oldest) == 0 {
+		return
+	}
+	if err := db.Where("job_name = ? AND id < ?", name, oldest[0].ID).Delete(&JobRun{}).Error; err != nil {
+		log.Error("Jobs", "Failed to prune the runs of %s: %s", name, err.Error())
+	}
+}
+
+// newInstanceId identifies this process by host name and process id
+func newInstanceId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
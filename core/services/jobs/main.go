@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Service Definition           //
+//////////////////////////////////
+
+var Service = extend.ServiceDef{
+	Name:         "jobs",
+	FriendlyName: "Background Jobs",
+	DependsOn:    []string{"administration", "authentication"},
+	Permissions: []extend.PermissionDef{
+		{Name: "job:view", Description: "View scheduled jobs and their history", DefaultGroups: []string{"administrator"}},
+		{Name: "job:run", Description: "Run scheduled jobs on demand and retry queued jobs", DefaultGroups: []string{"administrator"}},
+		{Name: "job:delete", Description: "Purge queued jobs", DefaultGroups: []string{"administrator"}},
+	},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_jobs",
+			Up:      database.AutoMigrateModels(&jobV1{}, &jobRunV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&jobRunV1{}, &jobV1{})
+			},
+		},
+		{
+			Version: 2,
+			Name:    "create_queued_jobs",
+			Up:      database.AutoMigrateModels(&QueuedJob{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&QueuedJob{})
+			},
+		},
+	},
+	Jobs: []extend.JobDef{
+		{
+			Name:        "jobs.queue_cleanup",
+			Description: "Deletes queued jobs that succeeded longer ago than the retention period",
+			Schedule:    "@hourly",
+			Run: func(ctx context.Context) error {
+				return CleanUpQueue(time.Now().Add(-config.ActiveConfig.Application.Jobs.Retention))
+			},
+		},
+	},
+	OnInit: func() error {
+		// Every service is registered by now, although some may not have initialised yet
+		if err := register(extend.GetOrderedServices()); err != nil {
+			log.Error("Jobs", "Failed to register jobs: %s", err.Error())
+			return err
+		}
+		registerAdminPages()
+		return nil
+	},
+	// Jobs only start once every service, and so every table a job may use, is ready
+	OnStart: func() error {
+		if err := syncJobs(); err != nil {
+			log.Error("Jobs", "Failed to schedule jobs: %s", err.Error())
+			return err
+		}
+		scheduler.start()
+		queue.start(config.ActiveConfig.Application.Jobs.Workers)
+		return nil
+	},
+	OnShutdown: func(ctx context.Context) error {
+		return errors.Join(queue.stop(ctx), scheduler.stop(ctx))
+	},
+}
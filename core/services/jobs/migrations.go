@@ -0,0 +1,38 @@
+package jobs
+
+import "time"
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// jobV1 and jobRunV1 are the tables as created by migration 1; they must not change along with Job and JobRun
+type jobV1 struct {
+	Name         string    `gorm:"primaryKey;size:255"`
+	Schedule     string    `gorm:"size:255"`
+	NextRunAt    time.Time `gorm:"index"`
+	RunRequested bool
+	LockedBy     string `gorm:"size:255"`
+	LockedUntil  *time.Time
+	UpdatedAt    time.Time
+}
+
+func (jobV1) TableName() string {
+	return "jobs"
+}
+
+type jobRunV1 struct {
+	ID         uint      `gorm:"primaryKey"`
+	JobName    string    `gorm:"size:255;index"`
+	Instance   string    `gorm:"size:255"`
+	Trigger    string    `gorm:"size:16"`
+	Status     string    `gorm:"size:16"`
+	Error      string    `gorm:"type:text"`
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt *time.Time
+	Duration   time.Duration
+}
+
+func (jobRunV1) TableName() string {
+	return "job_runs"
+}
@@ -0,0 +1,496 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// QueuedJob is a unit of background work enqueued by a task. Jobs are run at least once: a job whose
+// instance stops before recording the outcome is run again once its lock expires.
+type QueuedJob struct {
+	ID      uint   `gorm:"primaryKey"`
+	Task    string `gorm:"size:255;index"`
+	Payload string `gorm:"type:text"`
+	Status  string `gorm:"size:16;index"`
+	// Attempts counts the runs so far, including the current one while the job is running
+	Attempts    int
+	MaxAttempts int
+	// RunAt is when the job is next due
+	RunAt time.Time `gorm:"index"`
+	// Key is the uniqueness key the job was enqueued with. UniqueKey holds it, prefixed by the task, only
+	// while the job is pending or running, so that no two such jobs wait at once.
+	Key       string  `gorm:"size:255"`
+	UniqueKey *string `gorm:"size:512;uniqueIndex"`
+	// LockedBy is the instance running the job, which holds the job until LockedUntil
+	LockedBy    string `gorm:"size:255"`
+	LockedUntil *time.Time
+	LastError   string `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	FinishedAt  *time.Time
+}
+
+// TaskOptions controls how the jobs of a task are run and retried
+type TaskOptions struct {
+	// MaxAttempts is how many times a job is run before it is dead; defaults to 5
+	MaxAttempts int
+	// Timeout bounds each run of a job; defaults to 10 minutes
+	Timeout time.Duration
+	// Backoff is the delay before the first retry, which doubles with each attempt up to an hour;
+	// defaults to 10 seconds
+	Backoff time.Duration
+}
+
+// EnqueueOptions controls a single enqueued job
+type EnqueueOptions struct {
+	// Key prevents the job being enqueued while a pending or running job of the same task has the same
+	// key; that job is returned instead
+	Key string
+	// RunAt delays the job until the given time
+	RunAt time.Time
+}
+
+// Task is a kind of background job whose payload is a T, serialised as JSON. Tasks are declared as
+// package variables with NewTask, so every instance knows how to run them:
+//
+//	var SendWelcome = jobs.NewTask("users.welcome", jobs.TaskOptions{}, func(ctx context.Context, id uint) error {
+//		...
+//	})
+//
+//	SendWelcome.Enqueue(user.ID)
+type Task[T any] struct {
+	name string
+}
+
+const (
+	QueuePending   = "pending"
+	QueueRunning   = "running"
+	QueueSucceeded = "succeeded"
+	// QueueDead is the state of jobs that failed their last attempt, or failed with a Permanent error
+	QueueDead = "dead"
+
+	defaultMaxAttempts = 5
+	defaultTaskTimeout = time.Minute * 10
+	defaultBackoff     = time.Second * 10
+	maxBackoff         = time.Hour
+)
+
+// QueueStatuses lists the states of queued jobs in the order they are shown
+var QueueStatuses = []string{QueuePending, QueueRunning, QueueSucceeded, QueueDead}
+
+var (
+	ErrQueuedJobNotFound = errors.New("queued job not found")
+	ErrUnknownTask       = errors.New("unknown task")
+)
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// registeredTask is the options and handler of a task declared with NewTask
+type registeredTask struct {
+	TaskOptions
+	handler func(ctx context.Context, payload []byte) error
+}
+
+var (
+	tasks   = map[string]*registeredTask{}
+	tasksMu sync.RWMutex
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// NewTask declares a task, whose jobs are run by handler. A job is retried with exponential backoff
+// while handler returns an error, unless the error is Permanent.
+func NewTask[T any](name string, options TaskOptions, handler func(ctx context.Context, payload T) error) Task[T] {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultMaxAttempts
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTaskTimeout
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = defaultBackoff
+	}
+
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	if _, ok := tasks[name]; ok {
+		panic(fmt.Sprintf("task %s is declared twice", name))
+	}
+	tasks[name] = &registeredTask{
+		TaskOptions: options,
+		handler: func(ctx context.Context, raw []byte) error {
+			var payload T
+			if err := json.Unmarshal(raw, &payload); err != nil {
+				return Permanent(fmt.Errorf("invalid payload: %w", err))
+			}
+			return handler(ctx, payload)
+		},
+	}
+	return Task[T]{name: name}
+}
+
+// Name returns the name of the task
+func (t Task[T]) Name() string {
+	return t.name
+}
+
+// Enqueue adds a job to the queue, to be run as soon as a worker is free
+func (t Task[T]) Enqueue(payload T) (*QueuedJob, error) {
+	return t.EnqueueWith(payload, EnqueueOptions{})
+}
+
+// EnqueueWith adds a job to the queue with a uniqueness key or a delay
+func (t Task[T]) EnqueueWith(payload T, options EnqueueOptions) (*QueuedJob, error) {
+	task := getTask(t.name)
+	if task == nil {
+		return nil, ErrUnknownTask
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := QueuedJob{
+		Task:        t.name,
+		Payload:     string(data),
+		Status:      QueuePending,
+		MaxAttempts: task.MaxAttempts,
+		RunAt:       options.RunAt,
+		Key:         options.Key,
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if options.Key != "" {
+		unique := t.name + ":" + options.Key
+		job.UniqueKey = &unique
+	}
+
+	db := database.GetDB()
+	var created int64
+	err = database.Retry(func() error {
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&job)
+		created = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		log.Error("Jobs", "Failed to enqueue %s: %s", t.name, err.Error())
+		return nil, err
+	}
+	if created == 0 {
+		// A job with the same key is already waiting
+		var existing QueuedJob
+		if err := db.Where("unique_key = ?", *job.UniqueKey).First(&existing).Error; err != nil {
+			return nil, err
+		}
+		return &existing, nil
+	}
+
+	queue.poke()
+	return &job, nil
+}
+
+// Permanent wraps an error returned by a task to mark the job dead without retrying it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// GetQueuedJobs returns the queued jobs with a status, or every job if status is empty, newest first
+func GetQueuedJobs(status string, limit int, offset int) ([]QueuedJob, error) {
+	db := database.GetDB()
+	var jobs []QueuedJob
+	res := db.Scopes(withStatus(status)).Order("id desc").Limit(limit).Offset(offset).Find(&jobs)
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to get queued jobs: %s", res.Error.Error())
+		return nil, res.Error
+	}
+	return jobs, nil
+}
+
+// CountQueuedJobs counts the queued jobs with a status, or every job if status is empty
+func CountQueuedJobs(status string) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&QueuedJob{}).Scopes(withStatus(status)).Count(&count)
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to count queued jobs: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// GetQueuedJob gets a queued job by its id
+func GetQueuedJob(id uint) (*QueuedJob, error) {
+	db := database.GetDB()
+	var job QueuedJob
+	res := db.Limit(1).Find(&job, id)
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to get queued job %d: %s", id, res.Error.Error())
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrQueuedJobNotFound
+	}
+	return &job, nil
+}
+
+// RetryQueuedJob makes a pending job due immediately, or gives a dead job a fresh set of attempts
+func RetryQueuedJob(id uint) error {
+	job, err := GetQueuedJob(id)
+	if err != nil {
+		return err
+	}
+	if job.Status != QueuePending && job.Status != QueueDead {
+		return fmt.Errorf("a %s job cannot be retried", job.Status)
+	}
+
+	updates := map[string]any{"status": QueuePending, "run_at": time.Now(), "finished_at": nil}
+	if job.Status == QueueDead {
+		updates["attempts"] = 0
+		if job.Key != "" {
+			updates["unique_key"] = job.Task + ":" + job.Key
+		}
+	}
+
+	db := database.GetDB()
+	res := db.Model(&QueuedJob{}).Where("id = ? AND status = ?", id, job.Status).Updates(updates)
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to retry queued job %d: %s", id, res.Error.Error())
+		if job.Key != "" {
+			return fmt.Errorf("another %s job with key %s may already be waiting: %w", job.Task, job.Key, res.Error)
+		}
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("the job changed while it was being retried")
+	}
+	queue.poke()
+	return nil
+}
+
+// PurgeQueuedJob deletes a queued job that is not running
+func PurgeQueuedJob(id uint) error {
+	db := database.GetDB()
+	res := db.Where("id = ? AND status <> ?", id, QueueRunning).Delete(&QueuedJob{})
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to purge queued job %d: %s", id, res.Error.Error())
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("the job does not exist or is running")
+	}
+	return nil
+}
+
+// PurgeQueuedJobs deletes every succeeded or dead job, returning how many were deleted
+func PurgeQueuedJobs(status string) (int64, error) {
+	if status != QueueSucceeded && status != QueueDead {
+		return 0, fmt.Errorf("%s jobs cannot be purged", status)
+	}
+	db := database.GetDB()
+	res := db.Where("status = ?", status).Delete(&QueuedJob{})
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to purge %s jobs: %s", status, res.Error.Error())
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
+
+// CleanUpQueue deletes the jobs that succeeded before the given time
+func CleanUpQueue(before time.Time) error {
+	db := database.GetDB()
+	res := db.Where("status = ? AND finished_at < ?", QueueSucceeded, before).Delete(&QueuedJob{})
+	if res.Error != nil {
+		log.Error("Jobs", "Failed to clean up the queue: %s", res.Error.Error())
+		return res.Error
+	}
+	return nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func getTask(name string) *registeredTask {
+	tasksMu.RLock()
+	defer tasksMu.RUnlock()
+	return tasks[name]
+}
+
+// taskNames returns the names of the tasks this instance can run
+func taskNames() []string {
+	tasksMu.RLock()
+	defer tasksMu.RUnlock()
+	names := make([]string, 0, len(tasks))
+	for name := range tasks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func withStatus(status string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if status == "" {
+			return db
+		}
+		return db.Where("status = ?", status)
+	}
+}
+
+// claimable selects the jobs due to run: pending jobs whose time has come, and running jobs whose instance
+// stopped before finishing them
+func claimable(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?)", QueuePending, now, QueueRunning, now)
+}
+
+// nextQueuedAt returns when the next pending job of a known task is due, or nil if there are none
+func nextQueuedAt() (*time.Time, error) {
+	names := taskNames()
+	if len(names) == 0 {
+		return nil, nil
+	}
+	db := database.GetDB()
+	var jobs []QueuedJob
+	res := db.Select("run_at").Where("task IN ? AND status = ?", names, QueuePending).Order("run_at").Limit(1).Find(&jobs)
+	if res.Error != nil || len(jobs) == 0 {
+		return nil, res.Error
+	}
+	return &jobs[0].RunAt, nil
+}
+
+// claimNext locks the next due job of a known task, returning nil if there is none or other instances
+// claimed every candidate first
+func claimNext() (*QueuedJob, error) {
+	names := taskNames()
+	if len(names) == 0 {
+		return nil, nil
+	}
+	db := database.GetDB()
+	now := time.Now()
+
+	var candidates []QueuedJob
+	if err := claimable(db.Where("task IN ?", names), now).Order("run_at").Limit(10).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	for _, job := range candidates {
+		if job.Status == QueueRunning && job.Attempts >= job.MaxAttempts {
+			// The last attempt was abandoned, so the job is not run again
+			res := claimable(db.Model(&QueuedJob{}).Where("id = ?", job.ID), now).Updates(map[string]any{
+				"status":       QueueDead,
+				"locked_by":    "",
+				"locked_until": nil,
+				"unique_key":   nil,
+				"finished_at":  now,
+				"last_error":   "the job was abandoned by " + job.LockedBy + " before it finished",
+			})
+			if res.Error != nil {
+				return nil, res.Error
+			}
+			continue
+		}
+
+		lockedUntil := now.Add(getTask(job.Task).Timeout)
+		res := claimable(db.Model(&QueuedJob{}).Where("id = ?", job.ID), now).Updates(map[string]any{
+			"status":       QueueRunning,
+			"locked_by":    instance,
+			"locked_until": lockedUntil,
+			"attempts":     gorm.Expr("attempts + 1"),
+		})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		job.Status = QueueRunning
+		job.LockedBy = instance
+		job.LockedUntil = &lockedUntil
+		job.Attempts++
+		return &job, nil
+	}
+	return nil, nil
+}
+
+// process runs a claimed job and records the outcome, scheduling a retry if it failed
+func process(ctx context.Context, job *QueuedJob) {
+	task := getTask(job.Task)
+	runCtx, cancel := context.WithTimeout(ctx, task.Timeout)
+	defer cancel()
+
+	log.Debug("Jobs", "Running queued job %d (%s), attempt %d", job.ID, job.Task, job.Attempts)
+	err := call(runCtx, job.Task, func(ctx context.Context) error {
+		return task.handler(ctx, []byte(job.Payload))
+	})
+
+	now := time.Now()
+	updates := map[string]any{"locked_by": "", "locked_until": nil}
+	var permanent permanentError
+	switch {
+	case err == nil:
+		updates["status"] = QueueSucceeded
+		updates["finished_at"] = now
+		updates["unique_key"] = nil
+		updates["last_error"] = ""
+	case ctx.Err() != nil:
+		// The server is shutting down; the interrupted attempt does not count
+		updates["status"] = QueuePending
+		updates["run_at"] = now
+		updates["attempts"] = job.Attempts - 1
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		log.Error("Jobs", "Queued job %d (%s) failed for good: %s", job.ID, job.Task, err.Error())
+		updates["status"] = QueueDead
+		updates["finished_at"] = now
+		updates["unique_key"] = nil
+		updates["last_error"] = err.Error()
+	default:
+		log.Warn("Jobs", "Queued job %d (%s) failed, retrying: %s", job.ID, job.Task, err.Error())
+		updates["status"] = QueuePending
+		updates["run_at"] = now.Add(backoff(task.Backoff, job.Attempts))
+		updates["last_error"] = err.Error()
+	}
+
+	db := database.GetDB()
+	err = database.Retry(func() error {
+		return db.Model(&QueuedJob{}).Where("id = ? AND locked_by = ?", job.ID, instance).Updates(updates).Error
+	})
+	if err != nil {
+		log.Error("Jobs", "Failed to record the outcome of queued job %d: %s", job.ID, err.Error())
+	}
+}
+
+// backoff returns the delay before retrying a job that has failed attempts times
+func backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+// maxSchedulerInterval bounds how long the scheduler sleeps, so runs requested or rescheduled by other
+// instances sharing the database are picked up promptly
+const maxSchedulerInterval = time.Minute
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// jobScheduler claims and runs jobs as they fall due, waking at the next scheduled run
+type jobScheduler struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wake    chan struct{}
+	running sync.WaitGroup
+}
+
+var scheduler = &jobScheduler{}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func (s *jobScheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.wake = make(chan struct{}, 1)
+	go s.run(ctx)
+}
+
+// stop stops the scheduler and cancels running jobs, waiting for them to finish until ctx expires
+func (s *jobScheduler) stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		<-done
+		s.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poke wakes the scheduler so a requested run starts straight away
+func (s *jobScheduler) poke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wake == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *jobScheduler) run(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		s.runDue(ctx)
+
+		wait := maxSchedulerInterval
+		if next, err := nextRunAt(); err == nil && next != nil {
+			wait = max(min(wait, time.Until(*next)), 0)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// runDue starts every due job this instance manages to claim
+func (s *jobScheduler) runDue(ctx context.Context) {
+	due, err := dueJobs()
+	if err != nil {
+		log.Error("Jobs", "Failed to get due jobs: %s", err.Error())
+		return
+	}
+
+	for _, row := range due {
+		claimed, err := claim(row)
+		if err != nil {
+			log.Error("Jobs", "Failed to claim %s: %s", row.Name, err.Error())
+		}
+		if !claimed {
+			continue
+		}
+
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			execute(ctx, row)
+			// The job may have fallen due again while it ran
+			s.poke()
+		}()
+	}
+}
@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+// maxQueueInterval bounds how long idle workers wait, so jobs enqueued by other instances sharing the
+// database are picked up promptly
+const maxQueueInterval = time.Second * 5
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// jobQueue claims queued jobs as they fall due and runs them on a fixed number of workers
+type jobQueue struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wake    chan struct{}
+	running sync.WaitGroup
+}
+
+var queue = &jobQueue{}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func (q *jobQueue) start(workers int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+	q.wake = make(chan struct{}, 1)
+	go q.run(ctx, max(workers, 1))
+}
+
+// stop stops claiming jobs and cancels running ones, waiting for them to finish until ctx expires
+func (q *jobQueue) stop(ctx context.Context) error {
+	q.mu.Lock()
+	cancel, done := q.cancel, q.done
+	q.cancel = nil
+	q.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		<-done
+		q.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poke wakes an idle queue so a newly enqueued job starts straight away
+func (q *jobQueue) poke() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.wake == nil {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *jobQueue) run(ctx context.Context, workers int) {
+	defer close(q.done)
+	slots := make(chan struct{}, workers)
+
+	for {
+		// Wait for a free worker before claiming anything
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		job, err := claimNext()
+		if err != nil {
+			log.Error("Jobs", "Failed to claim a queued job: %s", err.Error())
+		}
+		if job != nil {
+			q.running.Add(1)
+			go func() {
+				defer q.running.Done()
+				defer func() { <-slots }()
+				process(ctx, job)
+			}()
+			continue
+		}
+		<-slots
+
+		wait := maxQueueInterval
+		if next, err := nextQueuedAt(); err == nil && next != nil {
+			wait = max(min(wait, time.Until(*next)), 0)
+		}
+		if err != nil {
+			// Back off rather than spin while the database is unavailable
+			wait = maxQueueInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package mail
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//go:embed send.gohtml
+var sendHtml []byte
+
+//go:embed test.html.gohtml
+var testHtml []byte
+
+//go:embed test.txt.gohtml
+var testText []byte
+
+// testTemplate is sent from the admin panel to check mail is delivered
+var testTemplate = NewTemplate("mail.test", "A test sent from the admin panel", "Test email from Goji", testHtml, testText)
+
+func registerAdminPages() {
+	extend.AddSideMenuItem("Mail", "mail", 50, "System", "mail:send")
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "mail:send",
+		Route:      "mail",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Mail")
+
+			user := flow.Get("user").(*users.User)
+			to := user.Email
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" && flow.PostFormValue("action") == "send" {
+				to = strings.TrimSpace(flow.PostFormValue("to"))
+				message, err := testTemplate.Render(to, utils.Object{
+					"site":   config.ActiveConfig.Application.RootUrl,
+					"sender": user.DisplayName,
+				})
+				if err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to render the test email: " + err.Error()
+					goto render
+				}
+				// The test is sent immediately so that transport errors are reported here
+				if err := SendNow(flow.Request.Context(), message); err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to send the test email: " + err.Error()
+					goto render
+				}
+				result["status"] = "success"
+				result["message"] = "A test email was sent to " + to
+			}
+
+		render:
+			mailConfig := config.ActiveConfig.Application.Mail
+			content, err := server.RenderTemplate(sendHtml, utils.Object{
+				"to":           to,
+				"transport":    mailConfig.Transport,
+				"from":         mailConfig.From,
+				"templatePath": mailConfig.TemplatePath,
+				"templates":    Templates(),
+				"result":       result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+}
@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/services/jobs"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Template renders one kind of message, such as a password reset. Each part may be overridden by a file in
+// the configured template directory named after the template, eg. "password_reset.html.gohtml" for the HTML
+// body, "password_reset.txt.gohtml" for the text body and "password_reset.subject.gohtml" for the subject.
+type Template struct {
+	Name        string
+	Description string
+	subject     []byte
+	html        []byte
+	text        []byte
+}
+
+// ErrUnknownTemplate is returned when sending a template that has not been declared
+var ErrUnknownTemplate = errors.New("unknown mail template")
+
+var templates = map[string]*Template{}
+
+// sendTask sends a queued message, retrying while the transport is unavailable
+var sendTask = jobs.NewTask("mail.send", jobs.TaskOptions{
+	MaxAttempts: 5,
+	Timeout:     time.Minute,
+	Backoff:     time.Minute,
+}, func(ctx context.Context, message Message) error {
+	return SendNow(ctx, &message)
+})
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// NewTemplate declares a kind of message with its default subject, HTML and text templates; either body
+// may be empty. Templates are rendered with server.RenderTemplate, so the global template functions are
+// available. It panics if a template with the same name was already declared.
+func NewTemplate(name string, description string, subject string, html []byte, text []byte) *Template {
+	if _, ok := templates[name]; ok {
+		panic(fmt.Sprintf("mail template %s is already declared", name))
+	}
+	t := &Template{Name: name, Description: description, subject: []byte(subject), html: html, text: text}
+	templates[name] = t
+	return t
+}
+
+// Templates returns every declared template, by name
+func Templates() []*Template {
+	list := make([]*Template, 0, len(templates))
+	for _, t := range templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetTemplate returns the template with a name
+func GetTemplate(name string) (*Template, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	return t, nil
+}
+
+// Render renders the template for a recipient into a message
+func (t *Template) Render(to string, data any) (*Message, error) {
+	subject, err := t.render("subject", t.subject, data)
+	if err != nil {
+		return nil, err
+	}
+	htmlBody, err := t.render("html", t.html, data)
+	if err != nil {
+		return nil, err
+	}
+	textBody, err := t.render("txt", t.text, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		To:       []string{to},
+		Subject:  singleLine(html.UnescapeString(subject)),
+		Html:     htmlBody,
+		Text:     html.UnescapeString(textBody),
+		Template: t.Name,
+	}, nil
+}
+
+// Send renders the template for a recipient and queues the message
+func (t *Template) Send(to string, data any) error {
+	message, err := t.Render(to, data)
+	if err != nil {
+		log.Error("Mail", "Failed to render %s: %s", t.Name, err.Error())
+		return err
+	}
+	return Send(message)
+}
+
+// Send queues a message to be sent in the background, filling in the configured sender if it has none
+func Send(message *Message) error {
+	if message.From == "" {
+		message.From = config.ActiveConfig.Application.Mail.From
+	}
+	if err := message.Validate(); err != nil {
+		return err
+	}
+	_, err := sendTask.Enqueue(*message)
+	return err
+}
+
+// SendNow sends a message immediately with the active transport, filling in the configured sender if it
+// has none
+func SendNow(ctx context.Context, message *Message) error {
+	if message.From == "" {
+		message.From = config.ActiveConfig.Application.Mail.From
+	}
+	if err := message.Validate(); err != nil {
+		return jobs.Permanent(err)
+	}
+	transport := GetTransport()
+	if transport == nil {
+		return errors.New("no mail transport is configured")
+	}
+	if err := transport.Send(ctx, message); err != nil {
+		log.Error("Mail", "Failed to send %q to %s: %s", message.Subject, strings.Join(message.To, ", "), err.Error())
+		return err
+	}
+	return nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// render renders one part of the template, preferring an override in the template directory
+func (t *Template) render(part string, fallback []byte, data any) (string, error) {
+	root := config.ActiveConfig.Application.Mail.TemplatePath
+	content := fallback
+	if root != "" {
+		override, err := os.ReadFile(filepath.Join(root, t.Name+"."+part+".gohtml"))
+		if err == nil {
+			content = override
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read the %s template of %s: %w", part, t.Name, err)
+		}
+	}
+	if len(content) == 0 {
+		return "", nil
+	}
+
+	rendered, err := server.RenderTemplate(content, data, server.RenderOptions{TemplateRoot: root})
+	if err != nil {
+		return "", fmt.Errorf("failed to render the %s template of %s: %w", part, t.Name, err)
+	}
+	return strings.TrimSpace(string(rendered)), nil
+}
@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Service Definition           //
+//////////////////////////////////
+
+var Service = extend.ServiceDef{
+	Name:         "mail",
+	FriendlyName: "Mail",
+	DependsOn:    []string{"administration", "authentication", "jobs"},
+	Permissions: []extend.PermissionDef{
+		{Name: "mail:send", Description: "Send test emails", DefaultGroups: []string{"administrator"}},
+	},
+	OnInit: func() error {
+		// A plugin may have provided its own transport
+		if GetTransport() == nil {
+			transport, err := NewTransport(config.ActiveConfig.Application.Mail)
+			if err != nil {
+				log.Error("Mail", "Failed to create mail transport: %s", err.Error())
+				return err
+			}
+			SetTransport(transport)
+		}
+
+		registerAdminPages()
+		return nil
+	},
+}
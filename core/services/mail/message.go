@@ -0,0 +1,175 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Message is an email ready to be sent. Addresses may include a name, eg. "Ann <ann@example.com>".
+type Message struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	ReplyTo string   `json:"reply_to,omitempty"`
+	Subject string   `json:"subject"`
+	// Text and Html are the alternative bodies of the message; at least one is required
+	Text string `json:"text,omitempty"`
+	Html string `json:"html,omitempty"`
+	// Template is the name of the template the message was rendered from, if any
+	Template string `json:"template,omitempty"`
+}
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Validate checks the message has a sender, recipients and a body, and that its addresses are valid
+func (message *Message) Validate() error {
+	if _, err := mail.ParseAddress(message.From); err != nil {
+		return fmt.Errorf("invalid sender %q: %w", message.From, err)
+	}
+	if len(message.To) == 0 {
+		return errors.New("the message has no recipients")
+	}
+	for _, to := range message.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+	}
+	if message.ReplyTo != "" {
+		if _, err := mail.ParseAddress(message.ReplyTo); err != nil {
+			return fmt.Errorf("invalid reply-to address %q: %w", message.ReplyTo, err)
+		}
+	}
+	if message.Text == "" && message.Html == "" {
+		return errors.New("the message has no body")
+	}
+	return nil
+}
+
+// Sender returns the bare address of the sender, as given to SMTP servers
+func (message *Message) Sender() string {
+	address, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return message.From
+	}
+	return address.Address
+}
+
+// Recipients returns the bare addresses of the recipients
+func (message *Message) Recipients() []string {
+	recipients := make([]string, 0, len(message.To))
+	for _, to := range message.To {
+		if address, err := mail.ParseAddress(to); err == nil {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	return recipients
+}
+
+// Bytes encodes the message in the Internet Message Format, with the text and HTML bodies as
+// multipart/alternative parts
+func (message *Message) Bytes() ([]byte, error) {
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	header := func(name string, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	from, _ := mail.ParseAddress(message.From)
+	header("From", from.String())
+	to := make([]string, 0, len(message.To))
+	for _, recipient := range message.To {
+		address, _ := mail.ParseAddress(recipient)
+		to = append(to, address.String())
+	}
+	header("To", strings.Join(to, ", "))
+	if message.ReplyTo != "" {
+		replyTo, _ := mail.ParseAddress(message.ReplyTo)
+		header("Reply-To", replyTo.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", singleLine(message.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", newMessageId(from.Address))
+	header("MIME-Version", "1.0")
+
+	if message.Text == "" || message.Html == "" {
+		contentType, body := "text/plain; charset=utf-8", message.Text
+		if message.Html != "" {
+			contentType, body = "text/html; charset=utf-8", message.Html
+		}
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.Html},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(writer, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write([]byte(body)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// singleLine removes line breaks, which would otherwise let a value inject headers
+func singleLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func newMessageId(sender string) string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	domain := "localhost"
+	if _, host, ok := strings.Cut(sender, "@"); ok && host != "" {
+		domain = host
+	}
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
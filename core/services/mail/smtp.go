@@ -0,0 +1,116 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/services/jobs"
+)
+
+// SMTPTransport sends messages through an SMTP server
+type SMTPTransport struct {
+	config config.SMTPConfig
+}
+
+// NewSMTPTransport creates a transport sending through the configured server
+func NewSMTPTransport(smtpConfig config.SMTPConfig) (*SMTPTransport, error) {
+	if smtpConfig.Host == "" {
+		return nil, errors.New("an SMTP host is required")
+	}
+	return &SMTPTransport{config: smtpConfig}, nil
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, message *Message) error {
+	content, err := message.Bytes()
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	client, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := t.deliver(client, message, content); err != nil {
+		// Permanent failures, such as an unknown mailbox, are reported with 5xx codes
+		var protocolErr *textproto.Error
+		if errors.As(err, &protocolErr) && protocolErr.Code >= 500 {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+	return client.Quit()
+}
+
+// dial connects and authenticates to the server, securing the connection as configured
+func (t *SMTPTransport) dial(ctx context.Context) (*smtp.Client, error) {
+	address := net.JoinHostPort(t.config.Host, strconv.Itoa(t.config.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: t.config.Host}
+
+	var conn net.Conn
+	var err error
+	if t.config.TLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, t.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if t.config.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, fmt.Errorf("%s does not support STARTTLS", address)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	if t.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.Host)); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+	return client, nil
+}
+
+func (t *SMTPTransport) deliver(client *smtp.Client, message *Message, content []byte) error {
+	if err := client.Mail(message.Sender()); err != nil {
+		return err
+	}
+	for _, recipient := range message.Recipients() {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(content); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Transport delivers messages
+type Transport interface {
+	// Send delivers a message, which has been validated; errors wrapped with jobs.Permanent are not retried
+	Send(ctx context.Context, message *Message) error
+}
+
+// OutboxTransport writes each message to a .eml file in a directory, for development and tests
+type OutboxTransport struct {
+	Path string
+}
+
+// LogTransport logs each message rather than sending it. Bodies may hold secrets such as reset links, so
+// only the sender, recipients and subject are logged unless debug is enabled.
+type LogTransport struct{}
+
+var activeTransport Transport
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// NewTransport creates the transport described by the configuration
+func NewTransport(mailConfig config.MailConfig) (Transport, error) {
+	switch mailConfig.Transport {
+	case "", "log":
+		return LogTransport{}, nil
+	case "outbox":
+		return NewOutboxTransport(mailConfig.OutboxPath)
+	case "smtp":
+		return NewSMTPTransport(mailConfig.SMTP)
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", mailConfig.Transport)
+	}
+}
+
+// GetTransport returns the transport messages are sent with
+func GetTransport() Transport {
+	return activeTransport
+}
+
+// SetTransport replaces the transport messages are sent with, eg. with one provided by a plugin. It must be
+// called before the server starts.
+func SetTransport(transport Transport) {
+	activeTransport = transport
+}
+
+// NewOutboxTransport creates a transport writing to the directory at path, creating it if needed
+func NewOutboxTransport(path string) (*OutboxTransport, error) {
+	if path == "" {
+		return nil, errors.New("a directory is required for the outbox")
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the outbox %s: %w", path, err)
+	}
+	return &OutboxTransport{Path: path}, nil
+}
+
+func (t *OutboxTransport) Send(_ context.Context, message *Message) error {
+	content, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(t.Path, name), content, 0o644)
+}
+
+func (LogTransport) Send(_ context.Context, message *Message) error {
+	if !config.ActiveConfig.Application.Debug {
+		log.Info("Mail", "Message from %s to %s: %s", message.From, strings.Join(message.To, ", "), message.Subject)
+		return nil
+	}
+	body := message.Text
+	if body == "" {
+		body = message.Html
+	}
+	log.Info("Mail", "Message from %s to %s: %s\n%s", message.From, strings.Join(message.To, ", "), message.Subject, body)
+	return nil
+}
@@ -0,0 +1,173 @@
+package media
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//go:embed library.gohtml
+var libraryHtml []byte
+
+//go:embed editor.gohtml
+var editorHtml []byte
+
+func registerAdminPages() {
+	extend.AddSideMenuItem("Media", "media", 20, "", "media:view")
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "media:view",
+		Route:      "media",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Media")
+
+			user := flow.Get("user").(*users.User)
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				if !user.HasPermission("media:add") {
+					result["status"] = "error"
+					result["message"] = "You do not have permission to upload media."
+				} else {
+					uploadFiles(flow, user, result)
+				}
+			}
+
+			query := flow.Request.URL.Query()
+			offset := utils.Stoid(query.Get("offset"), 0)
+			count := utils.Stoid(query.Get("count"), 20)
+			filter := Filter{Search: query.Get("q"), Type: query.Get("type")}
+
+			items, _ := GetFiltered(filter, count, offset)
+			total, _ := CountFiltered(filter)
+
+			content, err := server.RenderTemplate(libraryHtml, utils.Object{
+				"items":     items,
+				"offset":    offset,
+				"count":     count,
+				"itemCount": total,
+				"filter":    filter,
+				"types":     Types,
+				"canUpload": user.HasPermission("media:add"),
+				"maxSize":   FormatSize(config.ActiveConfig.Application.Media.MaxUploadSize),
+				"result":    result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "media:view",
+		Route:      "media/{id}",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Edit Media")
+
+			user := flow.Get("user").(*users.User)
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			media, err := GetById(uint(utils.Stoid(flow.GetKvp("admin_meta", "id"), 0)))
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			if flow.Request.Method == "POST" {
+				action := flow.PostFormValue("action")
+
+				if action == "delete" {
+					if !user.HasPermission("media:delete") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to delete media."
+						goto render
+					}
+					if err := Delete(flow.Request.Context(), media); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to delete media: " + err.Error()
+						goto render
+					}
+					flow.SetHeader("Location", "/admin/media")
+					flow.WriteHeaders(http.StatusFound)
+					return []byte{}, nil
+				}
+				if action == "save" {
+					if !user.HasPermission("media:edit") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to edit media."
+						goto render
+					}
+
+					media.Name = flow.PostFormValue("name")
+					media.AltText = flow.PostFormValue("alt_text")
+					media.Caption = flow.PostFormValue("caption")
+					if err := Update(media); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to update media: " + err.Error()
+						goto render
+					}
+					result["status"] = "success"
+					result["message"] = "Media updated."
+				}
+			}
+
+		render:
+			content, err := server.RenderTemplate(editorHtml, utils.Object{
+				"media":  media,
+				"result": result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+}
+
+// uploadFiles stores the files posted to the library page, reporting the outcome in result
+func uploadFiles(flow *httpflow.HttpFlow, user *users.User, result utils.Object) {
+	if err := parseUploads(flow); err != nil {
+		result["status"] = "error"
+		result["message"] = "Failed to upload: " + err.Error()
+		return
+	}
+
+	files := flow.FormFiles("file")
+	if len(files) == 0 {
+		result["status"] = "error"
+		result["message"] = "Choose at least one file to upload."
+		return
+	}
+
+	var failures []string
+	for _, file := range files {
+		if _, err := UploadFile(flow.Request.Context(), file, user); err != nil {
+			failures = append(failures, file.Filename+": "+err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		result["status"] = "error"
+		result["message"] = fmt.Sprintf("Uploaded %d of %d files. %s", len(files)-len(failures), len(files), strings.Join(failures, "; "))
+		return
+	}
+	result["status"] = "success"
+	result["message"] = fmt.Sprintf("Uploaded %d files.", len(files))
+}
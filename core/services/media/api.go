@@ -0,0 +1,241 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// mediaRequest is the body accepted when updating a media file
+type mediaRequest struct {
+	Name    *string `json:"name"`
+	AltText *string `json:"alt_text"`
+	Caption *string `json:"caption"`
+}
+
+// errInvalidUpload is returned when a request is not a valid multipart form
+var errInvalidUpload = errors.New("files must be sent as multipart/form-data")
+
+//////////////////////////////////
+// Resource Definitions         //
+//////////////////////////////////
+
+var getMediaListResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/api/v1/media$"),
+	Description:   "Lists media, newest first; filter with the q and type (image, video, audio or document) query parameters",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "media:view") {
+			return
+		}
+
+		query := flow.Request.URL.Query()
+		limit, err := strconv.Atoi(utils.OrDefault(query.Get("limit"), "20"))
+		if err != nil {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "limit must be an integer")
+			return
+		}
+		offset, err := strconv.Atoi(utils.OrDefault(query.Get("offset"), "0"))
+		if err != nil {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "offset must be an integer")
+			return
+		}
+
+		filter := Filter{Search: query.Get("q"), Type: query.Get("type")}
+		if filter.Type != "" && !slices.Contains(Types, filter.Type) {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "type must be one of image, video, audio or document")
+			return
+		}
+
+		items, err := GetFiltered(filter, limit, offset)
+		if err != nil {
+			flow.WriteErrorJson(http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		total, err := CountFiltered(filter)
+		if err != nil {
+			flow.WriteErrorJson(http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		httpflow.WriteJsonList(flow, limit, offset, int(total), "media", &items)
+	},
+}
+
+var uploadMediaResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "^/api/v1/media$"),
+	Description:   "Uploads a media file, sent as the file field of a multipart form",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "media:add") {
+			return
+		}
+
+		if err := parseUploads(flow); err != nil {
+			writeUploadError(flow, err)
+			return
+		}
+		files := flow.FormFiles("file")
+		if len(files) == 0 {
+			flow.WriteErrorJson(http.StatusBadRequest, "a file is required")
+			return
+		}
+
+		user, _ := flow.Get("user").(*users.User)
+		uploaded, err := UploadFile(flow.Request.Context(), files[0], user)
+		if err != nil {
+			writeUploadError(flow, err)
+			return
+		}
+
+		flow.SetHeader("Content-Type", "application/json")
+		flow.WriteHeaders(http.StatusCreated)
+		flow.WriteJson(uploaded)
+	},
+}
+
+var getMediaResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "/api/v1/media/{id}"),
+	Description:   "Returns a single media file",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "media:view") {
+			return
+		}
+
+		media := mediaFromPath(flow)
+		if media == nil {
+			return
+		}
+
+		flow.WriteJson(media)
+	},
+}
+
+var updateMediaResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodPost, "/api/v1/media/{id}"),
+	Description:   "Updates the name, alt text or caption of a media file; omitted fields are left unchanged",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "media:edit") {
+			return
+		}
+
+		media := mediaFromPath(flow)
+		if media == nil {
+			return
+		}
+
+		var body mediaRequest
+		if err := utils.DecodeJSONBody(flow.Request, &body); err != nil {
+			flow.WriteErrorJson(http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		if body.Name != nil {
+			media.Name = *body.Name
+		}
+		if body.AltText != nil {
+			media.AltText = *body.AltText
+		}
+		if body.Caption != nil {
+			media.Caption = *body.Caption
+		}
+
+		if err := Update(media); err != nil {
+			flow.WriteErrorJson(http.StatusUnprocessableEntity, "%s", err.Error())
+			return
+		}
+
+		flow.WriteJson(media)
+	},
+}
+
+var deleteMediaResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodDelete, "/api/v1/media/{id}"),
+	Description:   "Deletes a media file and its content",
+	Handler: func(flow *httpflow.HttpFlow) {
+		if !requirePermission(flow, "media:delete") {
+			return
+		}
+
+		media := mediaFromPath(flow)
+		if media == nil {
+			return
+		}
+
+		if err := Delete(flow.Request.Context(), media); err != nil {
+			flow.WriteErrorJson(http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		flow.WriteJson(utils.Object{"count": 1})
+	},
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// requirePermission writes a 401 or 403 and returns false unless the current user has the permission
+func requirePermission(flow *httpflow.HttpFlow, permission string) bool {
+	user, ok := flow.Get("user").(*users.User)
+	if !ok || user == nil {
+		httpflow.WriteUnauthorizedJson(flow)
+		return false
+	}
+	if !user.HasPermission(permission) {
+		flow.WriteErrorJson(http.StatusForbidden, "forbidden; requires the %s permission", permission)
+		return false
+	}
+	return true
+}
+
+// mediaFromPath returns the media named by the id path parameter, or writes a 404 and returns nil
+func mediaFromPath(flow *httpflow.HttpFlow) *Media {
+	id, err := strconv.Atoi(flow.Param("id"))
+	if err != nil || id <= 0 {
+		flow.WriteErrorJson(http.StatusNotFound, "media not found")
+		return nil
+	}
+
+	media, err := GetById(uint(id))
+	if err != nil {
+		flow.WriteErrorJson(http.StatusNotFound, "media not found")
+		return nil
+	}
+	return media
+}
+
+// parseUploads parses a multipart upload, bounding its size by the maximum upload size
+func parseUploads(flow *httpflow.HttpFlow) error {
+	err := flow.ParseMultipartForm(config.ActiveConfig.Application.Media.MaxRequestSize())
+	var maxBytesError *http.MaxBytesError
+	if err != nil && !errors.As(err, &maxBytesError) {
+		return fmt.Errorf("%w: %s", errInvalidUpload, err.Error())
+	}
+	return err
+}
+
+// writeUploadError writes the status matching an upload error
+func writeUploadError(flow *httpflow.HttpFlow, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrTooLarge), errors.As(err, &maxBytesError):
+		flow.WriteErrorJson(http.StatusRequestEntityTooLarge, "%s", ErrTooLarge.Error())
+	case errors.Is(err, ErrTypeNotAllowed):
+		flow.WriteErrorJson(http.StatusUnsupportedMediaType, "%s", err.Error())
+	case errors.Is(err, errInvalidUpload):
+		flow.WriteErrorJson(http.StatusBadRequest, "%s", err.Error())
+	default:
+		flow.WriteErrorJson(http.StatusInternalServerError, "%s", err.Error())
+	}
+}
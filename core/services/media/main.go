@@ -0,0 +1,241 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/utils"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Resource Definitions         //
+//////////////////////////////////
+
+var serveMediaResource = extend.ResourceDef{
+	HttpValidator: extend.NewHttpValidator(http.MethodGet, "^/media/{id}/{name}$"),
+	Description:   "Downloads the content of a media file",
+	Handler: func(flow *httpflow.HttpFlow) {
+		w := flow.Writer
+
+		id, err := strconv.Atoi(flow.Param("id"))
+		if err != nil || id <= 0 {
+			http.NotFound(w, flow.Request)
+			return
+		}
+		media, err := GetById(uint(id))
+		if err != nil {
+			http.NotFound(w, flow.Request)
+			return
+		}
+
+		// A name such as w_800.webp requests a resized copy of an image
+		name := flow.Param("name")
+		if transform, err := ParseTransform(strings.TrimSuffix(name, path.Ext(name))); err == nil {
+			serveDerivative(flow, media, transform, path.Ext(name))
+			return
+		}
+
+		// Storage with its own public address serves the file itself
+		if url := URL(media); !strings.HasPrefix(url, "/media/") {
+			flow.Redirect(url, http.StatusFound)
+			return
+		}
+
+		// The content stored under a key never changes, so the key identifies the version
+		etag := `"` + strings.TrimSuffix(path.Base(media.Key), path.Ext(media.Key)) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Type", media.ContentType)
+
+		disposition := "attachment"
+		if media.Kind() != "document" || media.ContentType == "application/pdf" {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, FileName(media.Name)))
+
+		if flow.Request.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		content, err := GetStorage().Get(flow.Request.Context(), media.Key)
+		if errors.Is(err, ErrNotStored) {
+			log.Warn("Media", "The content of media %d is missing from storage", media.ID)
+			http.NotFound(w, flow.Request)
+			return
+		}
+		if err != nil {
+			log.Error("Media", "Failed to read %s from storage: %s", media.Key, err.Error())
+			http.Error(w, "Failed to read the file.", http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+
+		// Seekable content, such as local files, supports range requests for seeking through audio and video
+		if seeker, ok := content.(io.ReadSeeker); ok {
+			http.ServeContent(w, flow.Request, "", media.UpdatedAt, seeker)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(media.Size, 10))
+		if _, err := io.Copy(w, content); err != nil {
+			log.Warn("Media", "Failed to send %s: %s", media.Key, err.Error())
+		}
+	},
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// serveDerivative sends a resized copy of an image, generating it on first request
+func serveDerivative(flow *httpflow.HttpFlow, media *Media, transform Transform, extension string) {
+	w := flow.Writer
+
+	file, err := Derive(flow.Request.Context(), media, transform, extension)
+	if errors.Is(err, ErrTransformNotAllowed) {
+		http.Error(w, "The requested image size is not allowed.", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrNotTransformable) || errors.Is(err, ErrNotStored) {
+		http.NotFound(w, flow.Request)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to resize the image.", http.StatusInternalServerError)
+		return
+	}
+
+	content, err := os.Open(file)
+	if err != nil {
+		log.Error("Media", "Failed to read %s: %s", file, err.Error())
+		http.Error(w, "Failed to read the file.", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	name := transform.String() + extension
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(path.Base(media.Key), path.Ext(media.Key))+"-"+name+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", derivativeTypes[extension])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	http.ServeContent(w, flow.Request, "", media.UpdatedAt, content)
+}
+
+//////////////////////////////////
+// Service Definition           //
+//////////////////////////////////
+
+var Service = extend.ServiceDef{
+	Name:         "media",
+	FriendlyName: "Media Library",
+	DependsOn:    []string{"administration", "authentication", "jobs"},
+	Resources: []extend.ResourceDef{
+		serveMediaResource,
+		getMediaListResource,
+		uploadMediaResource,
+		getMediaResource,
+		updateMediaResource,
+		deleteMediaResource,
+	},
+	Permissions: []extend.PermissionDef{
+		{Name: "media:view", Description: "View the media library", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "media:add", Description: "Upload media", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "media:edit", Description: "Edit the names, alt text and captions of media", DefaultGroups: []string{"administrator", "editor"}},
+		{Name: "media:delete", Description: "Delete media", DefaultGroups: []string{"administrator", "editor"}},
+	},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_media",
+			Up:      database.AutoMigrateModels(&mediaV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&mediaV1{})
+			},
+		},
+	},
+	OnInit: func() error {
+		// A plugin may have provided its own storage
+		if GetStorage() == nil {
+			storage, err := NewStorage(config.ActiveConfig.Application.Media)
+			if err != nil {
+				log.Error("Media", "Failed to create media storage: %s", err.Error())
+				return err
+			}
+			SetStorage(storage)
+		}
+
+		for _, preset := range config.ActiveConfig.Application.Media.ImagePresets {
+			if _, err := ParseTransform(preset); err != nil {
+				log.Error("Media", "Invalid image preset: %s", err.Error())
+				return err
+			}
+		}
+
+		registerAdminPages()
+
+		extend.RegisterFunctions(template.FuncMap{
+			// media returns the media file with the given id, or an empty one if there is none
+			"media": func(id any) Media {
+				media, err := GetById(uint(utils.Stoid(fmt.Sprint(id), 0)))
+				if err != nil {
+					return Media{}
+				}
+				return *media
+			},
+			// mediaUrl returns the URL of the media file with the given id, or an empty string if there is none.
+			// An image preset and format may follow, eg. mediaUrl 5 "w_800" "webp"; JPEG photos are not
+			// offered as WebP, which is lossless.
+			"mediaUrl": func(id any, options ...string) string {
+				media, err := GetById(uint(utils.Stoid(fmt.Sprint(id), 0)))
+				if err != nil {
+					return ""
+				}
+				if len(options) == 0 {
+					return media.Url
+				}
+				transform, err := ParseTransform(options[0])
+				if err != nil || !IsAllowedTransform(transform) || !CanTransform(media) {
+					return ""
+				}
+				if len(options) > 1 {
+					extension := "." + strings.TrimPrefix(options[1], ".")
+					if !canDerive(media, extension) {
+						return ""
+					}
+					return TransformURL(media, transform, extension)
+				}
+				return TransformURL(media, transform, "")
+			},
+			// imgSrcset returns a srcset of the widths the image with the given id is available in, optionally
+			// in another format, eg. <img src="{{ mediaUrl 5 }}" srcset="{{ imgSrcset 5 "webp" }}">
+			"imgSrcset": func(id any, format ...string) string {
+				media, err := GetById(uint(utils.Stoid(fmt.Sprint(id), 0)))
+				if err != nil {
+					return ""
+				}
+				if len(format) > 0 {
+					return Srcset(media, "."+strings.TrimPrefix(format[0], "."))
+				}
+				return Srcset(media, "")
+			},
+		})
+
+		return nil
+	},
+}
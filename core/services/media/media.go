@@ -0,0 +1,344 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/services/jobs"
+	"github.com/gojicms/goji/core/utils/log"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Media is an uploaded file; its content is kept in storage under Key
+type Media struct {
+	gorm.Model
+	Name         string      `json:"name" gorm:"size:255"`
+	Key          string      `json:"-" gorm:"size:255;uniqueIndex"`
+	ContentType  string      `json:"content_type" gorm:"size:127;index"`
+	Size         int64       `json:"size"`
+	Width        int         `json:"width"`
+	Height       int         `json:"height"`
+	AltText      string      `json:"alt_text" gorm:"size:1000"`
+	Caption      string      `json:"caption" gorm:"type:text"`
+	UploadedBy   *users.User `json:"-" gorm:"foreignKey:UploadedById;constraint:OnUpdate:NO ACTION,OnDelete:SET NULL;"`
+	UploadedById *uint       `json:"-" gorm:"index"`
+	// Url is where the file can be downloaded from; it is not stored
+	Url string `json:"url" gorm:"-"`
+}
+
+// Filter narrows the media returned by GetFiltered; empty fields are ignored
+type Filter struct {
+	// Search matches part of the name, alt text or caption
+	Search string
+	// Type is image, video, audio or document; documents are every other type
+	Type string
+}
+
+// Types lists the values accepted by Filter.Type
+var Types = []string{"image", "video", "audio", "document"}
+
+var (
+	// ErrTooLarge is returned when an upload exceeds the configured maximum size
+	ErrTooLarge = errors.New("the file is larger than the maximum upload size")
+	// ErrTypeNotAllowed is returned when the content of an upload is not of an allowed type
+	ErrTypeNotAllowed = errors.New("the file type is not allowed")
+)
+
+var unsafeFileName = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// IsImage returns true if the file is an image
+func (media Media) IsImage() bool {
+	return media.Kind() == "image"
+}
+
+// Kind returns the family of the file's content type: image, video, audio or document
+func (media Media) Kind() string {
+	family, _, _ := strings.Cut(media.ContentType, "/")
+	switch family {
+	case "image", "video", "audio":
+		return family
+	}
+	return "document"
+}
+
+// FormattedSize returns the size of the file in human-readable units, eg. 1.5 MB
+func (media Media) FormattedSize() string {
+	return FormatSize(media.Size)
+}
+
+// AfterFind sets the URL of media loaded from the database
+func (media *Media) AfterFind(_ *gorm.DB) error {
+	media.Url = URL(media)
+	return nil
+}
+
+// URL returns the address a media file can be downloaded from; this is the public URL of the storage if it
+// provides one, or otherwise /media/{id}/{name}
+func URL(media *Media) string {
+	if public, ok := GetStorage().(PublicStorage); ok {
+		if url := public.PublicURL(media.Key); url != "" {
+			return url
+		}
+	}
+	return fmt.Sprintf("/media/%d/%s", media.ID, FileName(media.Name))
+}
+
+// FileName reduces a file name to lowercase letters, digits, dots, underscores and hyphens
+func FileName(name string) string {
+	name = strings.Trim(unsafeFileName.ReplaceAllString(strings.ToLower(path.Base(name)), "-"), "-.")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// FormatSize returns a number of bytes in human-readable units, eg. 1.5 MB
+func FormatSize(bytes int64) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	size := float64(bytes)
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		size /= 1024
+		if size < 1024 || unit == "GB" {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+	}
+	return ""
+}
+
+// IsAllowedType returns true if the content type may be uploaded; a configured type such as image/* allows
+// every type in its family
+func IsAllowedType(contentType string) bool {
+	for _, allowed := range config.ActiveConfig.Application.Media.AllowedTypes {
+		if family, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(contentType, family+"/") {
+				return true
+			}
+		} else if strings.EqualFold(allowed, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Upload stores size bytes of content as a new media file named name. The content type is detected from
+// the content itself, and the dimensions of images are recorded.
+func Upload(ctx context.Context, name string, content io.ReadSeeker, size int64, uploader *users.User) (*Media, error) {
+	if size > config.ActiveConfig.Application.Media.MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	sniffed, err := bufio.NewReader(content).Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniffed))
+	if !IsAllowedType(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrTypeNotAllowed, contentType)
+	}
+
+	media := Media{
+		Name:        path.Base(name),
+		ContentType: contentType,
+		Size:        size,
+	}
+	if uploader != nil {
+		media.UploadedById = &uploader.ID
+	}
+
+	if media.IsImage() {
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if imageConfig, _, err := image.DecodeConfig(content); err == nil {
+			media.Width, media.Height = imageConfig.Width, imageConfig.Height
+		}
+	}
+
+	media.Key, err = newKey(media.Name, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := GetStorage().Put(ctx, media.Key, io.LimitReader(content, size), size, contentType); err != nil {
+		log.Error("Media", "Failed to store %s: %s", media.Key, err.Error())
+		return nil, err
+	}
+
+	db := database.GetDB()
+	if err := db.Create(&media).Error; err != nil {
+		log.Error("Media", "Failed to create media: %s", err.Error())
+		if err := GetStorage().Delete(ctx, media.Key); err != nil {
+			log.Error("Media", "Failed to remove %s from storage: %s", media.Key, err.Error())
+		}
+		return nil, err
+	}
+
+	media.Url = URL(&media)
+	if CanTransform(&media) {
+		key := strconv.FormatUint(uint64(media.ID), 10)
+		if _, err := deriveTask.EnqueueWith(media.ID, jobs.EnqueueOptions{Key: key}); err != nil {
+			log.Warn("Media", "Failed to queue the derivatives of %s: %s", media.Key, err.Error())
+		}
+	}
+	return &media, nil
+}
+
+// UploadFile stores a file uploaded in a multipart form as a new media file
+func UploadFile(ctx context.Context, file *multipart.FileHeader, uploader *users.User) (*Media, error) {
+	if file.Size > config.ActiveConfig.Application.Media.MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return Upload(ctx, file.Filename, content, file.Size, uploader)
+}
+
+// GetFiltered gets the media matching the filter, newest first, limited by limit and starting at offset
+func GetFiltered(filter Filter, limit int, offset int) ([]Media, error) {
+	db := database.GetDB()
+	var media []Media
+
+	res := db.Preload("UploadedBy").Scopes(filter.scope).Limit(limit).Offset(offset).Order("created_at DESC").Find(&media)
+	if res.Error != nil {
+		log.Error("Media", "Failed to get media: %s", res.Error.Error())
+		return nil, res.Error
+	}
+
+	return media, nil
+}
+
+// CountFiltered counts the media matching the filter
+func CountFiltered(filter Filter) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&Media{}).Scopes(filter.scope).Count(&count)
+	if res.Error != nil {
+		log.Error("Media", "Failed to count media: %s", res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// GetById gets a media file by its id
+func GetById(id uint) (*Media, error) {
+	db := database.GetDB()
+	var media Media
+
+	res := db.Preload("UploadedBy").Limit(1).Find(&media, id)
+	if res.Error != nil {
+		log.Error("Media", "Failed to get media %d: %s", id, res.Error.Error())
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &media, nil
+}
+
+// Update saves the name, alt text and caption of a media file; its content cannot be changed
+func Update(media *Media) error {
+	media.Name = strings.TrimSpace(media.Name)
+	if media.Name == "" {
+		return errors.New("a name is required")
+	}
+
+	db := database.GetDB()
+	res := db.Model(media).Select("Name", "AltText", "Caption").Updates(media)
+	if res.Error != nil {
+		log.Error("Media", "Failed to update media %d: %s", media.ID, res.Error.Error())
+		return res.Error
+	}
+
+	media.Url = URL(media)
+	return nil
+}
+
+// Delete removes a media file, its content from storage and its cached derivatives
+func Delete(ctx context.Context, media *Media) error {
+	db := database.GetDB()
+	if err := db.Unscoped().Delete(media).Error; err != nil {
+		log.Error("Media", "Failed to delete media %d: %s", media.ID, err.Error())
+		return err
+	}
+
+	if err := GetStorage().Delete(ctx, media.Key); err != nil {
+		// The record is gone either way; the orphaned file is only wasted space
+		log.Error("Media", "Failed to remove %s from storage: %s", media.Key, err.Error())
+	}
+	removeDerivatives(media)
+	return nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// scope applies the filter to a query
+func (filter Filter) scope(db *gorm.DB) *gorm.DB {
+	if filter.Search != "" {
+		like := "%" + filter.Search + "%"
+		db = db.Where("name LIKE ? OR alt_text LIKE ? OR caption LIKE ?", like, like, like)
+	}
+	switch filter.Type {
+	case "image", "video", "audio":
+		db = db.Where("content_type LIKE ?", filter.Type+"/%")
+	case "document":
+		db = db.Where("content_type NOT LIKE ? AND content_type NOT LIKE ? AND content_type NOT LIKE ?",
+			"image/%", "video/%", "audio/%")
+	}
+	return db
+}
+
+// newKey generates a unique storage key for a file, grouped by the month it was uploaded in
+func newKey(name string, contentType string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	// Keep the extension of the name if it suits the detected type
+	extension := strings.ToLower(path.Ext(FileName(name)))
+	if extensions, _ := mime.ExtensionsByType(contentType); len(extensions) > 0 && !slices.Contains(extensions, extension) {
+		extension = extensions[0]
+	}
+
+	return time.Now().UTC().Format("2006/01/") + hex.EncodeToString(random) + extension, nil
+}
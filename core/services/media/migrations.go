@@ -0,0 +1,29 @@
+package media
+
+import (
+	"github.com/gojicms/goji/core/services/auth/users"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// mediaV1 is the media table as created by migration 1; it must not change along with Media
+type mediaV1 struct {
+	gorm.Model
+	Name         string `gorm:"size:255"`
+	Key          string `gorm:"size:255;uniqueIndex"`
+	ContentType  string `gorm:"size:127;index"`
+	Size         int64
+	Width        int
+	Height       int
+	AltText      string      `gorm:"size:1000"`
+	Caption      string      `gorm:"type:text"`
+	UploadedBy   *users.User `gorm:"foreignKey:UploadedById;constraint:OnUpdate:NO ACTION,OnDelete:SET NULL;"`
+	UploadedById *uint       `gorm:"index"`
+}
+
+func (mediaV1) TableName() string {
+	return "media"
+}
@@ -0,0 +1,288 @@
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+)
+
+// S3Storage keeps files in a bucket of Amazon S3 or any S3 compatible service, such as MinIO, Cloudflare R2
+// or DigitalOcean Spaces. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	config   config.S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// listBucketResult is the part of a ListObjectsV2 response the driver uses
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// NewS3Storage creates storage in the bucket described by the configuration
+func NewS3Storage(s3Config config.S3Config) (*S3Storage, error) {
+	if s3Config.Endpoint == "" || s3Config.Bucket == "" {
+		return nil, errors.New("an endpoint and bucket are required for S3 storage")
+	}
+	endpoint, err := url.Parse(s3Config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", s3Config.Endpoint)
+	}
+	if s3Config.Region == "" {
+		s3Config.Region = "us-east-1"
+	}
+
+	return &S3Storage{
+		config:   s3Config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req)
+	if errors.Is(err, ErrNotStored) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		req, err := s.request(ctx, http.MethodGet, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req.URL.RawQuery = canonicalQuery(query)
+
+		res, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 list response: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) PublicURL(key string) string {
+	if s.config.PublicUrl == "" {
+		return ""
+	}
+	return strings.TrimSuffix(s.config.PublicUrl, "/") + "/" + escapePath(key)
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// request builds a request for the object at key, or the bucket itself if key is empty, using path style
+// (endpoint/bucket/key) or virtual host style (bucket.endpoint/key) addressing
+func (s *S3Storage) request(ctx context.Context, method string, key string, body io.Reader) (*http.Request, error) {
+	target := *s.endpoint
+	if s.config.PathStyle {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + s.config.Bucket
+		if key != "" {
+			target.Path += "/" + key
+		}
+	} else {
+		target.Host = s.config.Bucket + "." + target.Host
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + key
+	}
+	target.RawPath = escapePath(target.Path)
+
+	return http.NewRequestWithContext(ctx, method, target.String(), body)
+}
+
+// do signs and sends a request, turning error responses into errors
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, unsignedPayload)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotStored
+	}
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s failed with %s: %s", req.Method, req.URL.Path, res.Status, message)
+}
+
+// sign adds an AWS Signature Version 4 authorization header to the request
+func (s *S3Storage) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host, content type and range headers along with every x-amz header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSha256(key, s.config.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery sorts and encodes query parameters as required by Signature Version 4
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapePath encodes each segment of a path, leaving the slashes between them
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape encodes everything but unreserved characters, as required by Signature Version 4
+func escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gojicms/goji/core/config"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Storage keeps the content of uploaded files. Keys are relative paths using forward slashes, such as
+// 2025/01/4f2a9c.png.
+type Storage interface {
+	// Put stores size bytes of content under key, replacing anything already stored there
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+	// Get opens the content stored under key, returning ErrNotStored if there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the content stored under key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// PublicStorage is implemented by storage that can link to its content directly, such as a bucket behind a
+// CDN; files are otherwise served through Goji
+type PublicStorage interface {
+	// PublicURL returns the URL of the content stored under key, or an empty string if it has none
+	PublicURL(key string) string
+}
+
+// ListableStorage is implemented by storage that can enumerate its keys, eg. to find files that no media item
+// refers to
+type ListableStorage interface {
+	// List returns the keys stored under prefix, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ErrNotStored is returned when a key has no content in storage
+var ErrNotStored = errors.New("the file is not in storage")
+
+var activeStorage Storage
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// NewStorage creates the storage driver described by the configuration
+func NewStorage(mediaConfig config.MediaConfig) (Storage, error) {
+	switch mediaConfig.Storage {
+	case "", "local":
+		return NewLocalStorage(mediaConfig.LocalPath)
+	case "s3":
+		return NewS3Storage(mediaConfig.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", mediaConfig.Storage)
+	}
+}
+
+// GetStorage returns the storage uploads are kept in
+func GetStorage() Storage {
+	return activeStorage
+}
+
+// SetStorage replaces the storage uploads are kept in, eg. with a driver provided by a plugin. It must be
+// called before the server starts.
+func SetStorage(storage Storage) {
+	activeStorage = storage
+}
@@ -0,0 +1,411 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/services/jobs"
+	"github.com/gojicms/goji/core/utils/log"
+	"github.com/gojicms/goji/core/utils/webp"
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Transform describes a resized copy of an image, written in URLs as eg. w_800,h_600,fit_cover
+type Transform struct {
+	// Width and Height bound the size of the copy; either may be 0 to keep the aspect ratio of the image
+	Width  int
+	Height int
+	// Fit decides how an image meets both a width and a height: contain scales it to fit within them, cover
+	// crops it to fill them and fill stretches it
+	Fit string
+	// Quality is the quality of JPEG copies, from 1 to 100; 0 uses the default. WebP copies are lossless and
+	// ignore it.
+	Quality int
+}
+
+var (
+	// ErrNotTransformable is returned when a file is not an image that can be resized, or the requested
+	// format cannot be produced
+	ErrNotTransformable = errors.New("the file cannot be resized")
+	// ErrTransformNotAllowed is returned when a transform is not one of the configured presets
+	ErrTransformNotAllowed = errors.New("the image size is not allowed")
+)
+
+// derivativeTypes are the content types of the formats derivatives can be produced in, by extension
+var derivativeTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+const (
+	// maxSourcePixels is the largest image that will be decoded for resizing
+	maxSourcePixels = 50_000_000
+	// maxDimension is the largest width or height of a derivative
+	maxDimension       = 16384
+	defaultJpegQuality = 85
+)
+
+var (
+	generating      = map[string]chan struct{}{}
+	generatingMutex sync.Mutex
+	// generateSlots limits how many images are resized at once
+	generateSlots = make(chan struct{}, runtime.NumCPU())
+)
+
+// deriveTask generates the derivatives listed in the srcset of an uploaded image, so that its first
+// visitors need not wait for them
+var deriveTask = jobs.NewTask("media.derive", jobs.TaskOptions{MaxAttempts: 3}, func(ctx context.Context, id uint) error {
+	media, err := GetById(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The image was deleted in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	extension := DerivativeExtension(media.ContentType)
+	for _, transform := range srcsetWidths(media) {
+		if _, err := Derive(ctx, media, transform, extension); err != nil {
+			return err
+		}
+	}
+	return nil
+})
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// ParseTransform parses a transform such as w_800,h_600,fit_cover
+func ParseTransform(spec string) (Transform, error) {
+	transform := Transform{Fit: "contain"}
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(part, "_")
+		if !ok || seen[name] {
+			return Transform{}, fmt.Errorf("%q is not a valid transform", spec)
+		}
+		seen[name] = true
+
+		switch name {
+		case "w", "h", "q":
+			number, err := strconv.Atoi(value)
+			if err != nil || number <= 0 || number > maxDimension || (name == "q" && number > 100) {
+				return Transform{}, fmt.Errorf("%q is not a valid value for %s", value, name)
+			}
+			switch name {
+			case "w":
+				transform.Width = number
+			case "h":
+				transform.Height = number
+			default:
+				transform.Quality = number
+			}
+		case "fit":
+			if value != "contain" && value != "cover" && value != "fill" {
+				return Transform{}, fmt.Errorf("%q is not a valid fit; expected contain, cover or fill", value)
+			}
+			transform.Fit = value
+		default:
+			return Transform{}, fmt.Errorf("%q is not a valid transform", spec)
+		}
+	}
+
+	if transform.Width == 0 && transform.Height == 0 {
+		return Transform{}, fmt.Errorf("%q needs a width or a height", spec)
+	}
+	// The fit only matters when both dimensions are given
+	if transform.Width == 0 || transform.Height == 0 {
+		transform.Fit = "contain"
+	}
+	return transform, nil
+}
+
+// String returns the canonical form of the transform, as used in URLs
+func (transform Transform) String() string {
+	var parts []string
+	if transform.Width > 0 {
+		parts = append(parts, "w_"+strconv.Itoa(transform.Width))
+	}
+	if transform.Height > 0 {
+		parts = append(parts, "h_"+strconv.Itoa(transform.Height))
+	}
+	if transform.Fit != "" && transform.Fit != "contain" {
+		parts = append(parts, "fit_"+transform.Fit)
+	}
+	if transform.Quality > 0 {
+		parts = append(parts, "q_"+strconv.Itoa(transform.Quality))
+	}
+	return strings.Join(parts, ",")
+}
+
+// IsAllowedTransform returns true if the transform is one of the configured presets
+func IsAllowedTransform(transform Transform) bool {
+	for _, preset := range config.ActiveConfig.Application.Media.ImagePresets {
+		if allowed, err := ParseTransform(preset); err == nil && allowed == transform {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTransform returns true if derivatives can be generated from a media file
+func CanTransform(media *Media) bool {
+	return media.IsImage() && media.Width > 0 && media.Height > 0 && media.Width*media.Height <= maxSourcePixels
+}
+
+// TransformURL returns the address of a derivative of a media file; extension chooses its format and
+// defaults to the format of the original
+func TransformURL(media *Media, transform Transform, extension string) string {
+	if extension == "" {
+		extension = DerivativeExtension(media.ContentType)
+	}
+	return fmt.Sprintf("/media/%d/%s%s", media.ID, transform, extension)
+}
+
+// DerivativeExtension returns the extension of the format derivatives of a content type are produced in by
+// default; types that cannot be produced are converted to JPEG
+func DerivativeExtension(contentType string) string {
+	for extension, derivativeType := range derivativeTypes {
+		if derivativeType == contentType {
+			return extension
+		}
+	}
+	return ".jpg"
+}
+
+// Srcset returns a srcset listing the width-only presets narrower than the image, in the format of
+// extension, followed by the image itself if it is already in that format. It is empty if the image cannot
+// be produced in that format, such as a JPEG photo as WebP.
+func Srcset(media *Media, extension string) string {
+	if !CanTransform(media) {
+		return ""
+	}
+	if extension == "" {
+		extension = DerivativeExtension(media.ContentType)
+	}
+	if !canDerive(media, extension) {
+		return ""
+	}
+
+	var candidates []string
+	for _, transform := range srcsetWidths(media) {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", TransformURL(media, transform, extension), transform.Width))
+	}
+	if derivativeTypes[extension] == media.ContentType {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", URL(media), media.Width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// Derive returns the path of a cached derivative of a media file, generating it if needed; extension
+// chooses its format
+func Derive(ctx context.Context, media *Media, transform Transform, extension string) (string, error) {
+	if !canDerive(media, extension) || !CanTransform(media) {
+		return "", ErrNotTransformable
+	}
+	if !IsAllowedTransform(transform) {
+		return "", ErrTransformNotAllowed
+	}
+
+	file := filepath.Join(derivativeDir(media), transform.String()+extension)
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+
+	// Requests for a derivative that is already being generated wait for it rather than repeating the work
+	generatingMutex.Lock()
+	if done, ok := generating[file]; ok {
+		generatingMutex.Unlock()
+		<-done
+		if _, err := os.Stat(file); err != nil {
+			return "", fmt.Errorf("failed to generate %s", file)
+		}
+		return file, nil
+	}
+	done := make(chan struct{})
+	generating[file] = done
+	generatingMutex.Unlock()
+
+	defer func() {
+		generatingMutex.Lock()
+		delete(generating, file)
+		generatingMutex.Unlock()
+		close(done)
+	}()
+
+	generateSlots <- struct{}{}
+	defer func() { <-generateSlots }()
+
+	if err := generate(ctx, media, transform, extension, file); err != nil {
+		log.Error("Media", "Failed to generate %s: %s", file, err.Error())
+		return "", err
+	}
+	return file, nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// canDerive returns true if derivatives of a media file can be produced in the format of extension. WebP
+// copies are lossless, which suits graphics but makes photos several times larger than a JPEG, so they are
+// not produced from JPEG originals.
+func canDerive(media *Media, extension string) bool {
+	if _, ok := derivativeTypes[extension]; !ok {
+		return false
+	}
+	return extension != ".webp" || media.ContentType != "image/jpeg"
+}
+
+// srcsetWidths returns the width-only presets narrower than an image, narrowest first
+func srcsetWidths(media *Media) []Transform {
+	var widths []Transform
+	for _, preset := range config.ActiveConfig.Application.Media.ImagePresets {
+		transform, err := ParseTransform(preset)
+		if err == nil && transform.Height == 0 && transform.Width < media.Width {
+			widths = append(widths, transform)
+		}
+	}
+	slices.SortStableFunc(widths, func(a, b Transform) int { return a.Width - b.Width })
+	return widths
+}
+
+// derivativeDir returns the directory the derivatives of a media file are cached in
+func derivativeDir(media *Media) string {
+	key := strings.TrimSuffix(media.Key, path.Ext(media.Key))
+	return filepath.Join(config.ActiveConfig.Application.Media.CachePath, filepath.FromSlash(key))
+}
+
+// removeDerivatives removes the cached derivatives of a media file
+func removeDerivatives(media *Media) {
+	if err := os.RemoveAll(derivativeDir(media)); err != nil {
+		log.Error("Media", "Failed to remove the derivatives of media %d: %s", media.ID, err.Error())
+	}
+}
+
+// generate resizes the image of a media file and writes it to file
+func generate(ctx context.Context, media *Media, transform Transform, extension string, file string) error {
+	content, err := GetStorage().Get(ctx, media.Key)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	source, _, err := image.Decode(content)
+	if err != nil {
+		return err
+	}
+
+	size, crop := transform.layout(source.Bounds())
+	canvas := image.NewRGBA(image.Rectangle{Max: size})
+	op := draw.Src
+	if extension == ".jpg" {
+		// JPEG has no transparency, so transparent areas become white rather than black
+		draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	draw.CatmullRom.Scale(canvas, canvas.Bounds(), source, crop, op, nil)
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so a request never sees a partial image
+	temp, err := os.CreateTemp(filepath.Dir(file), ".derivative-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if err := encode(temp, canvas, extension, transform.Quality); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+// layout returns the size of the derivative of an image with the given bounds, and the area of the image
+// it shows. Images are never enlarged.
+func (transform Transform) layout(bounds image.Rectangle) (image.Point, image.Rectangle) {
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+	width, height := transform.Width, transform.Height
+	crop := bounds
+
+	switch {
+	case height == 0:
+		height = sourceHeight * width / sourceWidth
+	case width == 0:
+		width = sourceWidth * height / sourceHeight
+	case transform.Fit == "fill":
+	case transform.Fit == "cover":
+		// Crop the middle of the image to the aspect ratio of the box
+		if sourceWidth*height > sourceHeight*width {
+			cropWidth := sourceHeight * width / height
+			crop.Min.X += (sourceWidth - cropWidth) / 2
+			crop.Max.X = crop.Min.X + cropWidth
+		} else {
+			cropHeight := sourceWidth * height / width
+			crop.Min.Y += (sourceHeight - cropHeight) / 2
+			crop.Max.Y = crop.Min.Y + cropHeight
+		}
+	default:
+		if sourceWidth*height > sourceHeight*width {
+			height = sourceHeight * width / sourceWidth
+		} else {
+			width = sourceWidth * height / sourceHeight
+		}
+	}
+
+	if width > crop.Dx() || height > crop.Dy() {
+		if transform.Fit == "fill" {
+			width, height = min(width, crop.Dx()), min(height, crop.Dy())
+		} else {
+			width, height = crop.Dx(), crop.Dy()
+		}
+	}
+	return image.Pt(max(width, 1), max(height, 1)), crop
+}
+
+// encode writes an image in the format of extension
+func encode(w io.Writer, m image.Image, extension string, quality int) error {
+	switch extension {
+	case ".jpg":
+		if quality == 0 {
+			quality = defaultJpegQuality
+		}
+		return jpeg.Encode(w, m, &jpeg.Options{Quality: quality})
+	case ".png":
+		return png.Encode(w, m)
+	case ".gif":
+		return gif.Encode(w, m, nil)
+	case ".webp":
+		return webp.Encode(w, m)
+	}
+	return ErrNotTransformable
+}
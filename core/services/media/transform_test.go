@@ -0,0 +1,30 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gojicms/goji/core/config"
+	"gorm.io/gorm"
+)
+
+func TestWebpDerivatives(t *testing.T) {
+	config.ActiveConfig.Application.Media.ImagePresets = []string{"w_400"}
+	photo := &Media{Model: gorm.Model{ID: 1}, Name: "photo.jpg", ContentType: "image/jpeg", Width: 1200, Height: 800}
+	graphic := &Media{Model: gorm.Model{ID: 2}, Name: "logo.png", ContentType: "image/png", Width: 1200, Height: 800}
+
+	if got := Srcset(photo, ".webp"); got != "" {
+		t.Errorf("a JPEG photo was offered as WebP: %q", got)
+	}
+	if got := Srcset(photo, ""); got != "/media/1/w_400.jpg 400w, /media/1/photo.jpg 1200w" {
+		t.Errorf("photo srcset %q", got)
+	}
+	if got := Srcset(graphic, ".webp"); got != "/media/2/w_400.webp 400w" {
+		t.Errorf("graphic srcset %q", got)
+	}
+
+	if _, err := Derive(context.Background(), photo, Transform{Width: 400}, ".webp"); !errors.Is(err, ErrNotTransformable) {
+		t.Errorf("deriving WebP from a JPEG photo: %v, want ErrNotTransformable", err)
+	}
+}
@@ -0,0 +1,24 @@
+package sessions
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// sessionV1 is the sessions table as created by migration 1; it must not change along with Session
+type sessionV1 struct {
+	gorm.Model
+	SessionId string    `gorm:"index;size:36"`
+	CSRF      string    `gorm:"size:255"`
+	UserId    uint      `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (sessionV1) TableName() string {
+	return "sessions"
+}
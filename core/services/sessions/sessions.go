@@ -1,13 +1,20 @@
 package sessions
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"net/http"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/gojicms/goji/core/config"
 	"github.com/gojicms/goji/core/database"
 	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
 	"github.com/gojicms/goji/core/server/httpflow"
 	"github.com/gojicms/goji/core/services/auth/users"
 	"github.com/gojicms/goji/core/utils"
@@ -28,16 +35,45 @@ type Session struct {
 	ExpiresAt time.Time `gorm:"index"`
 }
 
+var (
+	csrfExemptions []*regexp.Regexp
+	csrfMu         sync.RWMutex
+)
+
+var (
+	EventSessionCreated = extend.NewEvent[*Session]("session.created")
+	// EventSessionEnded is published when a user logs out, or their sessions are ended
+	EventSessionEnded = extend.NewEvent[*Session]("session.ended")
+)
+
 var Service = extend.ServiceDef{
 	Name:         "sessions",
 	FriendlyName: "Sessions",
 	Internal:     true,
+	DependsOn:    []string{"authentication"},
 	Resources:    []extend.ResourceDef{},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_sessions",
+			Up:      database.AutoMigrateModels(&sessionV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&sessionV1{})
+			},
+		},
+	},
+	Jobs: []extend.JobDef{
+		{
+			Name:        "sessions.cleanup",
+			Description: "Deletes expired sessions",
+			Schedule:    "@every 15m",
+			Run: func(ctx context.Context) error {
+				return CleanUpSessions()
+			},
+		},
+	},
 	OnInit: func() error {
-		CleanUpSessions()
-
-		db := database.GetDB()
-		_ = db.AutoMigrate(&Session{})
+		_ = CleanUpSessions()
 
 		extend.AddMiddleware(extend.NewMiddleware("*", "*", 0, func(flow *httpflow.HttpFlow) {
 			var user *users.User
@@ -46,10 +82,23 @@ var Service = extend.ServiceDef{
 			if session != nil {
 				user, _ = users.GetById(session.UserId)
 				flow.Set("session", session)
+				flow.Set("csrf", session.CSRF)
 				flow.Set("user", user)
 				flow.Append("templateData", "user", user)
 			}
 		}))
+
+		// Validate CSRF tokens once both sessions and API tokens have been resolved
+		extend.AddMiddleware(extend.NewMiddleware("*", "*", 2, func(flow *httpflow.HttpFlow) {
+			if !RequiresCSRF(flow) {
+				return
+			}
+			if !ValidCSRF(flow) {
+				log.Warn("Security", "Rejected %s %s with a missing or invalid CSRF token", flow.Request.Method, flow.Request.URL.Path)
+				flow.WriteErrorJson(http.StatusForbidden, "missing or invalid CSRF token")
+				flow.Terminate()
+			}
+		}))
 		return nil
 	},
 }
@@ -81,23 +130,21 @@ func EnsureSession(flow *httpflow.HttpFlow) (*Session, error) {
 	if time.Now().After(refreshTime) {
 		log.Debug("Sessions", "Session Expired - Renewing Session")
 
-		sessionId := uuid.New().String()
-		expiration := time.Now().Add(config.ActiveConfig.Application.Auth.CookieLifetime)
-		session.SessionId = sessionId
-		session.ExpiresAt = expiration
+		session.SessionId = uuid.New().String()
+		session.ExpiresAt = time.Now().Add(config.ActiveConfig.Application.Auth.CookieLifetime)
+		// Sessions that predate CSRF tokens are given one
+		if session.CSRF == "" {
+			csrf, err := newCSRFToken()
+			if err != nil {
+				return nil, err
+			}
+			session.CSRF = csrf
+		}
 
 		db := database.GetDB()
 		db.Model(&session).Save(session)
 
-		flow.SetCookie(&http.Cookie{
-			Name:     config.ActiveConfig.Application.Auth.CookieId,
-			Value:    sessionId,
-			Expires:  expiration,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Secure:   true,
-			Path:     "/",
-		})
+		setSessionCookies(flow, session)
 
 		// Redirect to the same page to reload
 		flow.Redirect(flow.Request.URL.String(), http.StatusFound)
@@ -112,10 +159,17 @@ func EnsureSession(flow *httpflow.HttpFlow) (*Session, error) {
 	return session, nil
 }
 
-func CreateSession(flow *httpflow.HttpFlow, csrf string, userId uint) (*Session, error) {
+// CreateSession starts a new session for the user, issuing a session cookie along with a CSRF token bound
+// to the session.
+func CreateSession(flow *httpflow.HttpFlow, userId uint) (*Session, error) {
 	expiration := time.Now().Add(config.ActiveConfig.Application.Auth.CookieLifetime)
 	sessionID := uuid.New().String()
 
+	csrf, err := newCSRFToken()
+	if err != nil {
+		return nil, err
+	}
+
 	session := Session{
 		SessionId: sessionID,
 		ExpiresAt: expiration,
@@ -124,31 +178,14 @@ func CreateSession(flow *httpflow.HttpFlow, csrf string, userId uint) (*Session,
 	}
 
 	db := database.GetDB()
-	err := db.Create(&session).Error
+	err = db.Create(&session).Error
 	if err != nil {
 		return nil, err
 	}
 
-	flow.SetCookie(&http.Cookie{
-		Name:     "_CSRF",
-		Value:    csrf,
-		Expires:  time.Now().Add(time.Hour * 24 * 14),
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Secure:   true,
-		Path:     "/",
-	})
-
-	flow.SetCookie(&http.Cookie{
-		Name:     config.ActiveConfig.Application.Auth.CookieId,
-		Value:    sessionID,
-		Expires:  expiration,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Secure:   true,
-		Path:     "/",
-	})
+	setSessionCookies(flow, &session)
 
+	EventSessionCreated.Notify(flow.Request.Context(), &session)
 	return &session, nil
 }
 
@@ -160,18 +197,43 @@ func EndSession(flow *httpflow.HttpFlow) {
 
 	db := database.GetDB()
 	db.Unscoped().Delete(&Session{}, session)
+	if ended, ok := session.(*Session); ok {
+		EventSessionEnded.Notify(flow.Request.Context(), ended)
+	}
 
-	// Expire the cookie
-	flow.SetCookie(&http.Cookie{
-		Name:     config.ActiveConfig.Application.Auth.CookieId,
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	// Expire the session and CSRF cookies
+	for _, name := range []string{config.ActiveConfig.Application.Auth.CookieId, config.ActiveConfig.Application.Auth.CSRFId} {
+		flow.SetCookie(&http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: name == config.ActiveConfig.Application.Auth.CookieId,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+			Path:     "/",
+		})
+	}
+}
+
+// EndUserSessions ends every session of a user, eg. once their password has changed
+func EndUserSessions(ctx context.Context, userId uint) error {
+	db := database.GetDB()
+	var ended []Session
+	if err := db.Where("user_id = ?", userId).Find(&ended).Error; err != nil {
+		return err
+	}
+	if len(ended) == 0 {
+		return nil
+	}
+	if err := db.Unscoped().Where("user_id = ?", userId).Delete(&Session{}).Error; err != nil {
+		log.Error("Sessions", "Failed to end the sessions of user %d: %s", userId, err.Error())
+		return err
+	}
+	for i := range ended {
+		EventSessionEnded.Notify(ctx, &ended[i])
+	}
+	return nil
 }
 
 func GetAllSessions() []Session {
@@ -191,15 +253,111 @@ func GetSessionFromRequest(r *http.Request) (*Session, error) {
 	return session, nil
 }
 
-func CleanUpSessions() {
+// ExemptFromCSRF exempts paths matching the regular expression from CSRF validation; this should be
+// reserved for endpoints that authenticate by other means, such as webhooks.
+func ExemptFromCSRF(pattern string) {
+	csrfMu.Lock()
+	defer csrfMu.Unlock()
+	csrfExemptions = append(csrfExemptions, regexp.MustCompile(pattern))
+}
+
+// RequiresCSRF returns true if the request changes state on behalf of a session and is not exempt. Requests
+// authenticated with an API token are exempt unless AuthConfig.CSRFRequireForTokens is set.
+func RequiresCSRF(flow *httpflow.HttpFlow) bool {
+	switch flow.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if !flow.Has("session") {
+		return false
+	}
+
+	authConfig := config.ActiveConfig.Application.Auth
+	if flow.Has("token") && !authConfig.CSRFRequireForTokens {
+		return false
+	}
+
+	path := flow.Request.URL.Path
+	for _, pattern := range authConfig.CSRFExemptPaths {
+		if matched, _ := regexp.MatchString(pattern, path); matched {
+			return false
+		}
+	}
+
+	csrfMu.RLock()
+	defer csrfMu.RUnlock()
+	for _, exemption := range csrfExemptions {
+		if exemption.MatchString(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidCSRF checks the CSRF token provided in the X-CSRF-Token header or _CSRF form field against the
+// token bound to the current session. The header is preferred, as reading the form field reads the body.
+func ValidCSRF(flow *httpflow.HttpFlow) bool {
+	session, ok := flow.Get("session").(*Session)
+	if !ok || session.CSRF == "" {
+		return false
+	}
+
+	provided := flow.Request.Header.Get(server.CSRFHeaderName)
+	if provided == "" {
+		// The form is read before any handler has limited the body, so it is bounded by the largest upload
+		// accepted; handlers check the parsed form against their own limit with flow.ParseMultipartForm
+		maxBytes := config.ActiveConfig.Application.Media.MaxRequestSize()
+		flow.Request.Body = http.MaxBytesReader(flow.Writer, flow.Request.Body, maxBytes)
+		provided = flow.PostFormValue(server.CSRFFieldName)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(session.CSRF)) == 1
+}
+
+// CleanUpSessions deletes expired sessions
+func CleanUpSessions() error {
 	db := database.GetDB()
-	db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&Session{})
+	return db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&Session{}).Error
 }
 
 //////////////////////////////////
 // Private Methods              //
 //////////////////////////////////
 
+// setSessionCookies issues the session cookie along with the CSRF cookie, both expiring with the session
+func setSessionCookies(flow *httpflow.HttpFlow, session *Session) {
+	// The CSRF cookie is readable by scripts so they may echo it in the X-CSRF-Token header
+	flow.SetCookie(&http.Cookie{
+		Name:     config.ActiveConfig.Application.Auth.CSRFId,
+		Value:    session.CSRF,
+		Expires:  session.ExpiresAt,
+		HttpOnly: false,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+		Path:     "/",
+	})
+
+	flow.SetCookie(&http.Cookie{
+		Name:     config.ActiveConfig.Application.Auth.CookieId,
+		Value:    session.SessionId,
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+		Path:     "/",
+	})
+}
+
+func newCSRFToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
 func findSessionById(id string) *Session {
 	db := database.GetDB()
 	var session = Session{}
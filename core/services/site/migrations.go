@@ -0,0 +1,15 @@
+package site
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// siteConfigV1 is the site_configs table as created by migration 1; it must not change along with SiteConfig
+type siteConfigV1 struct {
+	Key   string `gorm:"primarykey;size:255"`
+	Value string `gorm:"type:text"`
+}
+
+func (siteConfigV1) TableName() string {
+	return "site_configs"
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gojicms/goji/core/extend"
 	"github.com/gojicms/goji/core/server"
 	"github.com/gojicms/goji/core/server/httpflow"
+	"gorm.io/gorm"
 )
 
 //go:embed "site.gohtml"
@@ -67,10 +68,19 @@ func SyncSiteConfigs() {
 var Service = extend.ServiceDef{
 	Name:         "site_config",
 	FriendlyName: "Site Configuration",
+	DependsOn:    []string{"administration"},
 	Resources:    []extend.ResourceDef{},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_site_configs",
+			Up:      database.AutoMigrateModels(&siteConfigV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&siteConfigV1{})
+			},
+		},
+	},
 	OnInit: func() error {
-		database.AutoMigrate(SiteConfig{})
-
 		SyncSiteConfigs()
 
 		extend.AddSideMenuItem("Site", "site", 0, "", "admin")
@@ -82,12 +92,15 @@ var Service = extend.ServiceDef{
 					err := flow.Request.ParseForm()
 					if err == nil {
 						for k, v := range flow.Request.Form {
+							if k == server.CSRFFieldName {
+								continue
+							}
 							SetSiteConfig(k, strings.Join(v, ","))
 						}
 					}
 				}
 
-				data, _ := server.RenderTemplate(siteConfigTemplate, flow.Get("templateData"), server.DefaultRenderOptions)
+				data, _ := server.RenderTemplate(siteConfigTemplate, flow.Get("templateData"), server.RenderOptions{Flow: flow})
 				return data, nil
 			},
 			Permission: "",
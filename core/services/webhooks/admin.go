@@ -0,0 +1,246 @@
+package webhooks
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/server"
+	"github.com/gojicms/goji/core/server/httpflow"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"github.com/gojicms/goji/core/utils"
+)
+
+//go:embed listing.gohtml
+var listingHtml []byte
+
+//go:embed editor.gohtml
+var editorHtml []byte
+
+//go:embed delivery.gohtml
+var deliveryHtml []byte
+
+func registerAdminPages() {
+	extend.AddSideMenuItem("Webhooks", "webhooks", 40, "System", "webhook:view")
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "webhook:view",
+		Route:      "webhooks",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Webhooks")
+
+			user := flow.Get("user").(*users.User)
+			items, err := GetAll()
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			lastDeliveries := map[uint]*Delivery{}
+			for _, webhook := range items {
+				if deliveries, _ := GetDeliveries(webhook.ID, 1, 0); len(deliveries) > 0 {
+					lastDeliveries[webhook.ID] = &deliveries[0]
+				}
+			}
+
+			content, err := server.RenderTemplate(listingHtml, utils.Object{
+				"items":          items,
+				"lastDeliveries": lastDeliveries,
+				"canAdd":         user.HasPermission("webhook:add"),
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "webhook:add",
+		Route:      "webhooks/new",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Create Webhook")
+
+			webhook := Webhook{Active: true}
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				webhookFromForm(flow, &webhook)
+				if err := Create(&webhook); err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to create webhook: " + err.Error()
+				} else {
+					flow.Redirect(fmt.Sprintf("/admin/webhooks/%d", webhook.ID), http.StatusFound)
+					return []byte{}, nil
+				}
+			}
+
+			return renderEditor(flow, &webhook, true, result)
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "webhook:view",
+		Route:      "webhooks/{id}",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Edit Webhook")
+
+			webhook, err := GetById(uint(utils.Stoid(flow.GetKvp("admin_meta", "id"), 0)))
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" {
+				user := flow.Get("user").(*users.User)
+
+				switch flow.PostFormValue("action") {
+				case "save", "regenerate":
+					if !user.HasPermission("webhook:edit") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to edit webhooks."
+						break
+					}
+
+					webhookFromForm(flow, webhook)
+					if flow.PostFormValue("action") == "regenerate" {
+						webhook.Secret = NewSecret()
+					}
+					if err := Update(webhook); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to update webhook: " + err.Error()
+						break
+					}
+					result["status"] = "success"
+					result["message"] = "Webhook updated successfully"
+				case "delete":
+					if !user.HasPermission("webhook:delete") {
+						result["status"] = "error"
+						result["message"] = "You do not have permission to delete webhooks."
+						break
+					}
+
+					if err := Delete(webhook); err != nil {
+						result["status"] = "error"
+						result["message"] = "Failed to delete webhook: " + err.Error()
+						break
+					}
+					flow.Redirect("/admin/webhooks", http.StatusFound)
+					return []byte{}, nil
+				}
+			}
+
+			return renderEditor(flow, webhook, false, result)
+		},
+	})
+
+	extend.AddAdminPage(extend.AdminPage{
+		Permission: "webhook:view",
+		Route:      "webhooks/{id}/deliveries/{delivery}",
+		Render: func(flow *httpflow.HttpFlow) ([]byte, error) {
+			flow.Append("templateData", "title", "Goji - Webhook Delivery")
+
+			user := flow.Get("user").(*users.User)
+			delivery, err := GetDelivery(uint(utils.Stoid(flow.GetKvp("admin_meta", "delivery"), 0)))
+			if err == nil && fmt.Sprint(delivery.WebhookID) != flow.GetKvp("admin_meta", "id") {
+				err = ErrDeliveryNotFound
+			}
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+
+			result := utils.Object{
+				"status":  nil,
+				"message": nil,
+			}
+
+			if flow.Request.Method == "POST" && flow.PostFormValue("action") == "redeliver" {
+				if !user.HasPermission("webhook:edit") {
+					result["status"] = "error"
+					result["message"] = "You do not have permission to redeliver events."
+					goto render
+				}
+				redelivery, err := Redeliver(delivery)
+				if err != nil {
+					result["status"] = "error"
+					result["message"] = "Failed to redeliver the event: " + err.Error()
+					goto render
+				}
+				flow.Redirect(fmt.Sprintf("/admin/webhooks/%d/deliveries/%d", redelivery.WebhookID, redelivery.ID), http.StatusFound)
+				return []byte{}, nil
+			}
+
+		render:
+			content, err := server.RenderTemplate(deliveryHtml, utils.Object{
+				"delivery": delivery,
+				"canEdit":  user.HasPermission("webhook:edit"),
+				"result":   result,
+			}, server.RenderOptions{Flow: flow})
+			if err != nil {
+				d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+				return d, nil
+			}
+			return content, nil
+		},
+	})
+}
+
+// webhookFromForm reads the fields submitted by the webhook editor
+func webhookFromForm(flow *httpflow.HttpFlow, webhook *Webhook) {
+	webhook.Name = flow.PostFormValue("name")
+	webhook.Url = flow.PostFormValue("url")
+	webhook.Active = flow.PostFormValue("active") == "on"
+
+	webhook.Events = utils.CSV{}
+	if err := flow.Request.ParseForm(); err != nil {
+		return
+	}
+	for _, event := range flow.Request.PostForm["events"] {
+		if !webhook.Events.Includes(event) {
+			webhook.Events = append(webhook.Events, event)
+		}
+	}
+}
+
+func renderEditor(flow *httpflow.HttpFlow, webhook *Webhook, create bool, result utils.Object) ([]byte, error) {
+	user := flow.Get("user").(*users.User)
+	query := flow.Request.URL.Query()
+	offset := utils.Stoid(query.Get("offset"), 0)
+	count := utils.Stoid(query.Get("count"), 20)
+
+	var deliveries []Delivery
+	var total int64
+	if !create {
+		deliveries, _ = GetDeliveries(webhook.ID, count, offset)
+		total, _ = CountDeliveries(webhook.ID)
+	}
+
+	content, err := server.RenderTemplate(editorHtml, utils.Object{
+		"webhook":    webhook,
+		"eventTypes": EventTypes(),
+		"deliveries": deliveries,
+		"offset":     offset,
+		"count":      count,
+		"totalItems": total,
+		"create":     create,
+		"canEdit":    create || user.HasPermission("webhook:edit"),
+		"canDelete":  user.HasPermission("webhook:delete"),
+		"result":     result,
+	}, server.RenderOptions{Flow: flow})
+	if err != nil {
+		d := []byte(fmt.Sprintf("<b>%s</b>", err.Error()))
+		return d, nil
+	}
+	return content, nil
+}
@@ -0,0 +1,193 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/services/jobs"
+	"github.com/gojicms/goji/core/utils/log"
+)
+
+// maxResponseBody is how much of a response is kept in the delivery log
+const maxResponseBody = 4096
+
+// payload is the body sent to a webhook
+type payload struct {
+	Id        uint            `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// errPrivateAddress is returned when a webhook resolves to an address it may not be delivered to
+var errPrivateAddress = errors.New("webhooks may not be delivered to loopback, link-local or private addresses")
+
+// internalPrefixes are ranges that are not private by name but never lead to a public endpoint: "this
+// network", which reaches the local host, and carrier-grade NAT, which some clouds use for metadata services
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// client delivers webhooks. The address of every connection is checked as it is dialed, after DNS
+// resolution, so a host name cannot be pointed at an internal service once the webhook is saved. Proxies
+// from the environment are not used, as the check would only see the proxy.
+var client = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network string, address string, _ syscall.RawConn) error {
+				return checkAddress(address)
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// deliverTask sends a delivery to its webhook, retrying while the endpoint is unavailable
+var deliverTask = jobs.NewTask("webhooks.deliver", jobs.TaskOptions{
+	MaxAttempts: 8,
+	Timeout:     time.Minute,
+	Backoff:     30 * time.Second,
+}, func(ctx context.Context, id uint) error {
+	delivery, err := GetDelivery(id)
+	if errors.Is(err, ErrDeliveryNotFound) {
+		// The webhook was deleted in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return deliver(ctx, delivery)
+})
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Sign returns the signature of a payload sent at the given unix time, as sent in the X-Goji-Signature
+// header: the hex HMAC-SHA256, keyed by the secret of the webhook, of the timestamp, a dot and the body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// deliver posts a delivery to its webhook and records the response. Client errors other than timeouts and
+// rate limiting are not retried, since sending the same request again will not help.
+func deliver(ctx context.Context, delivery *Delivery) error {
+	body, err := json.Marshal(payload{
+		Id:        delivery.ID,
+		Event:     delivery.Event,
+		CreatedAt: delivery.CreatedAt,
+		Data:      json.RawMessage(delivery.Data),
+	})
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	timestamp := time.Now().Unix()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Goji-Webhooks/"+config.ActiveConfig.Cms.Version)
+	request.Header.Set("X-Goji-Event", delivery.Event)
+	request.Header.Set("X-Goji-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set("X-Goji-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Goji-Signature", "sha256="+Sign(delivery.Webhook.Secret, timestamp, body))
+
+	started := time.Now()
+	response, err := client.Do(request)
+	if errors.Is(err, errPrivateAddress) {
+		err = jobs.Permanent(err)
+	}
+	delivery.Attempts++
+	delivery.Duration = time.Since(started)
+	delivery.ResponseCode = 0
+	delivery.ResponseBody = ""
+	delivery.Error = ""
+
+	if err == nil {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, maxResponseBody))
+		_ = response.Body.Close()
+		delivery.ResponseCode = response.StatusCode
+		delivery.ResponseBody = string(responseBody)
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			err = fmt.Errorf("the endpoint responded with %s", response.Status)
+			if response.StatusCode >= 400 && response.StatusCode < 500 &&
+				response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+				err = jobs.Permanent(err)
+			}
+		}
+	}
+
+	now := time.Now()
+	delivery.DeliveredAt = &now
+	delivery.Status = DeliverySucceeded
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	db := database.GetDB()
+	saveErr := database.Retry(func() error { return db.Omit("Webhook").Save(delivery).Error })
+	if saveErr != nil {
+		log.Error("Webhooks", "Failed to record delivery %d: %s", delivery.ID, saveErr.Error())
+	}
+	return err
+}
+
+// checkAddress returns errPrivateAddress if address, an IP and port being dialed, is loopback, link-local,
+// private or otherwise internal, unless private networks are allowed
+func checkAddress(address string) error {
+	if config.ActiveConfig.Application.Webhooks.AllowPrivateNetworks {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if isPrivateAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// isPrivateAddress returns true if ip is not a public unicast address
+func isPrivateAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return true
+	}
+	for _, prefix := range internalPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/gojicms/goji/core/config"
+)
+
+func TestIsPrivateAddress(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"100.100.100.200":  true,
+		"0.0.0.0":          true,
+		"0.1.2.3":          true,
+		"224.0.0.1":        true,
+		"::1":              true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"::ffff:127.0.0.1": true,
+		"::ffff:10.0.0.1":  true,
+		"8.8.8.8":          false,
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+	}
+	for address, private := range tests {
+		if got := isPrivateAddress(netip.MustParseAddr(address)); got != private {
+			t.Errorf("%s: private %v, want %v", address, got, private)
+		}
+	}
+}
+
+func TestDeliveryToPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	t.Cleanup(func() { config.ActiveConfig.Application.Webhooks.AllowPrivateNetworks = false })
+
+	// The server listens on loopback, so it is refused as the connection is dialed
+	config.ActiveConfig.Application.Webhooks.AllowPrivateNetworks = false
+	_, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("delivery to %s: %v, want errPrivateAddress", server.URL, err)
+	}
+
+	config.ActiveConfig.Application.Webhooks.AllowPrivateNetworks = true
+	response, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("delivery with private networks allowed: %v", err)
+	}
+	response.Body.Close()
+}
+
+func TestValidateUrl(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/hook":      true,
+		"http://93.184.216.34/hook":     true,
+		"http://localhost:8080/hook":    false,
+		"http://api.localhost/hook":     false,
+		"http://127.0.0.1/hook":         false,
+		"http://[::1]/hook":             false,
+		"http://169.254.169.254/latest": false,
+		"http://192.168.0.10:9000/hook": false,
+		"ftp://example.com/hook":        false,
+		"/relative/hook":                false,
+	}
+	for url, valid := range tests {
+		err := validate(&Webhook{Name: "test", Url: url, Secret: "secret"})
+		if valid && err != nil {
+			t.Errorf("%s: unexpected error %v", url, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
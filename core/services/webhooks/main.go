@@ -0,0 +1,50 @@
+package webhooks
+
+import (
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/services/auth/users"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Service Definition           //
+//////////////////////////////////
+
+var Service = extend.ServiceDef{
+	Name:         "webhooks",
+	FriendlyName: "Webhooks",
+	DependsOn:    []string{"administration", "authentication", "jobs"},
+	Permissions: []extend.PermissionDef{
+		{Name: "webhook:view", Description: "View webhooks and their deliveries", DefaultGroups: []string{"administrator"}},
+		{Name: "webhook:add", Description: "Create webhooks", DefaultGroups: []string{"administrator"}},
+		{Name: "webhook:edit", Description: "Edit webhooks and redeliver events", DefaultGroups: []string{"administrator"}},
+		{Name: "webhook:delete", Description: "Delete webhooks", DefaultGroups: []string{"administrator"}},
+	},
+	Migrations: []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_webhooks",
+			Up:      database.AutoMigrateModels(&webhookV1{}, &deliveryV1{}),
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&deliveryV1{}, &webhookV1{})
+			},
+		},
+	},
+	OnInit: func() error {
+		Register(users.EventCreated, "A user was created", func(user *users.User) any {
+			// Only the public details of the user are sent
+			return map[string]any{
+				"id":           user.ID,
+				"uuid":         user.Uuid,
+				"username":     user.Username,
+				"email":        user.Email,
+				"display_name": user.DisplayName,
+				"group":        user.GroupName,
+				"created_at":   user.CreatedAt,
+			}
+		})
+		registerAdminPages()
+		return nil
+	},
+}
@@ -0,0 +1,47 @@
+package webhooks
+
+import (
+	"time"
+
+	"github.com/gojicms/goji/core/utils"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Schema Snapshots             //
+//////////////////////////////////
+
+// webhookV1 and deliveryV1 are the tables as created by migration 1; they must not change along with
+// Webhook and Delivery
+type webhookV1 struct {
+	gorm.Model
+	Name   string    `gorm:"size:255"`
+	Url    string    `gorm:"size:2048"`
+	Secret string    `gorm:"size:255"`
+	Events utils.CSV `gorm:"type:text"`
+	Active bool
+}
+
+func (webhookV1) TableName() string {
+	return "webhooks"
+}
+
+type deliveryV1 struct {
+	ID           uint       `gorm:"primaryKey"`
+	WebhookID    uint       `gorm:"index"`
+	Webhook      *webhookV1 `gorm:"constraint:OnDelete:CASCADE"`
+	Event        string     `gorm:"size:255"`
+	Data         string     `gorm:"type:text"`
+	Status       string     `gorm:"size:16;index"`
+	Attempts     int
+	ResponseCode int
+	ResponseBody string `gorm:"type:text"`
+	Error        string `gorm:"type:text"`
+	Duration     time.Duration
+	CreatedAt    time.Time `gorm:"index"`
+	DeliveredAt  *time.Time
+}
+
+func (deliveryV1) TableName() string {
+	return "deliveries"
+}
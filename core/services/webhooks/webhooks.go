@@ -0,0 +1,314 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gojicms/goji/core/config"
+	"github.com/gojicms/goji/core/database"
+	"github.com/gojicms/goji/core/extend"
+	"github.com/gojicms/goji/core/utils"
+	"github.com/gojicms/goji/core/utils/log"
+	"gorm.io/gorm"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Webhook is an endpoint that receives the events it subscribes to
+type Webhook struct {
+	gorm.Model
+	Name string `gorm:"size:255"`
+	Url  string `gorm:"size:2048"`
+	// Secret signs the payloads sent to the endpoint, so it can verify they came from Goji
+	Secret string    `gorm:"size:255"`
+	Events utils.CSV `gorm:"type:text"`
+	Active bool
+}
+
+// Delivery is an event sent, or waiting to be sent, to a webhook. The response fields describe the most
+// recent attempt.
+type Delivery struct {
+	ID        uint     `gorm:"primaryKey"`
+	WebhookID uint     `gorm:"index"`
+	Webhook   *Webhook `gorm:"constraint:OnDelete:CASCADE"`
+	Event     string   `gorm:"size:255"`
+	// Data is the JSON of the event, sent as the data of the payload
+	Data         string `gorm:"type:text"`
+	Status       string `gorm:"size:16;index"`
+	Attempts     int
+	ResponseCode int
+	ResponseBody string `gorm:"type:text"`
+	Error        string `gorm:"type:text"`
+	Duration     time.Duration
+	CreatedAt    time.Time `gorm:"index"`
+	DeliveredAt  *time.Time
+}
+
+// EventType is an event webhooks may subscribe to
+type EventType struct {
+	Name        string
+	Description string
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
+var (
+	eventTypes   []EventType
+	eventTypesMu sync.RWMutex
+)
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Register makes an event available to webhooks; data converts its payload into the data sent to them, and
+// must not expose anything the receivers should not see. Register events that report completed actions,
+// such as users.EventCreated, since the deliveries are queued as soon as the event is delivered.
+func Register[T any](event extend.Event[T], description string, data func(payload T) any) {
+	eventTypesMu.Lock()
+	eventTypes = append(eventTypes, EventType{Name: event.Name(), Description: description})
+	eventTypesMu.Unlock()
+
+	// The data is captured synchronously, after the other listeners, so it matches what was saved
+	event.On("webhooks", 1000, func(ctx context.Context, payload T) error {
+		return enqueue(event.Name(), data(payload))
+	})
+}
+
+// EventTypes returns the events webhooks may subscribe to, in the order they were registered
+func EventTypes() []EventType {
+	eventTypesMu.RLock()
+	defer eventTypesMu.RUnlock()
+	return slices.Clone(eventTypes)
+}
+
+// GetAll returns every webhook, ordered by name
+func GetAll() ([]Webhook, error) {
+	db := database.GetDB()
+	var webhooks []Webhook
+	if err := db.Order("name").Find(&webhooks).Error; err != nil {
+		log.Error("Webhooks", "Failed to get webhooks: %s", err.Error())
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetById gets a webhook by its id
+func GetById(id uint) (*Webhook, error) {
+	db := database.GetDB()
+	var webhook Webhook
+	res := db.Limit(1).Find(&webhook, id)
+	if res.Error != nil {
+		log.Error("Webhooks", "Failed to get webhook %d: %s", id, res.Error.Error())
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrWebhookNotFound
+	}
+	return &webhook, nil
+}
+
+// Create saves a new webhook, generating a secret if it has none
+func Create(webhook *Webhook) error {
+	if webhook.Secret == "" {
+		webhook.Secret = NewSecret()
+	}
+	if err := validate(webhook); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if err := db.Create(webhook).Error; err != nil {
+		log.Error("Webhooks", "Failed to create webhook: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Update saves the changes to a webhook
+func Update(webhook *Webhook) error {
+	if err := validate(webhook); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if err := db.Save(webhook).Error; err != nil {
+		log.Error("Webhooks", "Failed to update webhook %d: %s", webhook.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Delete deletes a webhook along with its deliveries
+func Delete(webhook *Webhook) error {
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", webhook.ID).Delete(&Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(webhook).Error
+	})
+	if err != nil {
+		log.Error("Webhooks", "Failed to delete webhook %d: %s", webhook.ID, err.Error())
+	}
+	return err
+}
+
+// NewSecret generates a random signing secret
+func NewSecret() string {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return hex.EncodeToString(secret)
+}
+
+// Subscribes returns true if the webhook receives the named event
+func (webhook Webhook) Subscribes(event string) bool {
+	return webhook.Events.Includes(event)
+}
+
+// GetDeliveries returns the deliveries of a webhook, most recent first
+func GetDeliveries(webhookId uint, limit int, offset int) ([]Delivery, error) {
+	db := database.GetDB()
+	var deliveries []Delivery
+	res := db.Where("webhook_id = ?", webhookId).Order("id desc").Limit(limit).Offset(offset).Find(&deliveries)
+	if res.Error != nil {
+		log.Error("Webhooks", "Failed to get the deliveries of webhook %d: %s", webhookId, res.Error.Error())
+		return nil, res.Error
+	}
+	return deliveries, nil
+}
+
+// CountDeliveries counts the deliveries of a webhook
+func CountDeliveries(webhookId uint) (int64, error) {
+	db := database.GetDB()
+	var count int64
+	res := db.Model(&Delivery{}).Where("webhook_id = ?", webhookId).Count(&count)
+	if res.Error != nil {
+		log.Error("Webhooks", "Failed to count the deliveries of webhook %d: %s", webhookId, res.Error.Error())
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// GetDelivery gets a delivery, along with its webhook, by its id
+func GetDelivery(id uint) (*Delivery, error) {
+	db := database.GetDB()
+	var delivery Delivery
+	res := db.Preload("Webhook").Limit(1).Find(&delivery, id)
+	if res.Error != nil {
+		log.Error("Webhooks", "Failed to get delivery %d: %s", id, res.Error.Error())
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 || delivery.Webhook == nil {
+		return nil, ErrDeliveryNotFound
+	}
+	return &delivery, nil
+}
+
+// Redeliver sends the event of a delivery to its webhook again, as a new delivery
+func Redeliver(delivery *Delivery) (*Delivery, error) {
+	redelivery := Delivery{
+		WebhookID: delivery.WebhookID,
+		Event:     delivery.Event,
+		Data:      delivery.Data,
+		Status:    DeliveryPending,
+	}
+	db := database.GetDB()
+	if err := db.Create(&redelivery).Error; err != nil {
+		log.Error("Webhooks", "Failed to redeliver delivery %d: %s", delivery.ID, err.Error())
+		return nil, err
+	}
+	if _, err := deliverTask.Enqueue(redelivery.ID); err != nil {
+		return nil, err
+	}
+	return &redelivery, nil
+}
+
+// FormattedDuration returns the duration of the last attempt rounded to the millisecond, or an empty
+// string if the delivery has not been attempted
+func (delivery Delivery) FormattedDuration() string {
+	if delivery.Attempts == 0 {
+		return ""
+	}
+	return delivery.Duration.Round(time.Millisecond).String()
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func validate(webhook *Webhook) error {
+	webhook.Name = strings.TrimSpace(webhook.Name)
+	webhook.Url = strings.TrimSpace(webhook.Url)
+	if webhook.Name == "" {
+		return errors.New("a name is required")
+	}
+	parsed, err := url.Parse(webhook.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("the URL must be an absolute http or https address")
+	}
+	// Host names are checked again as deliveries are dialed, since they may resolve to anything
+	if !config.ActiveConfig.Application.Webhooks.AllowPrivateNetworks {
+		host := strings.ToLower(parsed.Hostname())
+		ip, err := netip.ParseAddr(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || (err == nil && isPrivateAddress(ip)) {
+			return errors.New("the URL must not be a loopback, link-local or private address")
+		}
+	}
+	if webhook.Secret == "" {
+		return errors.New("a secret is required")
+	}
+	return nil
+}
+
+// enqueue records a delivery of an event to every active webhook subscribing to it, and queues them
+func enqueue(event string, data any) error {
+	db := database.GetDB()
+	var webhooks []Webhook
+	if err := db.Where("active = ?", true).Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	var encoded []byte
+	var errs []error
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		if encoded == nil {
+			var err error
+			if encoded, err = json.Marshal(data); err != nil {
+				return fmt.Errorf("failed to encode %s: %w", event, err)
+			}
+		}
+
+		delivery := Delivery{WebhookID: webhook.ID, Event: event, Data: string(encoded), Status: DeliveryPending}
+		if err := database.Retry(func() error { return db.Create(&delivery).Error }); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := deliverTask.Enqueue(delivery.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
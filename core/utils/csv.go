@@ -19,6 +19,10 @@ func (csv *CSV) Scan(src any) error {
 		*csv = src.(CSV)
 		return nil
 	case string:
+		if src.(string) == "" {
+			*csv = CSV{}
+			return nil
+		}
 		*csv = strings.Split(src.(string), ",")
 		return nil
 	default:
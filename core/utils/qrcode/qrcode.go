@@ -0,0 +1,511 @@
+/*
+qrcode encodes text as QR codes (ISO/IEC 18004) in byte mode at error correction level M, which recovers
+from around 15% of the symbol being damaged. Each code uses the smallest version that fits its text and the
+mask with the lowest penalty. Codes are drawn as SVG, as they are only shown in the browser.
+*/
+
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// Code is an encoded QR code
+type Code struct {
+	// Size is the width and height of the code in modules, excluding the quiet zone
+	Size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+const (
+	minVersion = 1
+	maxVersion = 40
+	// formatLevelM identifies error correction level M in the format information
+	formatLevelM = 0
+	// quietZone is the width of the light border required around a code, in modules
+	quietZone = 4
+)
+
+// eccPerBlock and eccBlocks are the error correction codewords of each block, and the number of blocks, of
+// each version at level M
+var eccPerBlock = [maxVersion + 1]int{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26,
+	26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28}
+var eccBlocks = [maxVersion + 1]int{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17,
+	18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+
+// ErrTooLong is returned for text that does not fit in the largest version
+var ErrTooLong = errors.New("qrcode: text is too long")
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Encode encodes text as a QR code
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+
+	version := minVersion
+	for ; version <= maxVersion; version++ {
+		if segmentBits(version, len(data)) <= dataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrTooLong
+	}
+
+	code := newCode(version)
+	code.drawFunctionPatterns(version)
+	code.drawCodewords(addErrorCorrection(version, encodeData(version, data)))
+
+	// Choose the mask that leaves the fewest patterns which are hard to scan
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// Masks are undone by applying them again
+		code.applyMask(mask)
+	}
+	code.applyMask(best)
+	code.drawFormatBits(best)
+	return code, nil
+}
+
+// Dark returns true if the module at column x and row y is dark
+func (c *Code) Dark(x int, y int) bool {
+	return c.modules[y][x]
+}
+
+// SVG draws the code, with its quiet zone, as an SVG image; scale is the size of each module in pixels
+func (c *Code) SVG(scale int) string {
+	size := c.Size + quietZone*2
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		size*scale, size*scale, size, size, path.String())
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	c := &Code{Size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.function[i] = make([]bool, size)
+	}
+	return c
+}
+
+// rawModules returns the number of modules of a version available for data and error correction
+func rawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		alignments := version/7 + 2
+		result -= (25*alignments-10)*alignments - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// dataCodewords returns the number of data codewords of a version
+func dataCodewords(version int) int {
+	return rawModules(version)/8 - eccPerBlock[version]*eccBlocks[version]
+}
+
+// segmentBits returns the bits needed to encode n bytes in a version
+func segmentBits(version int, n int) int {
+	return 4 + countBits(version) + n*8
+}
+
+// countBits returns the width of the character count of a byte segment
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// encodeData encodes the data as a byte segment, padded to the data capacity of the version
+func encodeData(version int, data []byte) []byte {
+	capacity := dataCodewords(version)
+	bits := &bitBuffer{}
+	bits.append(0x4, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// Terminate, then pad to a whole byte and fill the remaining codewords
+	bits.append(0, min(4, capacity*8-bits.length))
+	bits.append(0, (8-bits.length%8)%8)
+	for pad := 0xEC; bits.length < capacity*8; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes
+}
+
+// addErrorCorrection splits the data into blocks, appends the error correction codewords of each and
+// interleaves the blocks
+func addErrorCorrection(version int, data []byte) []byte {
+	numBlocks := eccBlocks[version]
+	eccLen := eccPerBlock[version]
+	raw := rawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		length := shortLen - eccLen
+		if i >= numShort {
+			length++
+		}
+		block := append([]byte{}, data[k:k+length]...)
+		k += length
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			// Short blocks are padded, so every block has the same length while interleaving
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// setFunction sets a module that is part of a function pattern, which data and masks skip
+func (c *Code) setFunction(x int, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns(version int) {
+	// Timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns, with their separators, in three corners
+	for _, corner := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x >= 0 && x < c.Size && y >= 0 && y < c.Size {
+					distance := max(abs(dx), abs(dy))
+					c.setFunction(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would overlap the finder patterns
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format information, which is drawn once the mask is chosen
+	c.drawFormatBits(0)
+
+	// Version information
+	if version >= 7 {
+		remainder := version
+		for i := 0; i < 12; i++ {
+			remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+		}
+		bits := version<<12 | remainder
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 != 0
+			a, b := c.Size-11+i%3, i/3
+			c.setFunction(a, b, dark)
+			c.setFunction(b, a, dark)
+		}
+	}
+}
+
+// alignmentPositions returns the centres of the alignment patterns of a version, along each axis
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, position := count-1, version*4+10; i >= 1; i, position = i-1, position-step {
+		positions[i] = position
+	}
+	return positions
+}
+
+// drawFormatBits draws both copies of the format information for level M and a mask
+func (c *Code) drawFormatBits(mask int) {
+	data := formatLevelM<<3 | mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	// Around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	// Split between the other finder patterns
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawCodewords places the codewords in two-module columns, zigzagging up and down from the bottom right
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < c.Size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vertical
+				}
+				if !c.function[y][x] && i < len(codewords)*8 {
+					c.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by a mask pattern
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the code by the rules of the standard: long runs of one colour, 2x2 blocks, patterns
+// resembling finder patterns and an imbalance of dark and light modules
+func (c *Code) penalty() int {
+	penalty := 0
+	dark := 0
+
+	line := make([]bool, c.Size)
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < c.Size; i++ {
+			for j := 0; j < c.Size; j++ {
+				if vertical {
+					line[j] = c.modules[j][i]
+				} else {
+					line[j] = c.modules[i][j]
+				}
+			}
+			penalty += linePenalty(line)
+		}
+	}
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				colour := c.modules[y][x]
+				if colour == c.modules[y-1][x] && colour == c.modules[y][x-1] && colour == c.modules[y-1][x-1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	total := c.Size * c.Size
+	deviation := abs(dark*20-total*10) / total
+	return penalty + deviation*10
+}
+
+// finderLike is the pattern of a finder pattern preceded or followed by four light modules
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+func linePenalty(line []bool) int {
+	penalty := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			penalty += run - 2
+		}
+		run = 1
+	}
+
+	for i := 0; i+11 <= len(line); i++ {
+		for _, pattern := range finderLike {
+			matches := true
+			for j, dark := range pattern {
+				if line[i+j] != dark {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				penalty += 40
+			}
+		}
+	}
+	return penalty
+}
+
+// rsDivisor returns the generator polynomial of a Reed-Solomon code of a degree, highest terms first,
+// omitting the leading one
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of the data
+func rsRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+type bitBuffer struct {
+	bytes  []byte
+	length int
+}
+
+// append appends the lowest n bits of value, most significant first
+func (b *bitBuffer) append(value int, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if b.length%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if (value>>i)&1 != 0 {
+			b.bytes[b.length/8] |= 0x80 >> (b.length % 8)
+		}
+		b.length++
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
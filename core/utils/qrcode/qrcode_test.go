@@ -0,0 +1,261 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// formatInfo are the format information strings of level M for each mask, from the standard
+var formatInfo = [8]int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+
+// readFormat reads both copies of the format information of a code
+func readFormat(c *Code) (int, int) {
+	first, second := 0, 0
+	set := func(bits *int, i int, x int, y int) {
+		if c.Dark(x, y) {
+			*bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		set(&first, i, 8, i)
+	}
+	set(&first, 6, 8, 7)
+	set(&first, 7, 8, 8)
+	set(&first, 8, 7, 8)
+	for i := 9; i < 15; i++ {
+		set(&first, i, 14-i, 8)
+	}
+	for i := 0; i < 8; i++ {
+		set(&second, i, c.Size-1-i, 8)
+	}
+	for i := 8; i < 15; i++ {
+		set(&second, i, 8, c.Size-15+i)
+	}
+	return first, second
+}
+
+// decode reads the text back out of a code: it removes the mask, reads the codewords in placement order,
+// splits them into their blocks, checks each block has no errors and parses the byte segment
+func decode(t *testing.T, c *Code) []byte {
+	t.Helper()
+	version := (c.Size - 17) / 4
+	format, _ := readFormat(c)
+	mask := -1
+	for i, info := range formatInfo {
+		if info == format {
+			mask = i
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format information %015b is not level M", format)
+	}
+
+	unmasked := &Code{Size: c.Size, function: c.function}
+	for _, row := range c.modules {
+		unmasked.modules = append(unmasked.modules, append([]bool{}, row...))
+	}
+	unmasked.applyMask(mask)
+
+	raw := rawModules(version) / 8
+	codewords := make([]byte, 0, raw)
+	var bits bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < c.Size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vertical
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vertical
+				}
+				if !c.function[y][x] && bits.length < raw*8 {
+					dark := 0
+					if unmasked.modules[y][x] {
+						dark = 1
+					}
+					bits.append(dark, 1)
+				}
+			}
+		}
+	}
+	codewords = append(codewords, bits.bytes...)
+
+	// Undo the interleaving; short blocks have one data codeword fewer than long ones, which is skipped
+	numBlocks, eccLen := eccBlocks[version], eccPerBlock[version]
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortLen; i++ {
+		for j := range blocks {
+			if i == shortLen-eccLen && j < numShort {
+				continue
+			}
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+	if k != raw {
+		t.Fatalf("read %d codewords into blocks, want %d", k, raw)
+	}
+
+	var data []byte
+	for j, block := range blocks {
+		// Each block is a codeword of the Reed-Solomon code, so it vanishes at every root of the generator
+		root := byte(1)
+		for i := 0; i < eccLen; i++ {
+			syndrome := byte(0)
+			for _, b := range block {
+				syndrome = gfMultiply(syndrome, root) ^ b
+			}
+			if syndrome != 0 {
+				t.Fatalf("block %d has syndrome %d at root %d", j, syndrome, i)
+			}
+			root = gfMultiply(root, 0x02)
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+
+	reader := &bitReader{data: data}
+	if mode := reader.read(4); mode != 0x4 {
+		t.Fatalf("mode %04b, want byte mode", mode)
+	}
+	text := make([]byte, reader.read(countBits(version)))
+	for i := range text {
+		text[i] = byte(reader.read(8))
+	}
+	return text
+}
+
+type bitReader struct {
+	data     []byte
+	position int
+}
+
+func (r *bitReader) read(n int) int {
+	value := 0
+	for i := 0; i < n; i++ {
+		value = value<<1 | int(r.data[r.position/8]>>(7-r.position%8)&1)
+		r.position++
+	}
+	return value
+}
+
+func TestEncode(t *testing.T) {
+	texts := map[string]string{
+		"empty":       "",
+		"secret":      "otpauth://totp/Goji:jane%20doe?algorithm=SHA1&digits=6&issuer=Goji&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"non-ASCII":   "naïve café ✓",
+		"version 10":  strings.Repeat("0123456789", 20),
+		"many blocks": strings.Repeat("The quick brown fox jumps over the lazy dog. ", 30),
+	}
+	for name, text := range texts {
+		code, err := Encode(text)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := decode(t, code); !bytes.Equal(got, []byte(text)) {
+			t.Errorf("%s: decoded %q", name, got)
+		}
+		first, second := readFormat(code)
+		if first != second {
+			t.Errorf("%s: format information copies differ, %015b and %015b", name, first, second)
+		}
+	}
+}
+
+// TestVersions checks the smallest version is chosen, by the byte capacities of level M in the standard
+func TestVersions(t *testing.T) {
+	tests := []struct {
+		length int
+		size   int
+	}{
+		{14, 21}, {15, 25}, {26, 25}, {27, 29}, {213, 57}, {214, 61}, {2331, 177},
+	}
+	for _, test := range tests {
+		code, err := Encode(strings.Repeat("a", test.length))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", test.length, err)
+		}
+		if code.Size != test.size {
+			t.Errorf("%d bytes: size %d, want %d", test.length, code.Size, test.size)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("a", 2332)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("2332 bytes: %v, want ErrTooLong", err)
+	}
+}
+
+func TestFunctionPatterns(t *testing.T) {
+	code, err := Encode(strings.Repeat("a", 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := code.Size
+
+	for _, corner := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		for dy := 0; dy < 7; dy++ {
+			for dx := 0; dx < 7; dx++ {
+				ring := max(abs(dx-3), abs(dy-3))
+				if want := ring != 2; code.Dark(corner[0]+dx, corner[1]+dy) != want {
+					t.Errorf("finder pattern at %v: module (%d, %d) is wrong", corner, dx, dy)
+				}
+			}
+		}
+	}
+	for i := 8; i < size-8; i++ {
+		if code.Dark(i, 6) != (i%2 == 0) || code.Dark(6, i) != (i%2 == 0) {
+			t.Errorf("timing pattern module %d is wrong", i)
+		}
+	}
+	if !code.Dark(8, size-8) {
+		t.Error("the dark module is light")
+	}
+
+	// Version 10 information, from the standard, in both copies
+	if size != 57 {
+		t.Fatalf("size %d, want version 10", size)
+	}
+	const versionInfo = 0x0A4D3
+	for i := 0; i < 18; i++ {
+		want := (versionInfo>>i)&1 != 0
+		a, b := size-11+i%3, i/3
+		if code.Dark(a, b) != want || code.Dark(b, a) != want {
+			t.Errorf("version information bit %d is wrong", i)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	code, err := Encode("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := code.SVG(4)
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="116" height="116" viewBox="0 0 29 29"`) {
+		t.Errorf("unexpected header in %.120s", svg)
+	}
+	// The top left module of the finder pattern, offset by the quiet zone
+	if !strings.Contains(svg, `d="M4,4h1v1h-1z`) {
+		t.Error("the top left module is missing")
+	}
+}
+
+// TestGaloisField checks the field the error correction is computed in, as decode relies on it too
+func TestGaloisField(t *testing.T) {
+	if got := gfMultiply(0x80, 0x02); got != 0x1D {
+		t.Errorf("0x80 * 0x02 = %#x, want 0x1d", got)
+	}
+	// 0x02 generates the multiplicative group, so its powers repeat only after 255 steps
+	power := byte(1)
+	for i := 1; i <= 255; i++ {
+		power = gfMultiply(power, 0x02)
+		if (power == 1) != (i == 255) {
+			t.Fatalf("0x02 to the power %d is %#x", i, power)
+		}
+	}
+}
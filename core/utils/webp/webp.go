@@ -0,0 +1,568 @@
+/*
+webp encodes images in the lossless WebP (VP8L) format without C libraries. The encoder applies the subtract
+green and predictor transforms, copies runs that repeat the pixels to their left or above, and Huffman codes
+the rest; it skips the costlier searches of libwebp, trading some compression for speed and simplicity.
+*/
+
+package webp
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	mathbits "math/bits"
+)
+
+const (
+	maxDimension    = 1 << 14
+	predictorBits   = 4
+	maxCodeLength   = 15
+	maxCodeLenBits  = 7
+	greenAlphabet   = 256 + 24
+	colorAlphabet   = 256
+	distAlphabet    = 40
+	transformSubGrn = 2
+	transformPred   = 0
+	minCopyLength   = 3
+	maxCopyLength   = 4096
+)
+
+// codeLengthOrder is the order code length code lengths are written in
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// predictorModes are the predictors each block may choose from; see predict
+var predictorModes = []uint32{1, 2, 11, 12}
+
+//////////////////////////////////
+// Public Methods               //
+//////////////////////////////////
+
+// Encode writes m to w as a lossless WebP image
+func Encode(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return errors.New("webp: image dimensions must be between 1 and 16384 pixels")
+	}
+
+	pixels, hasAlpha := argbPixels(m)
+
+	bits := &bitWriter{}
+	bits.write(0x2f, 8)
+	bits.write(uint32(width-1), 14)
+	bits.write(uint32(height-1), 14)
+	if hasAlpha {
+		bits.write(1, 1)
+	} else {
+		bits.write(0, 1)
+	}
+	bits.write(0, 3)
+
+	// Transforms are undone by the decoder in reverse order, so subtract green is applied first
+	bits.write(1, 1)
+	bits.write(transformSubGrn, 2)
+	subtractGreen(pixels)
+
+	bits.write(1, 1)
+	bits.write(transformPred, 2)
+	bits.write(predictorBits-2, 3)
+	residuals, modes := predict(pixels, width, height)
+	tilesX := (width + 1<<predictorBits - 1) >> predictorBits
+	writeImage(bits, modes, tilesX, false)
+
+	bits.write(0, 1)
+	writeImage(bits, residuals, width, true)
+
+	data := bits.bytes()
+	padding := len(data) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padding == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+//////////////////////////////////
+// Private Methods              //
+//////////////////////////////////
+
+// argbPixels returns the pixels of m as non-premultiplied ARGB, and whether any are transparent
+func argbPixels(m image.Image) ([]uint32, bool) {
+	bounds := m.Bounds()
+	pixels := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	hasAlpha := false
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			hasAlpha = hasAlpha || c.A != 0xff
+			pixels = append(pixels, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+	return pixels, hasAlpha
+}
+
+// subtractGreen subtracts the green channel from the red and blue channels of every pixel
+func subtractGreen(pixels []uint32) {
+	for i, p := range pixels {
+		green := (p >> 8) & 0xff
+		redBlue := (p & 0x00ff00ff) + 0x01000100 - (green<<16 | green)
+		pixels[i] = p&0xff00ff00 | redBlue&0x00ff00ff
+	}
+}
+
+// predict chooses a predictor for each block of the image, returning the residuals of every pixel and
+// the image of chosen predictors
+func predict(pixels []uint32, width int, height int) ([]uint32, []uint32) {
+	blockSize := 1 << predictorBits
+	tilesX := (width + blockSize - 1) / blockSize
+	tilesY := (height + blockSize - 1) / blockSize
+	modes := make([]uint32, tilesX*tilesY)
+	residuals := make([]uint32, len(pixels))
+
+	for tileY := 0; tileY < tilesY; tileY++ {
+		for tileX := 0; tileX < tilesX; tileX++ {
+			minX, minY := tileX*blockSize, tileY*blockSize
+			maxX, maxY := min(minX+blockSize, width), min(minY+blockSize, height)
+
+			best, bestCost := predictorModes[0], -1
+			for _, mode := range predictorModes {
+				cost := 0
+				for y := max(minY, 1); y < maxY; y++ {
+					for x := max(minX, 1); x < maxX; x++ {
+						i := y*width + x
+						cost += residualCost(subPixels(pixels[i], predictor(mode, pixels, i, width)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[tileY*tilesX+tileX] = 0xff000000 | best<<8
+
+			for y := minY; y < maxY; y++ {
+				for x := minX; x < maxX; x++ {
+					i := y*width + x
+					var prediction uint32
+					switch {
+					case x == 0 && y == 0:
+						prediction = 0xff000000
+					case y == 0:
+						prediction = pixels[i-1]
+					case x == 0:
+						prediction = pixels[i-width]
+					default:
+						prediction = predictor(best, pixels, i, width)
+					}
+					residuals[i] = subPixels(pixels[i], prediction)
+				}
+			}
+		}
+	}
+	return residuals, modes
+}
+
+// predictor predicts the pixel at index i from its neighbours; it is only used away from the top row and
+// left column, whose predictors are fixed
+func predictor(mode uint32, pixels []uint32, i int, width int) uint32 {
+	left, top, topLeft := pixels[i-1], pixels[i-width], pixels[i-width-1]
+	switch mode {
+	case 1:
+		return left
+	case 2:
+		return top
+	case 11:
+		return selectPredictor(left, top, topLeft)
+	default:
+		return clampAddSubtractFull(left, top, topLeft)
+	}
+}
+
+// selectPredictor returns whichever of left and top is closest to the gradient estimate left + top - topLeft
+func selectPredictor(left uint32, top uint32, topLeft uint32) uint32 {
+	distanceLeft, distanceTop := 0, 0
+	for shift := 0; shift < 32; shift += 8 {
+		l, t, tl := int(left>>shift&0xff), int(top>>shift&0xff), int(topLeft>>shift&0xff)
+		distanceLeft += abs(t - tl)
+		distanceTop += abs(l - tl)
+	}
+	if distanceLeft < distanceTop {
+		return left
+	}
+	return top
+}
+
+// clampAddSubtractFull returns left + top - topLeft, clamped to 0-255 in each channel
+func clampAddSubtractFull(left uint32, top uint32, topLeft uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		value := int(left>>shift&0xff) + int(top>>shift&0xff) - int(topLeft>>shift&0xff)
+		result |= uint32(min(max(value, 0), 255)) << shift
+	}
+	return result
+}
+
+// subPixels subtracts each channel of b from a, modulo 256
+func subPixels(a uint32, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// residualCost estimates how expensive a residual is to code; values near zero are cheapest
+func residualCost(residual uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		value := int(residual >> shift & 0xff)
+		cost += min(value, 256-value)
+	}
+	return cost
+}
+
+// writeImage writes an entropy coded image of the given width: its prefix codes followed by its pixels.
+// Only the main image declares whether it uses meta prefix codes.
+func writeImage(bits *bitWriter, pixels []uint32, width int, main bool) {
+	// No color cache
+	bits.write(0, 1)
+	if main {
+		// A single group of prefix codes for the whole image
+		bits.write(0, 1)
+	}
+
+	tokens := backwardReferences(pixels, width)
+
+	green := make([]int, greenAlphabet)
+	red := make([]int, colorAlphabet)
+	blue := make([]int, colorAlphabet)
+	alpha := make([]int, colorAlphabet)
+	distance := make([]int, distAlphabet)
+	for _, t := range tokens {
+		if t.length > 0 {
+			symbol, _, _ := lz77Prefix(t.length)
+			green[256+symbol]++
+			symbol, _, _ = lz77Prefix(t.distanceCode)
+			distance[symbol]++
+			continue
+		}
+		green[t.pixel>>8&0xff]++
+		red[t.pixel>>16&0xff]++
+		blue[t.pixel&0xff]++
+		alpha[t.pixel>>24]++
+	}
+
+	var codes [5]prefixCode
+	for i, histogram := range [][]int{green, red, blue, alpha, distance} {
+		codes[i] = writePrefixCode(bits, histogram)
+	}
+
+	for _, t := range tokens {
+		if t.length > 0 {
+			symbol, extraBits, extra := lz77Prefix(t.length)
+			codes[0].write(bits, 256+symbol)
+			bits.write(extra, extraBits)
+			symbol, extraBits, extra = lz77Prefix(t.distanceCode)
+			codes[4].write(bits, symbol)
+			bits.write(extra, extraBits)
+			continue
+		}
+		codes[0].write(bits, int(t.pixel>>8&0xff))
+		codes[1].write(bits, int(t.pixel>>16&0xff))
+		codes[2].write(bits, int(t.pixel&0xff))
+		codes[3].write(bits, int(t.pixel>>24))
+	}
+}
+
+// backwardReferences replaces runs of pixels that repeat the pixels to their left or above them with
+// references to those pixels
+func backwardReferences(pixels []uint32, width int) []token {
+	// Distance codes 1 and 2 refer to the pixel above and the pixel to the left
+	candidates := [2]struct{ distance, code int }{{1, 2}, {width, 1}}
+
+	var tokens []token
+	for i := 0; i < len(pixels); {
+		bestLength, bestCode := 0, 0
+		for _, candidate := range candidates {
+			if candidate.distance > i {
+				continue
+			}
+			length := 0
+			for i+length < len(pixels) && length < maxCopyLength && pixels[i+length] == pixels[i+length-candidate.distance] {
+				length++
+			}
+			if length > bestLength {
+				bestLength, bestCode = length, candidate.code
+			}
+		}
+
+		if bestLength >= minCopyLength {
+			tokens = append(tokens, token{length: bestLength, distanceCode: bestCode})
+			i += bestLength
+		} else {
+			tokens = append(tokens, token{pixel: pixels[i]})
+			i++
+		}
+	}
+	return tokens
+}
+
+// lz77Prefix splits a length or distance code into its prefix symbol and the extra bits that follow it
+func lz77Prefix(value int) (int, int, uint32) {
+	value--
+	if value < 4 {
+		return value, 0, 0
+	}
+	highest := mathbits.Len(uint(value)) - 1
+	second := (value >> (highest - 1)) & 1
+	extraBits := highest - 1
+	return 2*highest + second, extraBits, uint32(value & (1<<extraBits - 1))
+}
+
+// writePrefixCode writes the Huffman code best suited to the histogram, returning it for writing symbols
+func writePrefixCode(bits *bitWriter, histogram []int) prefixCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	// Up to two symbols below 256 are written as a simple code
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bits.write(1, 1)
+		bits.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bits.write(0, 1)
+			bits.write(uint32(used[0]), 1)
+		} else {
+			bits.write(1, 1)
+			bits.write(uint32(used[0]), 8)
+		}
+		lengths := make([]uint8, len(histogram))
+		if len(used) == 2 {
+			bits.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := codeLengths(histogram, maxCodeLength)
+
+	// The code lengths are themselves Huffman coded
+	lengthHistogram := make([]int, len(codeLengthOrder))
+	for _, length := range lengths {
+		lengthHistogram[length]++
+	}
+	lengthLengths := codeLengths(lengthHistogram, maxCodeLenBits)
+
+	count := 4
+	for i, symbol := range codeLengthOrder {
+		if lengthLengths[symbol] > 0 {
+			count = max(count, i+1)
+		}
+	}
+
+	bits.write(0, 1)
+	bits.write(uint32(count-4), 4)
+	for _, symbol := range codeLengthOrder[:count] {
+		bits.write(uint32(lengthLengths[symbol]), 3)
+	}
+	// Every symbol in the alphabet has a code length
+	bits.write(0, 1)
+
+	lengthCode := newPrefixCode(lengthLengths)
+	for _, length := range lengths {
+		lengthCode.write(bits, int(length))
+	}
+	return newPrefixCode(lengths)
+}
+
+// codeLengths builds the Huffman code lengths of a histogram, limited to maxLength bits. A histogram with a
+// single symbol is given a second, unused symbol so the code is complete.
+func codeLengths(histogram []int, maxLength int) []uint8 {
+	counts := append([]int(nil), histogram...)
+	for {
+		lengths, deepest := huffmanLengths(counts)
+		if deepest <= maxLength {
+			return lengths
+		}
+		// Flatten the distribution until the tree is shallow enough
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = max(count/2, 1)
+			}
+		}
+	}
+}
+
+// huffmanLengths returns the depth of each symbol in a Huffman tree built from counts, and the deepest
+func huffmanLengths(counts []int) ([]uint8, int) {
+	lengths := make([]uint8, len(counts))
+	nodes := &nodeHeap{}
+	for symbol, count := range counts {
+		if count > 0 {
+			*nodes = append(*nodes, &node{count: count, symbol: symbol})
+		}
+	}
+
+	if nodes.Len() == 1 {
+		symbol := (*nodes)[0].symbol
+		lengths[symbol] = 1
+		if symbol == 0 {
+			lengths[1] = 1
+		} else {
+			lengths[0] = 1
+		}
+		return lengths, 1
+	}
+
+	heap.Init(nodes)
+	for nodes.Len() > 1 {
+		a, b := heap.Pop(nodes).(*node), heap.Pop(nodes).(*node)
+		heap.Push(nodes, &node{count: a.count + b.count, symbol: -1, left: a, right: b})
+	}
+
+	deepest := 0
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = uint8(depth)
+			deepest = max(deepest, depth)
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	if nodes.Len() == 1 {
+		walk((*nodes)[0], 0)
+	}
+	return lengths, deepest
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+//////////////////////////////////
+// Types                        //
+//////////////////////////////////
+
+// prefixCode is a canonical Huffman code
+type prefixCode struct {
+	codes   []uint32
+	lengths []uint8
+}
+
+// newPrefixCode assigns canonical codes to symbols from their code lengths
+func newPrefixCode(lengths []uint8) prefixCode {
+	var lengthCounts [maxCodeLength + 1]uint32
+	for _, length := range lengths {
+		lengthCounts[length]++
+	}
+	lengthCounts[0] = 0
+
+	var next [maxCodeLength + 2]uint32
+	code := uint32(0)
+	for length := 1; length <= maxCodeLength; length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		next[length] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			codes[symbol] = next[length]
+			next[length]++
+		}
+	}
+	return prefixCode{codes: codes, lengths: lengths}
+}
+
+// write writes the code of a symbol; codes are written from their most significant bit
+func (p prefixCode) write(bits *bitWriter, symbol int) {
+	length := p.lengths[symbol]
+	code := p.codes[symbol]
+	reversed := uint32(0)
+	for i := uint8(0); i < length; i++ {
+		reversed = reversed<<1 | code>>i&1
+	}
+	bits.write(reversed, int(length))
+}
+
+// token is either a literal pixel or, when length is set, a copy of earlier pixels
+type token struct {
+	pixel        uint32
+	length       int
+	distanceCode int
+}
+
+type node struct {
+	count  int
+	symbol int
+	left   *node
+	right  *node
+}
+
+// nodeHeap orders Huffman tree nodes by count, breaking ties by symbol so trees are deterministic
+type nodeHeap []*node
+
+func (h nodeHeap) Len() int { return len(h) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h nodeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x any)   { *h = append(*h, x.(*node)) }
+func (h *nodeHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// bitWriter packs values into bytes, least significant bit first
+type bitWriter struct {
+	buffer []byte
+	bits   uint64
+	count  int
+}
+
+func (w *bitWriter) write(value uint32, count int) {
+	w.bits |= uint64(value) << w.count
+	w.count += count
+	for w.count >= 8 {
+		w.buffer = append(w.buffer, byte(w.bits))
+		w.bits >>= 8
+		w.count -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.count > 0 {
+		w.buffer = append(w.buffer, byte(w.bits))
+		w.bits, w.count = 0, 0
+	}
+	return w.buffer
+}
@@ -0,0 +1,117 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImages returns images exercising each part of the encoder: smooth gradients for the predictors,
+// repeated rows and runs for backward references, noise for large Huffman alphabets and transparency
+func testImages() map[string]image.Image {
+	images := map[string]image.Image{}
+	random := rand.New(rand.NewSource(1))
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 97, 61))
+	for y := 0; y < 61; y++ {
+		for x := 0; x < 97; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 2), G: uint8(y * 4), B: uint8(x + y), A: 0xff})
+		}
+	}
+	images["gradient"] = gradient
+
+	noise := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	random.Read(noise.Pix)
+	images["noise with alpha"] = noise
+
+	stripes := image.NewNRGBA(image.Rect(0, 0, 300, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 300; x++ {
+			stripes.SetNRGBA(x, y, color.NRGBA{R: uint8(x / 7 * 40), G: 0x80, B: uint8(y % 3 * 90), A: 0xff})
+		}
+	}
+	images["stripes"] = stripes
+
+	translucent := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	for y := 0; y < 17; y++ {
+		for x := 0; x < 33; x++ {
+			translucent.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: uint8(x * 7), B: 0x10, A: uint8(y * 15)})
+		}
+	}
+	images["translucent"] = translucent
+
+	solid := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0xff
+	}
+	images["solid"] = solid
+	images["single pixel"] = image.NewNRGBA(image.Rect(0, 0, 1, 1))
+
+	gray := image.NewGray(image.Rect(5, 5, 45, 25))
+	random.Read(gray.Pix)
+	images["offset gray"] = gray
+
+	return images
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, m := range testImages() {
+		var encoded bytes.Buffer
+		if err := Encode(&encoded, m); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		decoded, err := webp.Decode(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Errorf("%s: decoding failed: %v", name, err)
+			continue
+		}
+
+		bounds := m.Bounds()
+		if decoded.Bounds().Dx() != bounds.Dx() || decoded.Bounds().Dy() != bounds.Dy() {
+			t.Errorf("%s: decoded %v, want %v", name, decoded.Bounds().Size(), bounds.Size())
+			continue
+		}
+	compare:
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				want := color.NRGBAModel.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y))
+				got := color.NRGBAModel.Convert(decoded.At(decoded.Bounds().Min.X+x, decoded.Bounds().Min.Y+y))
+				// The colour of fully transparent pixels is not kept
+				if want.(color.NRGBA).A == 0 && got.(color.NRGBA).A == 0 {
+					continue
+				}
+				if got != want {
+					t.Errorf("%s: pixel (%d, %d) is %v, want %v", name, x, y, got, want)
+					break compare
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeDimensions(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := Encode(&encoded, image.NewNRGBA(image.Rect(0, 0, 0, 10))); err == nil {
+		t.Error("expected an empty image to be rejected")
+	}
+	if err := Encode(&encoded, image.NewNRGBA(image.Rect(0, 0, maxDimension+1, 1))); err == nil {
+		t.Error("expected an image wider than 16384 pixels to be rejected")
+	}
+
+	encoded.Reset()
+	if err := Encode(&encoded, image.NewNRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	config, err := webp.DecodeConfig(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 10 || config.Height != 10 {
+		t.Errorf("header gives %dx%d, want 10x10", config.Width, config.Height)
+	}
+}